@@ -0,0 +1,406 @@
+// Package aggregator maintains OHLCV candles and rolling market statistics
+// of orderbook trades. Prices and volumes are fixed-point uint64 values with
+// orderbook.DefaultPrecision decimal places, like in the orderbook itself.
+package aggregator
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+
+	orderbook "github.com/actpohabtNS/hackademy/courses/golang/ex11-orderbook"
+)
+
+// Intervals are candle intervals used by New.
+var Intervals = []time.Duration{
+	time.Second,
+	time.Minute,
+	5 * time.Minute,
+	time.Hour,
+}
+
+// Window is period of rolling statistics, which are kept with one-minute
+// granularity.
+const Window = 24 * time.Hour
+
+// DefaultHistory is number of candles kept per interval by New.
+const DefaultHistory = 1440
+
+const bucket = time.Minute
+
+// precision converts between fixed-point notional and prices, instrument
+// without its own precision uses orderbook.DefaultPrecision.
+var precision = orderbook.Instrument{}
+
+// Candle aggregates trades executed during interval starting at Start.
+// Notional is sum of price multiplied by volume of every trade, expressed
+// with the same precision.
+type Candle struct {
+	Start time.Time `json:"start"`
+
+	Open  uint64 `json:"open"`
+	High  uint64 `json:"high"`
+	Low   uint64 `json:"low"`
+	Close uint64 `json:"close"`
+
+	Volume   uint64 `json:"volume"`
+	Notional uint64 `json:"notional"`
+	Trades   uint64 `json:"trades"`
+}
+
+// VWAP returns volume-weighted average price, rounded down.
+func (candle *Candle) VWAP() uint64 {
+	return vwap(candle.Notional, candle.Volume)
+}
+
+func (candle *Candle) add(volume uint64, price uint64, notional uint64) {
+	if candle.Trades == 0 {
+		candle.Open = price
+		candle.High = price
+		candle.Low = price
+	}
+
+	if price > candle.High {
+		candle.High = price
+	}
+
+	if price < candle.Low {
+		candle.Low = price
+	}
+
+	candle.Close = price
+	candle.Volume = saturating(candle.Volume, volume)
+	candle.Notional = saturating(candle.Notional, notional)
+	candle.Trades++
+}
+
+// Stats are rolling statistics of trades during Window before some moment.
+// Last is price of the last trade ever, even if it's older than the window.
+type Stats struct {
+	Last uint64
+
+	High     uint64
+	Low      uint64
+	Volume   uint64
+	Notional uint64
+	Trades   uint64
+}
+
+// VWAP returns volume-weighted average price of the window, rounded down.
+func (stats *Stats) VWAP() uint64 {
+	return vwap(stats.Notional, stats.Volume)
+}
+
+type series struct {
+	Interval time.Duration `json:"interval"`
+	Candles  []Candle      `json:"candles"`
+}
+
+// add accounts trade in candle of its time. Time doesn't go backwards:
+// trade older than the current candle is accounted in it.
+func (series *series) add(
+	at time.Time,
+	volume uint64,
+	price uint64,
+	notional uint64,
+	history int,
+) {
+	start := at.Truncate(series.Interval)
+
+	count := len(series.Candles)
+	if count == 0 || series.Candles[count-1].Start.Before(start) {
+		if history > 0 && count >= history {
+			series.Candles = append(series.Candles[:0], series.Candles[1:]...)
+		}
+
+		series.Candles = append(series.Candles, Candle{Start: start})
+	}
+
+	series.Candles[len(series.Candles)-1].add(volume, price, notional)
+}
+
+// Aggregator maintains candles of every interval and rolling statistics.
+// It's safe for concurrent use, so series can be read while trades are
+// added from the goroutine which calls the book.
+type Aggregator struct {
+	mutex sync.RWMutex
+
+	history int
+	series  []series
+	rolling series
+
+	// sequence is number of trades added so far, it identifies trades in
+	// journal.
+	sequence uint64
+	last     uint64
+
+	journal *Journal
+}
+
+// New returns aggregator of Intervals keeping DefaultHistory candles each.
+func New() *Aggregator {
+	return NewIntervals(Intervals, DefaultHistory)
+}
+
+// NewIntervals returns aggregator of specified intervals keeping history
+// candles each.
+func NewIntervals(intervals []time.Duration, history int) *Aggregator {
+	aggregator := &Aggregator{
+		history: history,
+		rolling: series{Interval: bucket},
+	}
+
+	for _, interval := range intervals {
+		aggregator.series = append(aggregator.series, series{Interval: interval})
+	}
+
+	return aggregator
+}
+
+// Journal makes aggregator append every trade to journal before applying
+// it, so aggregator can be resumed with Resume.
+func (aggregator *Aggregator) Journal(journal *Journal) {
+	aggregator.mutex.Lock()
+	defer aggregator.mutex.Unlock()
+
+	aggregator.journal = journal
+}
+
+// Add accounts trade executed at specified time. It returns error only if
+// trade can't be written to journal, in which case it's not accounted.
+func (aggregator *Aggregator) Add(at time.Time, volume uint64, price uint64) error {
+	aggregator.mutex.Lock()
+	defer aggregator.mutex.Unlock()
+
+	if aggregator.journal != nil {
+		err := aggregator.journal.append(Record{
+			Sequence: aggregator.sequence + 1,
+			Time:     at,
+			Volume:   volume,
+			Price:    price,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	aggregator.apply(at, volume, price)
+
+	return nil
+}
+
+// AddTrades accounts trades returned by single Match call. Self-trade
+// prevention records are skipped, as no exchange took place.
+func (aggregator *Aggregator) AddTrades(at time.Time, trades []*orderbook.Trade) error {
+	for _, trade := range trades {
+		if trade.SelfTrade != orderbook.SelfTradeAllow {
+			continue
+		}
+
+		if err := aggregator.Add(at, trade.Volume, trade.Price); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Listener returns book listener which accounts every trade at time
+// returned by clock. Each trade fills both bid and ask, so only fills of
+// bids are counted. Listener can't report journal errors, use AddTrades if
+// they must be handled.
+func (aggregator *Aggregator) Listener(clock func() time.Time) orderbook.Listener {
+	return listener{
+		aggregator: aggregator,
+		clock:      clock,
+	}
+}
+
+type listener struct {
+	aggregator *Aggregator
+	clock      func() time.Time
+}
+
+func (listener listener) OnEvent(event orderbook.Event) {
+	if event.Side != orderbook.SideBid || event.LastVolume == 0 {
+		return
+	}
+
+	listener.aggregator.Add(listener.clock(), event.LastVolume, event.LastPrice)
+}
+
+func (aggregator *Aggregator) apply(at time.Time, volume uint64, price uint64) {
+	notional := precision.Notional(price, volume)
+
+	for i := range aggregator.series {
+		aggregator.series[i].add(at, volume, price, notional, aggregator.history)
+	}
+
+	aggregator.rolling.add(at, volume, price, notional, int(Window/bucket))
+
+	aggregator.sequence++
+	aggregator.last = price
+}
+
+// Sequence returns number of trades accounted so far.
+func (aggregator *Aggregator) Sequence() uint64 {
+	aggregator.mutex.RLock()
+	defer aggregator.mutex.RUnlock()
+
+	return aggregator.sequence
+}
+
+// Candles returns copy of candles of interval from the oldest to the
+// current one. Intervals without trades have no candles. It returns nil if
+// aggregator doesn't maintain such interval.
+func (aggregator *Aggregator) Candles(interval time.Duration) []Candle {
+	aggregator.mutex.RLock()
+	defer aggregator.mutex.RUnlock()
+
+	for _, series := range aggregator.series {
+		if series.Interval == interval {
+			return append([]Candle{}, series.Candles...)
+		}
+	}
+
+	return nil
+}
+
+// Stats returns rolling statistics of Window ending at specified time.
+func (aggregator *Aggregator) Stats(at time.Time) Stats {
+	aggregator.mutex.RLock()
+	defer aggregator.mutex.RUnlock()
+
+	stats := Stats{Last: aggregator.last}
+	since := at.Add(-Window)
+
+	for i := range aggregator.rolling.Candles {
+		candle := &aggregator.rolling.Candles[i]
+
+		if !candle.Start.Add(bucket).After(since) || candle.Start.After(at) {
+			continue
+		}
+
+		if stats.Trades == 0 || candle.High > stats.High {
+			stats.High = candle.High
+		}
+
+		if stats.Trades == 0 || candle.Low < stats.Low {
+			stats.Low = candle.Low
+		}
+
+		stats.Volume = saturating(stats.Volume, candle.Volume)
+		stats.Notional = saturating(stats.Notional, candle.Notional)
+		stats.Trades += candle.Trades
+	}
+
+	return stats
+}
+
+type snapshot struct {
+	Sequence uint64   `json:"sequence"`
+	Last     uint64   `json:"last"`
+	History  int      `json:"history"`
+	Series   []series `json:"series"`
+	Rolling  series   `json:"rolling"`
+}
+
+// Snapshot writes complete state of aggregator as JSON.
+func (aggregator *Aggregator) Snapshot(writer io.Writer) error {
+	aggregator.mutex.RLock()
+	defer aggregator.mutex.RUnlock()
+
+	return json.NewEncoder(writer).Encode(snapshot{
+		Sequence: aggregator.sequence,
+		Last:     aggregator.last,
+		History:  aggregator.history,
+		Series:   aggregator.series,
+		Rolling:  aggregator.rolling,
+	})
+}
+
+// Restore rebuilds aggregator from snapshot written by Snapshot.
+func Restore(reader io.Reader) (*Aggregator, error) {
+	var state snapshot
+
+	if err := json.NewDecoder(reader).Decode(&state); err != nil {
+		return nil, err
+	}
+
+	aggregator := NewIntervals(nil, state.History)
+
+	aggregator.sequence = state.Sequence
+	aggregator.last = state.Last
+	aggregator.series = state.Series
+	aggregator.rolling = state.Rolling
+	aggregator.rolling.Interval = bucket
+
+	return aggregator, nil
+}
+
+// Resume restores aggregator from snapshot, if any, and then applies every
+// trade of journal which is newer than the snapshot.
+func Resume(snapshot io.Reader, journal io.Reader) (*Aggregator, error) {
+	aggregator := New()
+
+	if snapshot != nil {
+		restored, err := Restore(snapshot)
+		if err != nil {
+			return nil, err
+		}
+
+		aggregator = restored
+	}
+
+	reader := NewJournalReader(journal)
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return aggregator, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if record.Sequence <= aggregator.sequence {
+			continue
+		}
+
+		if record.Sequence != aggregator.sequence+1 {
+			return nil, ErrCorruptJournal
+		}
+
+		aggregator.apply(record.Time, record.Volume, record.Price)
+	}
+}
+
+// vwap divides notional by volume with precision of prices.
+func vwap(notional uint64, volume uint64) uint64 {
+	if volume == 0 {
+		return 0
+	}
+
+	hi, lo := bits.Mul64(notional, precision.Scale())
+	if hi >= volume {
+		return math.MaxUint64
+	}
+
+	price, _ := bits.Div64(hi, lo, volume)
+
+	return price
+}
+
+// saturating returns sum of a and b, or math.MaxUint64 if it overflows.
+func saturating(a uint64, b uint64) uint64 {
+	sum, carry := bits.Add64(a, b, 0)
+	if carry != 0 {
+		return math.MaxUint64
+	}
+
+	return sum
+}
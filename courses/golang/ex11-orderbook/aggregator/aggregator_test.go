@@ -0,0 +1,236 @@
+package aggregator
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	orderbook "github.com/actpohabtNS/hackademy/courses/golang/ex11-orderbook"
+)
+
+var epoch = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestAggregator_Candles(t *testing.T) {
+	test := assert.New(t)
+
+	aggregator := New()
+
+	aggregator.Add(epoch.Add(100*time.Millisecond), 10000, 60000000)
+	aggregator.Add(epoch.Add(900*time.Millisecond), 30000, 60200000)
+	aggregator.Add(epoch.Add(1500*time.Millisecond), 10000, 59900000)
+	aggregator.Add(epoch.Add(61*time.Second), 20000, 60100000)
+	aggregator.Add(epoch.Add(30*time.Second), 10000, 60000000)
+
+	test.Equal(
+		[]Candle{
+			{
+				Start:    epoch,
+				Open:     60000000,
+				High:     60200000,
+				Low:      60000000,
+				Close:    60200000,
+				Volume:   40000,
+				Notional: 240600000,
+				Trades:   2,
+			},
+			{
+				Start:    epoch.Add(time.Second),
+				Open:     59900000,
+				High:     59900000,
+				Low:      59900000,
+				Close:    59900000,
+				Volume:   10000,
+				Notional: 59900000,
+				Trades:   1,
+			},
+			{
+				Start:    epoch.Add(time.Minute + time.Second),
+				Open:     60100000,
+				High:     60100000,
+				Low:      60000000,
+				Close:    60000000,
+				Volume:   30000,
+				Notional: 180200000,
+				Trades:   2,
+			},
+		},
+		aggregator.Candles(time.Second),
+		"late trade is accounted in the current candle",
+	)
+
+	minutes := aggregator.Candles(time.Minute)
+
+	if test.Len(minutes, 2) {
+		test.Equal(uint64(50000), minutes[0].Volume)
+		test.Equal(uint64(60100000), minutes[0].VWAP())
+		test.Equal(uint64(60066666), minutes[1].VWAP())
+	}
+
+	test.Len(aggregator.Candles(time.Hour), 1)
+	test.Nil(aggregator.Candles(time.Hour * 4))
+	test.Equal(uint64(5), aggregator.Sequence())
+}
+
+func TestAggregator_History(t *testing.T) {
+	test := assert.New(t)
+
+	aggregator := NewIntervals([]time.Duration{time.Second}, 3)
+
+	for i := 0; i < 5; i++ {
+		aggregator.Add(epoch.Add(time.Duration(i)*time.Second), 10000, uint64(i+1)*10000)
+	}
+
+	candles := aggregator.Candles(time.Second)
+
+	if test.Len(candles, 3) {
+		test.Equal(epoch.Add(2*time.Second), candles[0].Start)
+		test.Equal(uint64(50000), candles[2].Close)
+	}
+}
+
+func TestAggregator_Stats(t *testing.T) {
+	test := assert.New(t)
+
+	aggregator := New()
+
+	aggregator.Add(epoch, 10000, 70000000)
+	aggregator.Add(epoch.Add(time.Hour), 10000, 50000000)
+	aggregator.Add(epoch.Add(23*time.Hour), 20000, 60000000)
+	aggregator.Add(epoch.Add(24*time.Hour+30*time.Second), 10000, 65000000)
+
+	test.Equal(
+		Stats{
+			Last:     65000000,
+			High:     65000000,
+			Low:      50000000,
+			Volume:   40000,
+			Notional: 235000000,
+			Trades:   3,
+		},
+		aggregator.Stats(epoch.Add(24*time.Hour+time.Minute)),
+	)
+
+	stats := aggregator.Stats(epoch.Add(49 * time.Hour))
+	test.Equal(Stats{Last: 65000000}, stats)
+	test.Equal(uint64(0), stats.VWAP())
+
+	stats = aggregator.Stats(epoch.Add(23 * time.Hour))
+	test.Equal(uint64(40000), stats.Volume, "later trades are excluded")
+	test.Equal(uint64(60000000), stats.VWAP())
+
+	// Volume saturates instead of wrapping around.
+	aggregator = New()
+
+	aggregator.Add(epoch, math.MaxUint64-1, 1)
+	aggregator.Add(epoch.Add(time.Minute), 2, 1)
+	aggregator.Add(epoch.Add(2*time.Minute), 2, 1)
+
+	stats = aggregator.Stats(epoch.Add(3 * time.Minute))
+	test.Equal(uint64(math.MaxUint64), stats.Volume)
+
+	candles := aggregator.Candles(time.Hour)
+	if test.Len(candles, 1) {
+		test.Equal(uint64(math.MaxUint64), candles[0].Volume)
+	}
+}
+
+func TestAggregator_Listener(t *testing.T) {
+	test := assert.New(t)
+
+	aggregator := New()
+	clock := func() time.Time { return epoch }
+
+	book := orderbook.New(orderbook.WithListener(aggregator.Listener(clock)))
+
+	book.Match(&orderbook.Order{ID: 1, Account: 1, Side: orderbook.SideAsk, Kind: orderbook.KindLimit, Volume: 10000, Price: 60000000})
+	book.Match(&orderbook.Order{ID: 2, Account: 2, Side: orderbook.SideAsk, Kind: orderbook.KindLimit, Volume: 10000, Price: 60100000})
+	book.Match(&orderbook.Order{
+		ID:        3,
+		Account:   1,
+		Side:      orderbook.SideBid,
+		Kind:      orderbook.KindMarket,
+		Volume:    15000,
+		SelfTrade: orderbook.SelfTradeCancelOldest,
+	})
+
+	candles := aggregator.Candles(time.Minute)
+
+	if test.Len(candles, 1) {
+		test.Equal(uint64(10000), candles[0].Volume, "self-trade prevention is not trade")
+		test.Equal(uint64(60100000), candles[0].Close)
+		test.Equal(uint64(1), candles[0].Trades)
+	}
+}
+
+func TestAggregator_Resume(t *testing.T) {
+	test := assert.New(t)
+
+	journal := &bytes.Buffer{}
+	snapshot := &bytes.Buffer{}
+
+	aggregator := New()
+	aggregator.Journal(NewJournal(journal))
+
+	trades := []*orderbook.Trade{
+		{Volume: 10000, Price: 60000000},
+		{Volume: 20000, Price: 60100000, SelfTrade: orderbook.SelfTradeDecrement},
+		{Volume: 30000, Price: 59900000},
+	}
+
+	test.NoError(aggregator.AddTrades(epoch, trades))
+	test.NoError(aggregator.Snapshot(snapshot))
+	test.NoError(aggregator.AddTrades(epoch.Add(90*time.Second), trades))
+	test.NoError(aggregator.Add(epoch.Add(2*time.Hour), 10000, 61000000))
+
+	test.Equal(uint64(5), aggregator.Sequence())
+
+	// Torn record at the end of journal is ignored.
+	complete := journal.Len()
+	journal.Write(make([]byte, recordSize/2))
+
+	for _, state := range []*bytes.Buffer{bytes.NewBuffer(snapshot.Bytes()), nil} {
+		var (
+			resumed *Aggregator
+			err     error
+		)
+
+		if state == nil {
+			resumed, err = Resume(nil, bytes.NewReader(journal.Bytes()))
+		} else {
+			resumed, err = Resume(state, bytes.NewReader(journal.Bytes()))
+		}
+
+		if !test.NoError(err) {
+			continue
+		}
+
+		test.Equal(aggregator.Sequence(), resumed.Sequence())
+
+		for _, interval := range Intervals {
+			expected := aggregator.Candles(interval)
+			actual := resumed.Candles(interval)
+
+			if test.Len(actual, len(expected)) {
+				for i := range expected {
+					test.True(expected[i].Start.Equal(actual[i].Start))
+
+					expected[i].Start = actual[i].Start
+				}
+
+				test.Equal(expected, actual, interval.String())
+			}
+		}
+
+		at := epoch.Add(3 * time.Hour)
+		test.Equal(aggregator.Stats(at), resumed.Stats(at))
+	}
+
+	corrupt := append([]byte{}, journal.Bytes()[:complete]...)
+	corrupt[recordSize+10] ^= 0xff
+
+	_, err := Resume(nil, bytes.NewReader(corrupt))
+	test.ErrorIs(err, ErrCorruptJournal)
+}
@@ -0,0 +1,100 @@
+package aggregator
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// Journal record layout:
+//
+//	sequence: 8 bytes
+//	time:     8 bytes, Unix time in nanoseconds
+//	volume:   8 bytes
+//	price:    8 bytes
+//	checksum: 4 bytes, CRC-32 (IEEE) of the fields above
+//
+// All integers are big endian.
+const recordSize = 36
+
+// ErrCorruptJournal is returned by JournalReader for complete record which
+// doesn't match its checksum.
+var ErrCorruptJournal = errors.New("corrupt trade journal")
+
+// Record is single trade of journal. Sequence numbers trades of aggregator
+// starting from 1.
+type Record struct {
+	Sequence uint64
+	Time     time.Time
+	Volume   uint64
+	Price    uint64
+}
+
+// Journal appends trades of aggregator to underlying writer. Each record is
+// written with single Write call.
+type Journal struct {
+	writer io.Writer
+	buffer [recordSize]byte
+}
+
+// NewJournal returns journal appending to writer, e.g. file opened with
+// O_APPEND.
+func NewJournal(writer io.Writer) *Journal {
+	return &Journal{
+		writer: writer,
+	}
+}
+
+func (journal *Journal) append(record Record) error {
+	buffer := journal.buffer[:]
+
+	binary.BigEndian.PutUint64(buffer[0:], record.Sequence)
+	binary.BigEndian.PutUint64(buffer[8:], uint64(record.Time.UnixNano()))
+	binary.BigEndian.PutUint64(buffer[16:], record.Volume)
+	binary.BigEndian.PutUint64(buffer[24:], record.Price)
+	binary.BigEndian.PutUint32(buffer[32:], crc32.ChecksumIEEE(buffer[:32]))
+
+	_, err := journal.writer.Write(buffer)
+
+	return err
+}
+
+// JournalReader reads trades written by Journal in order.
+type JournalReader struct {
+	reader io.Reader
+	buffer [recordSize]byte
+}
+
+func NewJournalReader(reader io.Reader) *JournalReader {
+	return &JournalReader{
+		reader: reader,
+	}
+}
+
+// Read returns the next record, or io.EOF at the end of journal. Record
+// torn by crash at the end of journal is treated as the end.
+func (reader *JournalReader) Read() (Record, error) {
+	buffer := reader.buffer[:]
+
+	_, err := io.ReadFull(reader.reader, buffer)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return Record{}, io.EOF
+	}
+
+	if err != nil {
+		return Record{}, err
+	}
+
+	if binary.BigEndian.Uint32(buffer[32:]) != crc32.ChecksumIEEE(buffer[:32]) {
+		return Record{}, ErrCorruptJournal
+	}
+
+	return Record{
+		Sequence: binary.BigEndian.Uint64(buffer[0:]),
+		Time:     time.Unix(0, int64(binary.BigEndian.Uint64(buffer[8:]))),
+		Volume:   binary.BigEndian.Uint64(buffer[16:]),
+		Price:    binary.BigEndian.Uint64(buffer[24:]),
+	}, nil
+}
@@ -0,0 +1,214 @@
+package orderbook
+
+import "math/bits"
+
+// Allocation is share of incoming order volume given to single resting order
+// of price level.
+type Allocation struct {
+	Order *Order
+
+	// Available is displayed volume of resting order.
+	Available uint64
+
+	// Volume is allocated volume, it's zero when allocator is called.
+	Volume uint64
+}
+
+// Allocator distributes volume of incoming order among resting orders of the
+// best price level, which are passed in time priority. It must allocate
+// exactly the smaller of volume and total available volume, otherwise
+// remaining volume is allocated in time priority.
+type Allocator interface {
+	Allocate(volume uint64, allocations []Allocation)
+}
+
+// FIFO allocates volume in strict time priority.
+type FIFO struct{}
+
+func (FIFO) Allocate(volume uint64, allocations []Allocation) {
+	fifo(volume, allocations)
+}
+
+// ProRata allocates volume in proportion to available volume of resting
+// orders. Shares smaller than Minimum are not allocated, and volume left
+// after rounding is allocated in time priority.
+type ProRata struct {
+	Minimum uint64
+}
+
+func (allocator ProRata) Allocate(volume uint64, allocations []Allocation) {
+	prorata(volume, allocator.Minimum, allocations)
+}
+
+// Hybrid gives the oldest resting order of the level top order priority: it
+// is filled first up to TopOrder volume (without limit if zero), and the rest
+// is allocated pro-rata among every order of the level.
+type Hybrid struct {
+	TopOrder uint64
+	Minimum  uint64
+}
+
+func (allocator Hybrid) Allocate(volume uint64, allocations []Allocation) {
+	if len(allocations) == 0 {
+		return
+	}
+
+	top := &allocations[0]
+
+	priority := top.Available
+	if allocator.TopOrder != 0 && allocator.TopOrder < priority {
+		priority = allocator.TopOrder
+	}
+
+	if volume < priority {
+		priority = volume
+	}
+
+	top.Volume += priority
+
+	prorata(volume-priority, allocator.Minimum, allocations)
+}
+
+// Option configures book created by New.
+type Option func(*Orderbook)
+
+// WithAllocator sets allocation of incoming order volume among resting orders
+// of the same price level. Book allocates in time priority by default.
+func WithAllocator(allocator Allocator) Option {
+	return func(orderbook *Orderbook) {
+		orderbook.allocator = allocator
+	}
+}
+
+// fifo adds volume to allocations in time priority, up to their remaining
+// available volume.
+func fifo(volume uint64, allocations []Allocation) {
+	for i := range allocations {
+		if volume == 0 {
+			return
+		}
+
+		allocation := &allocations[i]
+
+		share := allocation.Available - allocation.Volume
+		if volume < share {
+			share = volume
+		}
+
+		allocation.Volume += share
+		volume -= share
+	}
+}
+
+// prorata adds volume to allocations in proportion to their remaining
+// available volume.
+func prorata(volume uint64, minimum uint64, allocations []Allocation) {
+	var total uint64
+
+	for i := range allocations {
+		total += allocations[i].Available - allocations[i].Volume
+	}
+
+	if total == 0 {
+		return
+	}
+
+	if volume > total {
+		volume = total
+	}
+
+	remaining := volume
+
+	for i := range allocations {
+		allocation := &allocations[i]
+
+		// volume * available / total can't overflow the quotient, since
+		// available doesn't exceed total.
+		high, low := bits.Mul64(volume, allocation.Available-allocation.Volume)
+		share, _ := bits.Div64(high, low, total)
+
+		if share == 0 || share < minimum {
+			continue
+		}
+
+		allocation.Volume += share
+		remaining -= share
+	}
+
+	fifo(remaining, allocations)
+}
+
+// allocate matches incoming order against every resting order of the level
+// according to allocator of the book. Icebergs whose displayed volume is
+// consumed are replenished, so matching continues at the same level.
+func (orderbook *Orderbook) allocate(
+	trades []Trade,
+	start int,
+	order *Order,
+	level *level,
+) []Trade {
+	allocations := orderbook.allocations[:0]
+
+	for resting := level.head; resting != nil; resting = resting.next {
+		allocations = append(allocations, Allocation{
+			Order:     resting,
+			Available: resting.visible,
+		})
+	}
+
+	orderbook.allocator.Allocate(order.Volume, allocations)
+
+	var total uint64
+
+	for i := range allocations {
+		allocation := &allocations[i]
+
+		if allocation.Volume > allocation.Available {
+			allocation.Volume = allocation.Available
+		}
+
+		if total+allocation.Volume > order.Volume {
+			allocation.Volume = order.Volume - total
+		}
+
+		total += allocation.Volume
+	}
+
+	if total < order.Volume {
+		fifo(order.Volume-total, allocations)
+	}
+
+	for i := range allocations {
+		if allocations[i].Volume > 0 {
+			trades = orderbook.fill(
+				trades,
+				start,
+				order,
+				allocations[i].Order,
+				allocations[i].Volume,
+			)
+		}
+
+		allocations[i] = Allocation{}
+	}
+
+	orderbook.allocations = allocations[:0]
+
+	return trades
+}
+
+// conflict returns resting order of the level which incoming order can't
+// trade with.
+func (orderbook *Orderbook) conflict(order *Order, level *level) *Order {
+	if order.SelfTrade == SelfTradeAllow || order.Account == 0 {
+		return nil
+	}
+
+	for resting := level.head; resting != nil; resting = resting.next {
+		if order.prevents(resting) {
+			return resting
+		}
+	}
+
+	return nil
+}
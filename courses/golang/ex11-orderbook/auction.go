@@ -0,0 +1,340 @@
+package orderbook
+
+import "sort"
+
+// auction holds state of call phase. Limit orders rest in the book without
+// matching, so the book may become crossed, while market orders are queued
+// separately and have priority over any limit order at uncross.
+type auction struct {
+	active    bool
+	reference uint64
+
+	bids []*Order
+	asks []*Order
+}
+
+func (auction *auction) queue(side Side) *[]*Order {
+	if side == SideBid {
+		return &auction.bids
+	}
+
+	return &auction.asks
+}
+
+func (auction *auction) remove(order *Order) {
+	queue := auction.queue(order.Side)
+
+	for i, queued := range *queue {
+		if queued == order {
+			*queue = append((*queue)[:i], (*queue)[i+1:]...)
+
+			return
+		}
+	}
+}
+
+// BeginAuction switches book to call phase: incoming orders are accumulated
+// without matching until Uncross. Reference price breaks ties between
+// clearing prices, zero means last trade price.
+func (orderbook *Orderbook) BeginAuction(reference uint64) {
+	orderbook.recycle()
+
+	if reference == 0 {
+		reference = orderbook.last
+	}
+
+	orderbook.auction.active = true
+	orderbook.auction.reference = reference
+}
+
+// Auction reports whether book is in call phase.
+func (orderbook *Orderbook) Auction() bool {
+	return orderbook.auction.active
+}
+
+// Indicative returns price and volume at which the book would be uncrossed
+// right now. Both are zero if there is nothing to execute.
+func (orderbook *Orderbook) Indicative() (uint64, uint64) {
+	return orderbook.clearing()
+}
+
+// Uncross executes every order of call phase which can be executed at single
+// clearing price and returns book to continuous matching. Clearing price
+// maximizes executed volume, ties are broken by minimum imbalance between
+// demand and supply and then by distance to reference price, preferring the
+// lower price. Market orders which can't be executed are rejected. Stop
+// orders triggered by clearing price are matched afterwards.
+func (orderbook *Orderbook) Uncross() ([]*Trade, []*Order) {
+	orderbook.recycle()
+
+	if !orderbook.auction.active {
+		return nil, nil
+	}
+
+	price, volume := orderbook.clearing()
+
+	bids := orderbook.crossing(SideBid, price, volume)
+	asks := orderbook.crossing(SideAsk, price, volume)
+
+	var (
+		trades  []Trade
+		rejects []*Order
+		drained []*Order
+	)
+
+	for len(bids) > 0 && len(asks) > 0 && volume > 0 {
+		bid, ask := bids[0], asks[0]
+
+		amount := volume
+		if bid.Volume < amount {
+			amount = bid.Volume
+		}
+
+		if ask.Volume < amount {
+			amount = ask.Volume
+		}
+
+		bidHidden := orderbook.consume(bid, amount)
+		askHidden := orderbook.consume(ask, amount)
+
+		orderbook.executed(bid, amount, price)
+		orderbook.executed(ask, amount, price)
+
+		trades = append(trades, Trade{
+			Bid:       bid,
+			Ask:       ask,
+			Volume:    amount,
+			Price:     price,
+			BidHidden: bidHidden,
+			AskHidden: askHidden,
+		})
+
+		volume -= amount
+
+		for _, order := range []*Order{bid, ask} {
+			if order.level != nil && order.visible == 0 && order.Volume > 0 {
+				drained = append(drained, order)
+			}
+		}
+
+		if bid.Volume == 0 {
+			bids = bids[1:]
+		}
+
+		if ask.Volume == 0 {
+			asks = asks[1:]
+		}
+	}
+
+	for _, iceberg := range drained {
+		if iceberg.level != nil {
+			orderbook.replenish(iceberg)
+		}
+	}
+
+	for _, iceberg := range orderbook.refreshed {
+		iceberg.refreshed = false
+	}
+
+	orderbook.refreshed = orderbook.refreshed[:0]
+
+	for _, side := range []Side{SideBid, SideAsk} {
+		for _, order := range *orderbook.auction.queue(side) {
+			delete(orderbook.orders, order.ID)
+
+			if order.Volume == 0 {
+				orderbook.retire(order)
+			} else {
+				rejects = append(rejects, orderbook.reject(order, ReasonNoLiquidity))
+			}
+		}
+	}
+
+	orderbook.auction = auction{}
+
+	if len(trades) > 0 {
+		orderbook.last = price
+	}
+
+	trades = orderbook.cascade(trades)
+
+	orderbook.flush()
+
+	return pointers(trades), rejects
+}
+
+// collect accepts order during call phase.
+func (orderbook *Orderbook) collect(order *Order) *Order {
+	switch {
+	case order.TimeInForce == TimeInForceIOC,
+		order.TimeInForce == TimeInForceFOK:
+		return orderbook.reject(order, ReasonAuction)
+	case order.Kind == KindMarket:
+		queue := orderbook.auction.queue(order.Side)
+
+		*queue = append(*queue, order)
+		orderbook.orders[order.ID] = order
+	default:
+		orderbook.rest(order)
+	}
+
+	return nil
+}
+
+// consume executes specified volume of order at uncross, taking it from
+// displayed volume first. It returns part of volume taken from hidden
+// reserve.
+func (orderbook *Orderbook) consume(order *Order, volume uint64) uint64 {
+	if order.level == nil {
+		order.Volume -= volume
+
+		return 0
+	}
+
+	visible := volume
+	if order.visible < visible {
+		visible = order.visible
+	}
+
+	order.level.detach(order)
+
+	order.Volume -= volume
+	order.visible -= visible
+
+	order.level.attach(order)
+
+	orderbook.feed.touch(order.level)
+
+	if order.Volume == 0 {
+		orderbook.unlink(order)
+		orderbook.retire(order)
+	}
+
+	return volume - visible
+}
+
+// crossing returns orders of specified side which take part in uncross at
+// clearing price in their priority order, i.e. market orders first and then
+// limit orders from the best price.
+func (orderbook *Orderbook) crossing(
+	side Side,
+	price uint64,
+	volume uint64,
+) []*Order {
+	var (
+		orders []*Order
+		total  uint64
+	)
+
+	for _, order := range *orderbook.auction.queue(side) {
+		if total >= volume {
+			return orders
+		}
+
+		orders = append(orders, order)
+		total += order.Volume
+	}
+
+	ladder := orderbook.same(side)
+
+	for i := len(ladder.levels) - 1; i >= 0; i-- {
+		level := ladder.levels[i]
+
+		if ladder.better(price, level.price) {
+			break
+		}
+
+		for order := level.head; order != nil; order = order.next {
+			if total >= volume {
+				return orders
+			}
+
+			orders = append(orders, order)
+			total += order.Volume
+		}
+	}
+
+	return orders
+}
+
+// clearing finds uncross price and volume among prices of resting orders.
+func (orderbook *Orderbook) clearing() (uint64, uint64) {
+	var demand, supply uint64
+
+	for _, order := range orderbook.auction.bids {
+		demand += order.Volume
+	}
+
+	for _, order := range orderbook.auction.asks {
+		supply += order.Volume
+	}
+
+	var prices []uint64
+
+	for _, level := range orderbook.bids.levels {
+		prices = append(prices, level.price)
+		demand += level.volume + level.reserve
+	}
+
+	for _, level := range orderbook.asks.levels {
+		prices = append(prices, level.price)
+	}
+
+	sort.Slice(prices, func(i, j int) bool { return prices[i] < prices[j] })
+
+	var (
+		bids = orderbook.bids.levels
+		asks = orderbook.asks.levels
+
+		best struct {
+			price     uint64
+			volume    uint64
+			imbalance uint64
+			distance  uint64
+		}
+	)
+
+	reference := orderbook.auction.reference
+
+	// Bids are sorted by ascending price and asks by descending one, so
+	// both can be consumed from the start and the end respectively while
+	// candidate price rises.
+	for _, price := range prices {
+		for len(bids) > 0 && bids[0].price < price {
+			demand -= bids[0].volume + bids[0].reserve
+			bids = bids[1:]
+		}
+
+		for len(asks) > 0 && asks[len(asks)-1].price <= price {
+			supply += asks[len(asks)-1].volume + asks[len(asks)-1].reserve
+			asks = asks[:len(asks)-1]
+		}
+
+		volume, imbalance := demand, supply-demand
+		if supply < demand {
+			volume, imbalance = supply, demand-supply
+		}
+
+		distance := price - reference
+		if reference > price {
+			distance = reference - price
+		}
+
+		switch {
+		case volume == 0 || volume < best.volume:
+			continue
+		case volume == best.volume && imbalance > best.imbalance:
+			continue
+		case volume == best.volume && imbalance == best.imbalance &&
+			distance >= best.distance:
+			continue
+		}
+
+		best.price = price
+		best.volume = volume
+		best.imbalance = imbalance
+		best.distance = distance
+	}
+
+	return best.price, best.volume
+}
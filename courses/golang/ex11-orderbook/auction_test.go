@@ -0,0 +1,260 @@
+package orderbook
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuction_Uncross_MaximumVolume(t *testing.T) {
+	test := assert.New(t)
+
+	book := New()
+	book.BeginAuction(0)
+
+	test.True(book.Auction())
+
+	for _, order := range []*Order{
+		{ID: 1, Side: SideBid, Kind: KindLimit, Volume: 10000, Price: 1010000},
+		{ID: 2, Side: SideBid, Kind: KindLimit, Volume: 20000, Price: 1000000},
+		{ID: 3, Side: SideAsk, Kind: KindLimit, Volume: 15000, Price: 990000},
+		{ID: 4, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 1000000},
+	} {
+		trades, reject := book.Match(order)
+		test.Empty(trades)
+		test.Nil(reject)
+	}
+
+	price, volume := book.Indicative()
+	test.Equal(uint64(1000000), price)
+	test.Equal(uint64(25000), volume)
+
+	trades, rejects := book.Uncross()
+	test.Empty(rejects)
+	test.False(book.Auction())
+
+	if test.Len(trades, 3) {
+		for i, expected := range []struct {
+			bid, ask int
+			volume   uint64
+		}{
+			{1, 3, 10000},
+			{2, 3, 5000},
+			{2, 4, 10000},
+		} {
+			test.Equal(expected.bid, trades[i].Bid.ID)
+			test.Equal(expected.ask, trades[i].Ask.ID)
+			test.Equal(expected.volume, trades[i].Volume)
+			test.Equal(uint64(1000000), trades[i].Price)
+		}
+	}
+
+	test.Equal(
+		[]DepthLevel{{Price: 1000000, Volume: 5000, Count: 1}},
+		book.Snapshot(0).Bids,
+	)
+	test.Empty(book.Snapshot(0).Asks)
+}
+
+func TestAuction_Indicative_TieBreaks(t *testing.T) {
+	test := assert.New(t)
+
+	for _, testcase := range []struct {
+		name      string
+		reference uint64
+		asks      []*Order
+		price     uint64
+	}{
+		{
+			name:      "lower price on equal distance",
+			reference: 1000000,
+			asks:      []*Order{{ID: 2, Volume: 10000, Price: 980000}},
+			price:     980000,
+		},
+		{
+			name:      "closest to reference",
+			reference: 1010000,
+			asks:      []*Order{{ID: 2, Volume: 10000, Price: 980000}},
+			price:     1020000,
+		},
+		{
+			name:      "minimum imbalance",
+			reference: 1020000,
+			asks: []*Order{
+				{ID: 2, Volume: 10000, Price: 980000},
+				{ID: 3, Volume: 5000, Price: 1000000},
+			},
+			price: 980000,
+		},
+	} {
+		book := New()
+		book.BeginAuction(testcase.reference)
+
+		book.Match(&Order{ID: 1, Side: SideBid, Kind: KindLimit, Volume: 10000, Price: 1020000})
+
+		for _, ask := range testcase.asks {
+			ask.Side = SideAsk
+			ask.Kind = KindLimit
+
+			book.Match(ask)
+		}
+
+		price, volume := book.Indicative()
+		test.Equal(testcase.price, price, testcase.name)
+		test.Equal(uint64(10000), volume, testcase.name)
+	}
+}
+
+func TestAuction_MarketOrders(t *testing.T) {
+	test := assert.New(t)
+
+	book := New()
+	book.BeginAuction(0)
+
+	_, reject := book.Match(&Order{ID: 1, Side: SideBid, Kind: KindLimit, TimeInForce: TimeInForceIOC, Volume: 10000, Price: 1000000})
+	if test.NotNil(reject) {
+		test.Equal(ReasonAuction, reject.Reason)
+	}
+
+	book.Match(&Order{ID: 2, Side: SideBid, Kind: KindMarket, Volume: 20000})
+	book.Match(&Order{ID: 3, Side: SideBid, Kind: KindMarket, Volume: 5000})
+	book.Match(&Order{ID: 4, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 1000000})
+
+	test.True(book.Cancel(3))
+
+	price, volume := book.Indicative()
+	test.Equal(uint64(1000000), price)
+	test.Equal(uint64(10000), volume)
+
+	trades, rejects := book.Uncross()
+
+	if test.Len(trades, 1) {
+		test.Equal(2, trades[0].Bid.ID)
+		test.Equal(uint64(10000), trades[0].Volume)
+	}
+
+	if test.Len(rejects, 1) {
+		test.Equal(2, rejects[0].ID)
+		test.Equal(uint64(10000), rejects[0].Volume)
+		test.Equal(ReasonNoLiquidity, rejects[0].Reason)
+	}
+
+	test.False(book.Cancel(2))
+}
+
+func TestAuction_Uncross_IcebergAndStops(t *testing.T) {
+	test := assert.New(t)
+
+	book := New()
+	book.BeginAuction(0)
+
+	book.Match(&Order{ID: 1, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 1000000, DisplayVolume: 2000})
+	book.Match(&Order{ID: 2, Side: SideAsk, Kind: KindLimit, Volume: 5000, Price: 1010000})
+	book.Match(&Order{ID: 3, Side: SideBid, Kind: KindLimit, Volume: 6000, Price: 1000000})
+	book.Match(&Order{ID: 4, Side: SideBid, Kind: KindStop, Volume: 5000, StopPrice: 1000000})
+
+	trades, rejects := book.Uncross()
+	test.Empty(rejects)
+
+	if test.Len(trades, 3) {
+		test.Equal(uint64(6000), trades[0].Volume)
+		test.Equal(uint64(1000000), trades[0].Price)
+		test.Equal(uint64(0), trades[0].BidHidden)
+		test.Equal(uint64(4000), trades[0].AskHidden)
+
+		test.Equal(4, trades[1].Bid.ID)
+		test.Equal(1, trades[1].Ask.ID)
+		test.Equal(uint64(4000), trades[1].Volume)
+		test.Equal(uint64(1000000), trades[1].Price)
+		test.Equal(uint64(2000), trades[1].AskHidden)
+
+		test.Equal(2, trades[2].Ask.ID)
+		test.Equal(uint64(1000), trades[2].Volume)
+		test.Equal(uint64(1010000), trades[2].Price)
+	}
+
+	test.Equal(
+		[]DepthLevel{{Price: 1010000, Volume: 4000, Count: 1}},
+		book.Snapshot(0).Asks,
+	)
+}
+
+func TestAuction_Uncross_Icebergs(t *testing.T) {
+	test := assert.New(t)
+
+	book := New()
+	book.BeginAuction(0)
+
+	book.Match(&Order{ID: 1, Side: SideBid, Kind: KindLimit, Volume: 6000, Price: 1000000, DisplayVolume: 1000})
+	book.Match(&Order{ID: 2, Side: SideAsk, Kind: KindLimit, Volume: 6000, Price: 1000000, DisplayVolume: 3000})
+
+	trades, rejects := book.Uncross()
+	test.Empty(rejects)
+
+	if test.Len(trades, 1) {
+		test.Equal(uint64(6000), trades[0].Volume)
+		test.Equal(uint64(5000), trades[0].BidHidden)
+		test.Equal(uint64(3000), trades[0].AskHidden)
+	}
+
+	snapshot := book.Snapshot(0)
+	test.Empty(snapshot.Bids)
+	test.Empty(snapshot.Asks)
+}
+
+func TestAuction_Uncross_IcebergBid(t *testing.T) {
+	test := assert.New(t)
+
+	book := New()
+	book.BeginAuction(0)
+
+	book.Match(&Order{ID: 1, Side: SideBid, Kind: KindLimit, Volume: 8000, Price: 1000000, DisplayVolume: 2000})
+	book.Match(&Order{ID: 2, Side: SideAsk, Kind: KindLimit, Volume: 5000, Price: 1000000})
+
+	trades, rejects := book.Uncross()
+	test.Empty(rejects)
+
+	if test.Len(trades, 1) {
+		test.Equal(uint64(5000), trades[0].Volume)
+		test.Equal(uint64(3000), trades[0].BidHidden)
+		test.Equal(uint64(0), trades[0].AskHidden)
+	}
+
+	test.Equal(
+		[]DepthLevel{{Price: 1000000, Volume: 2000, Count: 1}},
+		book.Snapshot(0).Bids,
+	)
+}
+
+func TestAuction_Journal_Recover(t *testing.T) {
+	test := assert.New(t)
+
+	journal := &bytes.Buffer{}
+
+	book, err := NewJournaled(New(), NewJournal(journal, 0))
+	test.NoError(err)
+
+	test.NoError(book.BeginAuction(0))
+
+	_, _, err = book.Match(&Order{ID: 1, Side: SideBid, Kind: KindMarket, Volume: 5000})
+	test.NoError(err)
+
+	_, _, err = book.Match(&Order{ID: 2, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 1000000})
+	test.NoError(err)
+
+	test.NoError(book.Checkpoint())
+
+	_, _, err = book.Match(&Order{ID: 3, Side: SideBid, Kind: KindLimit, Volume: 2000, Price: 1010000})
+	test.NoError(err)
+
+	trades, _, err := book.Uncross()
+	test.NoError(err)
+
+	recovered, recoveredTrades, err := Recover(bytes.NewReader(journal.Bytes()))
+	test.NoError(err)
+
+	assertTrades(test, trades, recoveredTrades)
+	test.False(recovered.Auction())
+	test.Equal(book.SnapshotOrders(0), recovered.SnapshotOrders(0))
+}
@@ -1,8 +1,11 @@
 package orderbook
 
 import (
+	"fmt"
 	"math"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -76,6 +79,185 @@ func BenchmarkOrderbook(b *testing.B) {
 	}
 }
 
+func BenchmarkOrderbook_ZeroAlloc(b *testing.B) {
+	fabricAsk := new(fabricator)
+	fabricBid := new(fabricator)
+	fabricBench := new(fabricator)
+
+	fabricAsk.chances.buy = 0
+	fabricAsk.chances.market = 0
+	fabricAsk.volume.min = 1000
+	fabricAsk.volume.max = 9000
+	fabricAsk.price.min = 6001
+	fabricAsk.price.max = 7000
+
+	fabricBid.chances.buy = 1
+	fabricBid.chances.market = 0
+	fabricBid.volume.min = 1000
+	fabricBid.volume.max = 9000
+	fabricBid.price.min = 5001
+	fabricBid.price.max = 6000
+
+	fabricBench.chances.buy = 0.5
+	fabricBench.chances.market = 0.5
+	fabricBench.volume.min = 1000
+	fabricBench.volume.max = 9000
+	fabricBench.price.min = 5000
+	fabricBench.price.max = 7000
+
+	pool := NewPool(1 << 18)
+
+	book := New()
+	book.Recycle(pool)
+
+	bids := make([]Order, 80000)
+	asks := make([]Order, 80000)
+
+	fabricBid.fabricate(bids, 1000001)
+	fabricAsk.fabricate(asks, 1000002)
+
+	trades := make([]Trade, 0, 1024)
+
+	for _, orders := range [][]Order{bids, asks} {
+		for i := range orders {
+			order := pool.Get()
+			*order = orders[i]
+
+			trades, _ = book.MatchTo(order, trades[:0])
+		}
+	}
+
+	orders := make([]Order, 1<<16)
+
+	fabricBench.fabricate(orders, 0)
+
+	id := 0
+	step := func() {
+		order := pool.Get()
+		*order = orders[id%len(orders)]
+		order.ID = id
+
+		id++
+
+		trades, _ = book.MatchTo(order, trades[:0])
+	}
+
+	for i := 0; i < len(orders); i++ {
+		step()
+	}
+
+	if allocs := testing.AllocsPerRun(len(orders), step); allocs > 0 {
+		b.Fatalf("steady state allocates: %.2f allocs/op", allocs)
+	}
+
+	b.ReportAllocs()
+
+	started := time.Now()
+	{
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			step()
+		}
+		b.StopTimer()
+	}
+
+	elapsed := time.Since(started).Seconds()
+
+	b.ReportMetric(float64(b.N)/elapsed, "orders/sec")
+}
+
+func BenchmarkOrderbook_Parallel(b *testing.B) {
+	fabricAsk := new(fabricator)
+	fabricBid := new(fabricator)
+
+	fabricAsk.chances.buy = 0
+	fabricAsk.chances.market = 0
+	fabricAsk.volume.min = 1000
+	fabricAsk.volume.max = 9000
+	fabricAsk.price.min = 6001
+	fabricAsk.price.max = 7000
+
+	fabricBid.chances.buy = 1
+	fabricBid.chances.market = 0
+	fabricBid.volume.min = 1000
+	fabricBid.volume.max = 9000
+	fabricBid.price.min = 5001
+	fabricBid.price.max = 6000
+
+	symbols := make([]string, runtime.GOMAXPROCS(0))
+	for i := range symbols {
+		symbols[i] = fmt.Sprintf("SYMBOL%d", i)
+	}
+
+	sharded := NewSharded(symbols, 4096)
+	defer sharded.Close()
+
+	for _, symbol := range symbols {
+		bids := make([]Order, 80000)
+		asks := make([]Order, 80000)
+
+		fabricBid.fabricate(bids, 1000001)
+		fabricAsk.fabricate(asks, 1000002)
+
+		var last *Future
+
+		for i := range bids {
+			sharded.Match(symbol, &bids[i])
+			last = sharded.Match(symbol, &asks[i])
+		}
+
+		last.Wait()
+	}
+
+	var producer atomic.Int64
+
+	started := time.Now()
+	{
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			index := int(producer.Add(1))
+			symbol := symbols[index%len(symbols)]
+
+			fabric := new(fabricator)
+
+			fabric.chances.buy = 0.5
+			fabric.chances.market = 0.5
+			fabric.volume.min = 1000
+			fabric.volume.max = 9000
+			fabric.price.min = 5000
+			fabric.price.max = 7000
+
+			batch := make([]Order, shardBatch)
+			futures := make([]*Future, 0, shardBatch)
+
+			for generation := index << 20; pb.Next(); generation++ {
+				fabric.fabricate(batch, generation)
+
+				for i := range batch {
+					order := batch[i]
+
+					futures = append(futures, sharded.Match(symbol, &order))
+
+					if i+1 < len(batch) && !pb.Next() {
+						break
+					}
+				}
+
+				for _, future := range futures {
+					future.Wait()
+				}
+
+				futures = futures[:0]
+			}
+		})
+		b.StopTimer()
+	}
+
+	elapsed := time.Since(started).Seconds()
+
+	b.ReportMetric(float64(b.N)/elapsed, "orders/sec")
+}
+
 type fabricator struct {
 	chances struct {
 		buy    float64
@@ -0,0 +1,129 @@
+package orderbook
+
+// checkpoint encodes complete state of the book: resting orders of every
+// level in priority order, held stop orders in trigger order, call phase
+// state and counters which affect further matching.
+func (orderbook *Orderbook) checkpoint(encoder *encoder) {
+	encoder.uint64(orderbook.last)
+	encoder.uint64(orderbook.sequence)
+	encoder.uint64(orderbook.feed.sequence)
+
+	for _, ladder := range []*ladder{&orderbook.bids, &orderbook.asks} {
+		encoder.uint64(uint64(len(ladder.levels)))
+
+		for i := len(ladder.levels) - 1; i >= 0; i-- {
+			level := ladder.levels[i]
+
+			encoder.uint64(uint64(level.count))
+
+			for order := level.head; order != nil; order = order.next {
+				encoder.order(order)
+				encoder.uint64(order.visible)
+				encoder.uint64(order.execution.filled)
+				encoder.uint64(order.execution.notional[0])
+				encoder.uint64(order.execution.notional[1])
+			}
+		}
+	}
+
+	for _, stops := range []*stops{&orderbook.bidStops, &orderbook.askStops} {
+		encoder.uint64(uint64(len(stops.orders)))
+
+		for _, order := range stops.orders {
+			encoder.order(order)
+			encoder.uint64(order.sequence)
+		}
+	}
+
+	auction := &orderbook.auction
+
+	if !auction.active {
+		encoder.byte(0)
+
+		return
+	}
+
+	encoder.byte(1)
+	encoder.uint64(auction.reference)
+
+	for _, queue := range [][]*Order{auction.bids, auction.asks} {
+		encoder.uint64(uint64(len(queue)))
+
+		for _, order := range queue {
+			encoder.order(order)
+		}
+	}
+}
+
+// restore creates book with specified options from checkpoint. Levels of
+// restored book are considered published, so depth updates continue
+// checkpoint sequence.
+func restore(payload []byte, options []Option) (*Orderbook, error) {
+	decoder := decoder{payload: payload}
+
+	book := New(options...)
+
+	book.last = decoder.uint64()
+	book.sequence = decoder.uint64()
+	book.feed.sequence = decoder.uint64()
+
+	for _, ladder := range []*ladder{&book.bids, &book.asks} {
+		levels := decoder.uint64()
+
+		for i := uint64(0); i < levels && !decoder.failed; i++ {
+			count := decoder.uint64()
+
+			for j := uint64(0); j < count && !decoder.failed; j++ {
+				order := decoder.order()
+				order.visible = decoder.uint64()
+				order.execution.filled = decoder.uint64()
+				order.execution.notional[0] = decoder.uint64()
+				order.execution.notional[1] = decoder.uint64()
+
+				ladder.insert(order)
+				book.orders[order.ID] = order
+			}
+		}
+
+		for _, level := range ladder.levels {
+			level.published = true
+			level.shown = level.volume
+			level.shownCount = level.count
+		}
+	}
+
+	for _, stops := range []*stops{&book.bidStops, &book.askStops} {
+		count := decoder.uint64()
+
+		for i := uint64(0); i < count && !decoder.failed; i++ {
+			order := decoder.order()
+			order.sequence = decoder.uint64()
+
+			stops.orders = append(stops.orders, order)
+			book.orders[order.ID] = order
+		}
+	}
+
+	if decoder.byte() == 1 {
+		book.auction.active = true
+		book.auction.reference = decoder.uint64()
+
+		for _, side := range []Side{SideBid, SideAsk} {
+			queue := book.auction.queue(side)
+			count := decoder.uint64()
+
+			for i := uint64(0); i < count && !decoder.failed; i++ {
+				order := decoder.order()
+
+				*queue = append(*queue, order)
+				book.orders[order.ID] = order
+			}
+		}
+	}
+
+	if decoder.failed || len(decoder.payload) > 0 {
+		return nil, ErrCorruptJournal
+	}
+
+	return book, nil
+}
@@ -0,0 +1,366 @@
+// Command orderbook-sim replays recorded order stream through the orderbook
+// and reports executed trades, final depth, latency percentiles and
+// throughput.
+//
+// Usage:
+//
+//	orderbook-sim [flags] orders.csv|orders.jsonl|-
+//
+// Stream format is described by orderbook.InputReader. Trades are written as
+// CSV to standard output or -trades file, in the same format as regression
+// fixtures in testdata of the orderbook package, while report goes to
+// standard error. By default stream is replayed as fast as possible: -speed
+// keeps its recorded pacing accelerated by specified factor, and -step
+// prints every record with its outcome and waits for Enter.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	orderbook "github.com/actpohabtNS/hackademy/courses/golang/ex11-orderbook"
+)
+
+type options struct {
+	format string
+	trades string
+	depth  int
+	speed  float64
+	step   bool
+}
+
+func main() {
+	var options options
+
+	flag.StringVar(&options.format, "format", "", "input format: csv or jsonl (default by extension)")
+	flag.StringVar(&options.trades, "trades", "-", "file to write trades to, - for standard output")
+	flag.IntVar(&options.depth, "depth", 10, "number of price levels of final depth per side, 0 for all")
+	flag.Float64Var(&options.speed, "speed", 0, "replay with recorded pacing accelerated by this factor, 0 for no pacing")
+	flag.BoolVar(&options.step, "step", false, "wait for Enter after every record")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] orders.csv|orders.jsonl|-\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), options, os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "orderbook-sim:", err)
+		os.Exit(1)
+	}
+}
+
+// simulation holds state of single replay.
+type simulation struct {
+	options options
+
+	book   *orderbook.Orderbook
+	trades *orderbook.TradeWriter
+
+	console *bufio.Reader
+	report  io.Writer
+
+	// first is timestamp of the first record and started is time when it
+	// was replayed, both are used for pacing.
+	first   int64
+	started time.Time
+
+	records   int
+	executed  int
+	rejected  int
+	latencies []time.Duration
+}
+
+func run(
+	path string,
+	options options,
+	stdin io.Reader,
+	stdout io.Writer,
+	stderr io.Writer,
+) error {
+	format, err := detect(path, options.format)
+	if err != nil {
+		return err
+	}
+
+	input := stdin
+
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		input = file
+	} else if options.step {
+		return errors.New("step mode reads standard input, so orders must be read from file")
+	}
+
+	output := stdout
+
+	if options.trades != "-" {
+		file, err := os.Create(options.trades)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		output = file
+	}
+
+	simulation := &simulation{
+		options: options,
+		book:    orderbook.New(),
+		trades:  orderbook.NewTradeWriter(output),
+		console: bufio.NewReader(stdin),
+		report:  stderr,
+	}
+
+	reader := orderbook.NewInputReader(input, format)
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := simulation.replay(&record); err != nil {
+			return err
+		}
+	}
+
+	if err := simulation.trades.Flush(); err != nil {
+		return err
+	}
+
+	simulation.summary()
+
+	return nil
+}
+
+// detect returns format of input, which is taken from its extension unless
+// specified explicitly.
+func detect(path string, format string) (orderbook.InputFormat, error) {
+	if format == "" {
+		format = "csv"
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".jsonl", ".ndjson", ".json":
+			format = "jsonl"
+		}
+	}
+
+	switch format {
+	case "csv":
+		return orderbook.InputCSV, nil
+	case "jsonl":
+		return orderbook.InputJSONLines, nil
+	}
+
+	return 0, fmt.Errorf("unknown format %q", format)
+}
+
+func (simulation *simulation) replay(record *orderbook.Input) error {
+	simulation.pace(record.Timestamp)
+
+	started := time.Now()
+	trades, reject, ok := record.Apply(simulation.book)
+	latency := time.Since(started)
+
+	simulation.records++
+	simulation.executed += len(trades)
+	simulation.latencies = append(simulation.latencies, latency)
+
+	if reject != nil || !ok {
+		simulation.rejected++
+	}
+
+	for _, trade := range trades {
+		if err := simulation.trades.Write(trade); err != nil {
+			return err
+		}
+	}
+
+	if simulation.options.step {
+		simulation.pause(record, trades, reject, ok)
+	}
+
+	return nil
+}
+
+// pace sleeps until the record is due according to its timestamp.
+func (simulation *simulation) pace(timestamp int64) {
+	if simulation.options.speed <= 0 || timestamp == 0 {
+		return
+	}
+
+	if simulation.started.IsZero() {
+		simulation.first = timestamp
+		simulation.started = time.Now()
+
+		return
+	}
+
+	due := time.Duration(float64(timestamp-simulation.first) / simulation.options.speed)
+
+	if wait := due - time.Since(simulation.started); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// pause prints outcome of the record and waits for Enter. Stepping stops
+// when console is closed.
+func (simulation *simulation) pause(
+	record *orderbook.Input,
+	trades []*orderbook.Trade,
+	reject *orderbook.Order,
+	ok bool,
+) {
+	order := &record.Order
+
+	fmt.Fprintf(
+		simulation.report,
+		"#%d %s id=%d %s %s volume=%d price=%d\n",
+		simulation.records,
+		record.Action,
+		order.ID,
+		order.Side,
+		order.Kind,
+		order.Volume,
+		order.Price,
+	)
+
+	for _, trade := range trades {
+		fmt.Fprintf(
+			simulation.report,
+			"  trade bid=%d ask=%d volume=%d price=%d\n",
+			trade.Bid.ID,
+			trade.Ask.ID,
+			trade.Volume,
+			trade.Price,
+		)
+	}
+
+	switch {
+	case reject != nil:
+		fmt.Fprintf(simulation.report, "  rejected: %s\n", reject.Reason)
+	case !ok:
+		fmt.Fprintln(simulation.report, "  unknown order")
+	}
+
+	depth := simulation.book.Snapshot(1)
+
+	fmt.Fprintf(
+		simulation.report,
+		"  best bid %s, best ask %s [Enter]",
+		level(depth.Bids),
+		level(depth.Asks),
+	)
+
+	if _, err := simulation.console.ReadString('\n'); err != nil {
+		fmt.Fprintln(simulation.report)
+
+		simulation.options.step = false
+	}
+}
+
+func level(levels []orderbook.DepthLevel) string {
+	if len(levels) == 0 {
+		return "none"
+	}
+
+	return fmt.Sprintf("%d x %d", levels[0].Volume, levels[0].Price)
+}
+
+func (simulation *simulation) summary() {
+	var elapsed time.Duration
+
+	for _, latency := range simulation.latencies {
+		elapsed += latency
+	}
+
+	throughput := 0.0
+	if elapsed > 0 {
+		throughput = float64(simulation.records) / elapsed.Seconds()
+	}
+
+	fmt.Fprintf(
+		simulation.report,
+		"records: %d, trades: %d, rejected: %d\n",
+		simulation.records,
+		simulation.executed,
+		simulation.rejected,
+	)
+	fmt.Fprintf(
+		simulation.report,
+		"matching time: %s, throughput: %.0f records/sec\n",
+		elapsed,
+		throughput,
+	)
+
+	latencies := simulation.latencies
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	fmt.Fprintf(simulation.report, "latency:")
+
+	for _, percent := range []float64{50, 90, 99, 99.9, 100} {
+		fmt.Fprintf(
+			simulation.report,
+			" p%g=%s",
+			percent,
+			percentile(latencies, percent),
+		)
+	}
+
+	fmt.Fprintln(simulation.report)
+
+	depth := simulation.book.Snapshot(simulation.options.depth)
+
+	fmt.Fprintln(simulation.report, "depth:")
+
+	for i := len(depth.Asks) - 1; i >= 0; i-- {
+		ask := depth.Asks[i]
+
+		fmt.Fprintf(simulation.report, "  ASK %d %d (%d)\n", ask.Price, ask.Volume, ask.Count)
+	}
+
+	for _, bid := range depth.Bids {
+		fmt.Fprintf(simulation.report, "  BID %d %d (%d)\n", bid.Price, bid.Volume, bid.Count)
+	}
+}
+
+// percentile returns latency below or equal to which is specified percent
+// of sorted latencies.
+func percentile(sorted []time.Duration, percent float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	index := int(math.Ceil(percent*float64(len(sorted))/100)) - 1
+	if index < 0 {
+		index = 0
+	}
+
+	return sorted[index]
+}
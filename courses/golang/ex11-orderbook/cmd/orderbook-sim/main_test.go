@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	test := assert.New(t)
+
+	path := filepath.Join("..", "..", "testdata", "sweep.orders.csv")
+
+	expected, err := os.ReadFile(filepath.Join("..", "..", "testdata", "sweep.trades.csv"))
+	test.NoError(err)
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	err = run(path, options{trades: "-", speed: 1000}, nil, stdout, stderr)
+	test.NoError(err)
+
+	test.Equal(string(expected), stdout.String())
+	test.Contains(stderr.String(), "records: 10, trades: 4, rejected: 2\n")
+	test.Contains(stderr.String(), "depth:\n  ASK 60100000 10000 (1)\n")
+}
+
+func TestRun_Step(t *testing.T) {
+	test := assert.New(t)
+
+	path := filepath.Join("..", "..", "testdata", "selftrade.orders.jsonl")
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	err := run(path, options{trades: "-", step: true}, strings.NewReader("\n\n"), stdout, stderr)
+	test.NoError(err)
+
+	test.Contains(stderr.String(), "#1 NEW id=1 ASK LIMIT volume=10000 price=60000000\n")
+	test.Contains(stderr.String(), "best bid none, best ask 10000 x 60000000 [Enter]")
+	test.NotContains(stderr.String(), "#4 ", "stepping stops when console is closed")
+	test.Contains(stderr.String(), "records: 6, trades: 4, rejected: 0\n")
+}
+
+func TestPercentile(t *testing.T) {
+	test := assert.New(t)
+
+	var latencies []time.Duration
+	for i := 1; i <= 1000; i++ {
+		latencies = append(latencies, time.Duration(i))
+	}
+
+	test.Equal(time.Duration(0), percentile(nil, 50))
+	test.Equal(time.Duration(500), percentile(latencies, 50))
+	test.Equal(time.Duration(999), percentile(latencies, 99.9))
+	test.Equal(time.Duration(1000), percentile(latencies, 100))
+}
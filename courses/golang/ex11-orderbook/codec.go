@@ -0,0 +1,106 @@
+package orderbook
+
+import "encoding/binary"
+
+// encoder and decoder implement binary layout shared by journal records and
+// checkpoints. All integers are big endian.
+type encoder struct {
+	buffer []byte
+}
+
+func (encoder *encoder) uint64(value uint64) {
+	encoder.buffer = binary.BigEndian.AppendUint64(encoder.buffer, value)
+}
+
+func (encoder *encoder) byte(value byte) {
+	encoder.buffer = append(encoder.buffer, value)
+}
+
+// string writes string of up to 255 bytes prefixed with its length.
+func (encoder *encoder) string(value string) {
+	if len(value) > 255 {
+		value = value[:255]
+	}
+
+	encoder.byte(byte(len(value)))
+	encoder.buffer = append(encoder.buffer, value...)
+}
+
+func (encoder *encoder) order(order *Order) {
+	encoder.uint64(uint64(order.ID))
+	encoder.byte(byte(order.Side))
+	encoder.byte(byte(order.Kind))
+	encoder.byte(byte(order.TimeInForce))
+	encoder.uint64(order.Account)
+	encoder.byte(byte(order.SelfTrade))
+	encoder.uint64(order.Volume)
+	encoder.uint64(order.Price)
+	encoder.uint64(order.StopPrice)
+	encoder.uint64(order.DisplayVolume)
+}
+
+type decoder struct {
+	payload []byte
+	failed  bool
+}
+
+func (decoder *decoder) uint64() uint64 {
+	if len(decoder.payload) < 8 {
+		decoder.failed = true
+
+		return 0
+	}
+
+	value := binary.BigEndian.Uint64(decoder.payload)
+
+	decoder.payload = decoder.payload[8:]
+
+	return value
+}
+
+func (decoder *decoder) byte() byte {
+	if len(decoder.payload) < 1 {
+		decoder.failed = true
+
+		return 0
+	}
+
+	value := decoder.payload[0]
+
+	decoder.payload = decoder.payload[1:]
+
+	return value
+}
+
+func (decoder *decoder) string() string {
+	length := int(decoder.byte())
+
+	if len(decoder.payload) < length {
+		decoder.failed = true
+
+		return ""
+	}
+
+	value := string(decoder.payload[:length])
+
+	decoder.payload = decoder.payload[length:]
+
+	return value
+}
+
+func (decoder *decoder) order() *Order {
+	order := &Order{}
+
+	order.ID = int(decoder.uint64())
+	order.Side = Side(decoder.byte())
+	order.Kind = Kind(decoder.byte())
+	order.TimeInForce = TimeInForce(decoder.byte())
+	order.Account = decoder.uint64()
+	order.SelfTrade = SelfTrade(decoder.byte())
+	order.Volume = decoder.uint64()
+	order.Price = decoder.uint64()
+	order.StopPrice = decoder.uint64()
+	order.DisplayVolume = decoder.uint64()
+
+	return order
+}
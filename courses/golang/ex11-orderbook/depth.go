@@ -0,0 +1,80 @@
+package orderbook
+
+// Depth is a snapshot of resting orders. Bids and asks are ordered from the
+// best price to the worst one. Only displayed volume is included, hidden
+// reserve of iceberg orders is never exposed.
+type Depth struct {
+	Sequence uint64
+
+	Bids []DepthLevel
+	Asks []DepthLevel
+}
+
+type DepthLevel struct {
+	Price  uint64
+	Volume uint64
+	Count  int
+
+	// Orders lists resting orders of the level in priority order. It is
+	// filled only by SnapshotOrders.
+	Orders []DepthOrder
+}
+
+type DepthOrder struct {
+	ID     int
+	Volume uint64
+}
+
+// Snapshot returns aggregated price levels (L2), up to specified number of
+// levels per side. Non-positive number means all levels.
+func (orderbook *Orderbook) Snapshot(levels int) *Depth {
+	return &Depth{
+		Sequence: orderbook.feed.sequence,
+		Bids:     orderbook.bids.depth(levels, false),
+		Asks:     orderbook.asks.depth(levels, false),
+	}
+}
+
+// SnapshotOrders returns price levels together with every resting order
+// (L3), up to specified number of levels per side. Non-positive number means
+// all levels.
+func (orderbook *Orderbook) SnapshotOrders(levels int) *Depth {
+	return &Depth{
+		Sequence: orderbook.feed.sequence,
+		Bids:     orderbook.bids.depth(levels, true),
+		Asks:     orderbook.asks.depth(levels, true),
+	}
+}
+
+func (ladder *ladder) depth(levels int, orders bool) []DepthLevel {
+	if levels <= 0 || levels > len(ladder.levels) {
+		levels = len(ladder.levels)
+	}
+
+	depth := make([]DepthLevel, levels)
+
+	for i := range depth {
+		level := ladder.levels[len(ladder.levels)-1-i]
+
+		depth[i] = DepthLevel{
+			Price:  level.price,
+			Volume: level.volume,
+			Count:  level.count,
+		}
+
+		if !orders {
+			continue
+		}
+
+		depth[i].Orders = make([]DepthOrder, 0, level.count)
+
+		for order := level.head; order != nil; order = order.next {
+			depth[i].Orders = append(depth[i].Orders, DepthOrder{
+				ID:     order.ID,
+				Volume: order.visible,
+			})
+		}
+	}
+
+	return depth
+}
@@ -0,0 +1,250 @@
+package orderbook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot_Levels(t *testing.T) {
+	test := assert.New(t)
+
+	book := New()
+
+	book.Match(&Order{ID: 1, Side: SideBid, Kind: KindLimit, Volume: 10000, Price: 59000000})
+	book.Match(&Order{ID: 2, Side: SideBid, Kind: KindLimit, Volume: 20000, Price: 60000000})
+	book.Match(&Order{ID: 3, Side: SideBid, Kind: KindLimit, Volume: 5000, Price: 60000000})
+	book.Match(&Order{ID: 4, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 62000000})
+	book.Match(&Order{ID: 5, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 61000000})
+
+	depth := book.Snapshot(0)
+
+	test.Equal(
+		[]DepthLevel{
+			{Price: 60000000, Volume: 25000, Count: 2},
+			{Price: 59000000, Volume: 10000, Count: 1},
+		},
+		depth.Bids,
+	)
+
+	test.Equal(
+		[]DepthLevel{
+			{Price: 61000000, Volume: 10000, Count: 1},
+			{Price: 62000000, Volume: 10000, Count: 1},
+		},
+		depth.Asks,
+	)
+
+	depth = book.Snapshot(1)
+
+	test.Len(depth.Bids, 1)
+	test.Len(depth.Asks, 1)
+	test.Equal(uint64(60000000), depth.Bids[0].Price)
+	test.Equal(uint64(61000000), depth.Asks[0].Price)
+}
+
+func TestSnapshot_Iceberg_HiddenExcluded(t *testing.T) {
+	test := assert.New(t)
+
+	book := New()
+
+	book.Match(&Order{
+		ID:            1,
+		Side:          SideAsk,
+		Kind:          KindLimit,
+		Volume:        30000,
+		Price:         60000000,
+		DisplayVolume: 10000,
+	})
+	book.Match(&Order{ID: 2, Side: SideAsk, Kind: KindLimit, Volume: 5000, Price: 60000000})
+
+	test.Equal(
+		[]DepthLevel{{Price: 60000000, Volume: 15000, Count: 2}},
+		book.Snapshot(0).Asks,
+	)
+
+	book.Match(&Order{ID: 3, Side: SideBid, Kind: KindMarket, Volume: 12000})
+
+	test.Equal(
+		[]DepthLevel{
+			{
+				Price:  60000000,
+				Volume: 13000,
+				Count:  2,
+				Orders: []DepthOrder{
+					{ID: 2, Volume: 3000},
+					{ID: 1, Volume: 10000},
+				},
+			},
+		},
+		book.SnapshotOrders(0).Asks,
+	)
+}
+
+func TestSnapshotOrders_Priority(t *testing.T) {
+	test := assert.New(t)
+
+	book := New()
+
+	book.Match(&Order{ID: 1, Side: SideBid, Kind: KindLimit, Volume: 10000, Price: 60000000})
+	book.Match(&Order{ID: 2, Side: SideBid, Kind: KindLimit, Volume: 10000, Price: 60000000})
+	book.Match(&Order{ID: 3, Side: SideBid, Kind: KindLimit, Volume: 10000, Price: 59000000})
+	book.Amend(1, 15000, 60000000)
+
+	test.Equal(
+		[]DepthLevel{
+			{
+				Price:  60000000,
+				Volume: 25000,
+				Count:  2,
+				Orders: []DepthOrder{
+					{ID: 2, Volume: 10000},
+					{ID: 1, Volume: 15000},
+				},
+			},
+		},
+		book.SnapshotOrders(1).Bids,
+	)
+}
+
+func TestSubscribe_Updates(t *testing.T) {
+	test := assert.New(t)
+
+	book := New()
+
+	var updates []Update
+
+	unsubscribe := book.Subscribe(func(update Update) {
+		updates = append(updates, update)
+	})
+
+	book.Match(&Order{ID: 1, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 60000000})
+	book.Match(&Order{ID: 2, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 60000000})
+	book.Match(&Order{ID: 3, Side: SideBid, Kind: KindMarket, Volume: 15000})
+	book.Cancel(2)
+
+	unsubscribe()
+
+	book.Match(&Order{ID: 4, Side: SideBid, Kind: KindLimit, Volume: 10000, Price: 59000000})
+
+	test.Equal(
+		[]Update{
+			{
+				Sequence: 1,
+				Action:   UpdateAdd,
+				Side:     SideAsk,
+				Price:    60000000,
+				Volume:   10000,
+				Count:    1,
+			},
+			{
+				Sequence: 2,
+				Action:   UpdateChange,
+				Side:     SideAsk,
+				Price:    60000000,
+				Volume:   20000,
+				Count:    2,
+			},
+			{
+				Sequence: 3,
+				Action:   UpdateChange,
+				Side:     SideAsk,
+				Price:    60000000,
+				Volume:   5000,
+				Count:    1,
+			},
+			{
+				Sequence: 4,
+				Action:   UpdateRemove,
+				Side:     SideAsk,
+				Price:    60000000,
+			},
+		},
+		updates,
+	)
+
+	test.Equal(uint64(5), book.Snapshot(0).Sequence)
+}
+
+func TestSubscribe_RebuildsSnapshot(t *testing.T) {
+	test := assert.New(t)
+
+	book := New()
+
+	fabric := new(fabricator)
+
+	fabric.chances.buy = 0.5
+	fabric.chances.market = 0.2
+	fabric.volume.min = 1
+	fabric.volume.max = 5
+	fabric.price.min = 90
+	fabric.price.max = 110
+
+	orders := make([]Order, 2000)
+
+	fabric.fabricate(orders[:1000], 0)
+
+	for i := range orders[:1000] {
+		book.Match(&orders[i])
+	}
+
+	snapshot := book.Snapshot(0)
+
+	levels := map[Side]map[uint64]DepthLevel{
+		SideBid: {},
+		SideAsk: {},
+	}
+
+	for _, level := range snapshot.Bids {
+		levels[SideBid][level.Price] = level
+	}
+
+	for _, level := range snapshot.Asks {
+		levels[SideAsk][level.Price] = level
+	}
+
+	sequence := snapshot.Sequence
+
+	book.Subscribe(func(update Update) {
+		test.Equal(sequence+1, update.Sequence, "sequence gap")
+
+		sequence = update.Sequence
+
+		if update.Action == UpdateRemove {
+			delete(levels[update.Side], update.Price)
+
+			return
+		}
+
+		levels[update.Side][update.Price] = DepthLevel{
+			Price:  update.Price,
+			Volume: update.Volume,
+			Count:  update.Count,
+		}
+	})
+
+	fabric.fabricate(orders[1000:], 1)
+
+	for i := range orders[1000:] {
+		order := &orders[1000+i]
+
+		if i%10 == 0 {
+			book.Cancel(order.ID - 500)
+		}
+
+		book.Match(order)
+	}
+
+	expected := book.Snapshot(0)
+
+	test.Len(levels[SideBid], len(expected.Bids))
+	test.Len(levels[SideAsk], len(expected.Asks))
+
+	for _, level := range expected.Bids {
+		test.Equal(level, levels[SideBid][level.Price])
+	}
+
+	for _, level := range expected.Asks {
+		test.Equal(level, levels[SideAsk][level.Price])
+	}
+}
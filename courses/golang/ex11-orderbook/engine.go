@@ -0,0 +1,379 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// DefaultPrecision is number of implied decimal places of price and volume
+// used when instrument doesn't specify its own.
+const DefaultPrecision = 4
+
+var (
+	ErrUnknownInstrument   = errors.New("unknown instrument")
+	ErrDuplicateInstrument = errors.New("instrument already exists")
+	ErrInvalidInstrument   = errors.New("invalid instrument")
+	ErrHalted              = errors.New("trading halted")
+	ErrAuctionOnly         = errors.New("instrument is in auction-only state")
+	ErrZeroVolume          = errors.New("zero volume")
+	ErrTickSize            = errors.New("price is not multiple of tick size")
+	ErrLotSize             = errors.New("volume is not multiple of lot size")
+	ErrMinNotional         = errors.New("notional is below minimum")
+	ErrMaxNotional         = errors.New("notional is above maximum")
+)
+
+// RejectError is returned by MatchingEngine when order doesn't pass
+// instrument rules. Reason is one of Err* values and can be checked with
+// errors.Is.
+type RejectError struct {
+	Symbol string
+	Order  *Order
+	Reason error
+}
+
+func (err *RejectError) Error() string {
+	return fmt.Sprintf(
+		"%s: order %d rejected: %s",
+		err.Symbol,
+		err.Order.ID,
+		err.Reason,
+	)
+}
+
+func (err *RejectError) Unwrap() error {
+	return err.Reason
+}
+
+type InstrumentState int8
+
+const (
+	StateTrading     InstrumentState = 0
+	StateHalted      InstrumentState = 1
+	StateAuctionOnly InstrumentState = 2
+)
+
+func (state InstrumentState) String() string {
+	switch state {
+	case StateTrading:
+		return "TRADING"
+	case StateHalted:
+		return "HALTED"
+	case StateAuctionOnly:
+		return "AUCTION_ONLY"
+	}
+
+	return "UNKNOWN"
+}
+
+// Instrument describes trading rules of single book. Zero tick size, lot size
+// or notional bound means that corresponding rule is not enforced. Notional
+// is price multiplied by volume and is expressed with the same precision.
+type Instrument struct {
+	Symbol string
+
+	// Precision is number of implied decimal places of price and volume,
+	// DefaultPrecision is used if it's nil. Zero means whole units.
+	Precision *uint8
+
+	TickSize uint64
+	LotSize  uint64
+
+	MinNotional uint64
+	MaxNotional uint64
+
+	// Allocator distributes volume among orders of price level, FIFO is
+	// used if it's nil.
+	Allocator Allocator
+}
+
+// Scale returns fixed-point value which represents one unit of instrument.
+func (instrument *Instrument) Scale() uint64 {
+	scale := uint64(1)
+
+	for i := uint8(0); i < instrument.places(); i++ {
+		scale *= 10
+	}
+
+	return scale
+}
+
+// places returns number of implied decimal places.
+func (instrument *Instrument) places() uint8 {
+	if instrument.Precision == nil {
+		return DefaultPrecision
+	}
+
+	return *instrument.Precision
+}
+
+// resolved returns copy of instrument with its own Precision, so precision
+// can't be changed through pointer shared with caller.
+func (instrument Instrument) resolved() Instrument {
+	places := instrument.places()
+
+	instrument.Precision = &places
+
+	return instrument
+}
+
+// Notional returns price multiplied by volume with instrument precision,
+// saturating at math.MaxUint64.
+func (instrument *Instrument) Notional(price, volume uint64) uint64 {
+	hi, lo := bits.Mul64(price, volume)
+
+	scale := instrument.Scale()
+	if hi >= scale {
+		return math.MaxUint64
+	}
+
+	notional, _ := bits.Div64(hi, lo, scale)
+
+	return notional
+}
+
+func (instrument *Instrument) validate(order *Order) error {
+	if order.Volume == 0 {
+		return ErrZeroVolume
+	}
+
+	if instrument.LotSize > 0 && order.Volume%instrument.LotSize != 0 {
+		return ErrLotSize
+	}
+
+	if instrument.TickSize > 0 {
+		if order.stop() && order.StopPrice%instrument.TickSize != 0 {
+			return ErrTickSize
+		}
+
+		if order.priced() && order.Price%instrument.TickSize != 0 {
+			return ErrTickSize
+		}
+	}
+
+	if !order.priced() {
+		return nil
+	}
+
+	notional := instrument.Notional(order.Price, order.Volume)
+
+	if instrument.MinNotional > 0 && notional < instrument.MinNotional {
+		return ErrMinNotional
+	}
+
+	if instrument.MaxNotional > 0 && notional > instrument.MaxNotional {
+		return ErrMaxNotional
+	}
+
+	return nil
+}
+
+type market struct {
+	instrument Instrument
+	state      InstrumentState
+	book       *Orderbook
+}
+
+// MatchingEngine routes orders to books of named instruments and enforces
+// their trading rules and states. Like Orderbook, it is not thread safe.
+type MatchingEngine struct {
+	markets map[string]*market
+}
+
+func NewMatchingEngine() *MatchingEngine {
+	return &MatchingEngine{
+		markets: map[string]*market{},
+	}
+}
+
+func (engine *MatchingEngine) AddInstrument(instrument Instrument) error {
+	if instrument.Symbol == "" || instrument.places() > 19 {
+		return ErrInvalidInstrument
+	}
+
+	if _, ok := engine.markets[instrument.Symbol]; ok {
+		return ErrDuplicateInstrument
+	}
+
+	instrument = instrument.resolved()
+
+	if instrument.Allocator == nil {
+		instrument.Allocator = FIFO{}
+	}
+
+	engine.markets[instrument.Symbol] = &market{
+		instrument: instrument,
+		book:       New(WithAllocator(instrument.Allocator)),
+	}
+
+	return nil
+}
+
+func (engine *MatchingEngine) Instrument(symbol string) (Instrument, bool) {
+	market, ok := engine.markets[symbol]
+	if !ok {
+		return Instrument{}, false
+	}
+
+	return market.instrument.resolved(), true
+}
+
+// Book returns underlying book of instrument, e.g. to take depth snapshots.
+func (engine *MatchingEngine) Book(symbol string) (*Orderbook, bool) {
+	market, ok := engine.markets[symbol]
+	if !ok {
+		return nil, false
+	}
+
+	return market.book, true
+}
+
+func (engine *MatchingEngine) State(symbol string) (InstrumentState, error) {
+	market, ok := engine.markets[symbol]
+	if !ok {
+		return 0, ErrUnknownInstrument
+	}
+
+	return market.state, nil
+}
+
+// Match validates order against instrument rules and matches it in the book
+// of instrument. Returned trades and rejected order have the same meaning as
+// in Orderbook.Match, while error reports orders which were refused before
+// reaching the book. In auction-only state valid orders are collected by the
+// book for uncross and only IOC and FOK ones are rejected.
+func (engine *MatchingEngine) Match(
+	symbol string,
+	order *Order,
+) ([]*Trade, *Order, error) {
+	market, ok := engine.markets[symbol]
+	if !ok {
+		return nil, nil, ErrUnknownInstrument
+	}
+
+	if market.state == StateHalted {
+		return nil, nil, engine.reject(market, order, ErrHalted)
+	}
+
+	if err := market.instrument.validate(order); err != nil {
+		return nil, nil, engine.reject(market, order, err)
+	}
+
+	trades, reject := market.book.Match(order)
+
+	return trades, reject, nil
+}
+
+// Cancel cancels resting order. Cancellation is allowed in every state of
+// instrument.
+func (engine *MatchingEngine) Cancel(symbol string, id int) (bool, error) {
+	market, ok := engine.markets[symbol]
+	if !ok {
+		return false, ErrUnknownInstrument
+	}
+
+	return market.book.Cancel(id), nil
+}
+
+// Amend validates new volume and price against instrument rules and amends
+// resting order. Amendment is refused unless instrument is trading.
+func (engine *MatchingEngine) Amend(
+	symbol string,
+	id int,
+	volume uint64,
+	price uint64,
+) ([]*Trade, bool, error) {
+	market, ok := engine.markets[symbol]
+	if !ok {
+		return nil, false, ErrUnknownInstrument
+	}
+
+	order, ok := market.book.orders[id]
+	if !ok {
+		return nil, false, nil
+	}
+
+	switch market.state {
+	case StateHalted:
+		return nil, false, engine.reject(market, order, ErrHalted)
+	case StateAuctionOnly:
+		return nil, false, engine.reject(market, order, ErrAuctionOnly)
+	}
+
+	if volume > 0 {
+		amended := *order
+
+		amended.Volume = volume
+		amended.Price = price
+
+		if err := market.instrument.validate(&amended); err != nil {
+			return nil, false, engine.reject(market, order, err)
+		}
+	}
+
+	trades, ok := market.book.Amend(id, volume, price)
+
+	return trades, ok, nil
+}
+
+// Halt stops accepting orders and amendments for instrument. Orders collected
+// in auction-only state are kept until trading resumes.
+func (engine *MatchingEngine) Halt(symbol string) error {
+	return engine.transit(symbol, StateHalted)
+}
+
+// AuctionOnly starts call phase of instrument book: orders are accepted
+// without matching them until trading resumes.
+func (engine *MatchingEngine) AuctionOnly(symbol string) error {
+	if err := engine.transit(symbol, StateAuctionOnly); err != nil {
+		return err
+	}
+
+	market := engine.markets[symbol]
+
+	if !market.book.Auction() {
+		market.book.BeginAuction(0)
+	}
+
+	return nil
+}
+
+// Resume returns instrument to continuous trading and uncrosses orders
+// collected in auction-only state. It returns resulting trades and rejected
+// market orders.
+func (engine *MatchingEngine) Resume(symbol string) ([]*Trade, []*Order, error) {
+	if err := engine.transit(symbol, StateTrading); err != nil {
+		return nil, nil, err
+	}
+
+	trades, rejects := engine.markets[symbol].book.Uncross()
+
+	return trades, rejects, nil
+}
+
+func (engine *MatchingEngine) transit(
+	symbol string,
+	state InstrumentState,
+) error {
+	market, ok := engine.markets[symbol]
+	if !ok {
+		return ErrUnknownInstrument
+	}
+
+	market.state = state
+
+	return nil
+}
+
+func (engine *MatchingEngine) reject(
+	market *market,
+	order *Order,
+	reason error,
+) error {
+	return &RejectError{
+		Symbol: market.instrument.Symbol,
+		Order:  order,
+		Reason: reason,
+	}
+}
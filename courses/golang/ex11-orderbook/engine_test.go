@@ -0,0 +1,243 @@
+package orderbook
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngine_Routing(t *testing.T) {
+	test := assert.New(t)
+
+	engine := NewMatchingEngine()
+
+	test.NoError(engine.AddInstrument(Instrument{Symbol: "BTCUSD"}))
+	test.NoError(engine.AddInstrument(Instrument{Symbol: "ETHUSD"}))
+	test.ErrorIs(
+		engine.AddInstrument(Instrument{Symbol: "BTCUSD"}),
+		ErrDuplicateInstrument,
+	)
+
+	_, _, err := engine.Match("BTCUSD", &Order{
+		ID:     1,
+		Side:   SideAsk,
+		Kind:   KindLimit,
+		Volume: 10000,
+		Price:  60000000,
+	})
+	test.NoError(err)
+
+	trades, reject, err := engine.Match("ETHUSD", &Order{
+		ID:     2,
+		Side:   SideBid,
+		Kind:   KindMarket,
+		Volume: 10000,
+	})
+	test.NoError(err)
+	test.Empty(trades)
+	test.NotNil(reject)
+
+	trades, reject, err = engine.Match("BTCUSD", &Order{
+		ID:     3,
+		Side:   SideBid,
+		Kind:   KindMarket,
+		Volume: 10000,
+	})
+	test.NoError(err)
+	test.Len(trades, 1)
+	test.Nil(reject)
+
+	_, _, err = engine.Match("XRPUSD", &Order{ID: 4})
+	test.ErrorIs(err, ErrUnknownInstrument)
+
+	instrument, ok := engine.Instrument("BTCUSD")
+	test.True(ok)
+	if test.NotNil(instrument.Precision) {
+		test.Equal(uint8(DefaultPrecision), *instrument.Precision)
+	}
+
+	test.Equal(uint64(10000), instrument.Scale())
+}
+
+func TestEngine_Rules(t *testing.T) {
+	test := assert.New(t)
+
+	engine := NewMatchingEngine()
+
+	places := uint8(2)
+
+	test.NoError(engine.AddInstrument(Instrument{
+		Symbol:      "BTCUSD",
+		Precision:   &places,
+		TickSize:    50,
+		LotSize:     10,
+		MinNotional: 1000,
+		MaxNotional: 1000000,
+	}))
+
+	for i, testcase := range []struct {
+		order  Order
+		reason error
+	}{
+		{Order{Kind: KindLimit, Volume: 100, Price: 1000}, nil},
+		{Order{Kind: KindLimit, Volume: 0, Price: 1000}, ErrZeroVolume},
+		{Order{Kind: KindLimit, Volume: 105, Price: 1000}, ErrLotSize},
+		{Order{Kind: KindLimit, Volume: 100, Price: 1010}, ErrTickSize},
+		{Order{Kind: KindLimit, Volume: 10, Price: 1000}, ErrMinNotional},
+		{Order{Kind: KindLimit, Volume: 200000, Price: 1000}, ErrMaxNotional},
+		{Order{Kind: KindMarket, Volume: 200000}, nil},
+		{Order{Kind: KindStop, Volume: 100, StopPrice: 1020}, ErrTickSize},
+		{Order{Kind: KindStopLimit, Volume: 100, StopPrice: 1050, Price: 1000}, nil},
+	} {
+		order := testcase.order
+		order.ID = i + 1
+		order.Side = SideBid
+
+		_, _, err := engine.Match("BTCUSD", &order)
+
+		if testcase.reason == nil {
+			test.NoError(err, "%+v", testcase.order)
+
+			continue
+		}
+
+		test.ErrorIs(err, testcase.reason, "%+v", testcase.order)
+
+		var reject *RejectError
+
+		if test.True(errors.As(err, &reject)) {
+			test.Equal("BTCUSD", reject.Symbol)
+		}
+	}
+
+	// Instrument traded in whole units has zero precision.
+	places = 0
+
+	test.NoError(engine.AddInstrument(Instrument{
+		Symbol:      "SHARE",
+		Precision:   &places,
+		MinNotional: 1000,
+	}))
+
+	places = 4
+
+	instrument, _ := engine.Instrument("SHARE")
+	if test.NotNil(instrument.Precision) {
+		test.Equal(uint8(0), *instrument.Precision, "precision is copied")
+	}
+
+	_, _, err := engine.Match("SHARE", &Order{ID: 100, Side: SideBid, Kind: KindLimit, Volume: 10, Price: 99})
+	test.ErrorIs(err, ErrMinNotional)
+
+	_, _, err = engine.Match("SHARE", &Order{ID: 101, Side: SideBid, Kind: KindLimit, Volume: 10, Price: 100})
+	test.NoError(err)
+
+	places = 20
+
+	test.ErrorIs(engine.AddInstrument(Instrument{Symbol: "WIDE", Precision: &places}), ErrInvalidInstrument)
+}
+
+func TestEngine_Amend_Rules(t *testing.T) {
+	test := assert.New(t)
+
+	engine := NewMatchingEngine()
+
+	test.NoError(engine.AddInstrument(Instrument{Symbol: "BTCUSD", TickSize: 100}))
+
+	_, _, err := engine.Match("BTCUSD", &Order{
+		ID:     1,
+		Side:   SideBid,
+		Kind:   KindLimit,
+		Volume: 10000,
+		Price:  60000000,
+	})
+	test.NoError(err)
+
+	_, ok, err := engine.Amend("BTCUSD", 1, 10000, 60000050)
+	test.ErrorIs(err, ErrTickSize)
+	test.False(ok)
+
+	_, ok, err = engine.Amend("BTCUSD", 1, 5000, 60000100)
+	test.NoError(err)
+	test.True(ok)
+}
+
+func TestEngine_States(t *testing.T) {
+	test := assert.New(t)
+
+	engine := NewMatchingEngine()
+
+	test.NoError(engine.AddInstrument(Instrument{Symbol: "BTCUSD"}))
+
+	bid := &Order{ID: 1, Side: SideBid, Kind: KindLimit, Volume: 10000, Price: 60000000}
+
+	_, _, err := engine.Match("BTCUSD", bid)
+	test.NoError(err)
+
+	test.NoError(engine.Halt("BTCUSD"))
+
+	_, _, err = engine.Match("BTCUSD", &Order{
+		ID:     2,
+		Side:   SideAsk,
+		Kind:   KindMarket,
+		Volume: 10000,
+	})
+	test.ErrorIs(err, ErrHalted)
+
+	_, ok, err := engine.Amend("BTCUSD", 1, 5000, 60000000)
+	test.ErrorIs(err, ErrHalted)
+	test.False(ok)
+
+	test.NoError(engine.AuctionOnly("BTCUSD"))
+
+	state, err := engine.State("BTCUSD")
+	test.NoError(err)
+	test.Equal(StateAuctionOnly, state)
+
+	trades, reject, err := engine.Match("BTCUSD", &Order{
+		ID:     3,
+		Side:   SideAsk,
+		Kind:   KindLimit,
+		Volume: 5000,
+		Price:  59000000,
+	})
+	test.NoError(err)
+	test.Empty(trades)
+	test.Nil(reject)
+
+	_, _, err = engine.Match("BTCUSD", &Order{
+		ID:     4,
+		Side:   SideAsk,
+		Kind:   KindLimit,
+		Volume: 5000,
+		Price:  59000000,
+	})
+	test.NoError(err)
+
+	ok, err = engine.Cancel("BTCUSD", 4)
+	test.NoError(err)
+	test.True(ok)
+
+	_, _, err = engine.Match("BTCUSD", &Order{
+		ID:     5,
+		Side:   SideAsk,
+		Kind:   KindMarket,
+		Volume: 10000,
+	})
+	test.NoError(err)
+
+	trades, rejects, err := engine.Resume("BTCUSD")
+	test.NoError(err)
+	test.Len(trades, 1)
+	test.Equal(5, trades[0].Ask.ID)
+	test.Equal(uint64(10000), trades[0].Volume)
+	test.Equal(uint64(59000000), trades[0].Price)
+	test.Empty(rejects)
+
+	book, _ := engine.Book("BTCUSD")
+	test.Equal(
+		[]DepthLevel{{Price: 59000000, Volume: 5000, Count: 1}},
+		book.Snapshot(0).Asks,
+	)
+}
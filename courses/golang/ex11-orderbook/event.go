@@ -0,0 +1,300 @@
+package orderbook
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math/bits"
+	"os"
+	"sync"
+)
+
+type EventType int8
+
+const (
+	EventAccepted        EventType = 1
+	EventRested          EventType = 2
+	EventPartiallyFilled EventType = 3
+	EventFilled          EventType = 4
+	EventCancelled       EventType = 5
+	EventRejected        EventType = 6
+	EventAmended         EventType = 7
+)
+
+func (kind EventType) String() string {
+	switch kind {
+	case EventAccepted:
+		return "ACCEPTED"
+	case EventRested:
+		return "RESTED"
+	case EventPartiallyFilled:
+		return "PARTIALLY_FILLED"
+	case EventFilled:
+		return "FILLED"
+	case EventCancelled:
+		return "CANCELLED"
+	case EventRejected:
+		return "REJECTED"
+	case EventAmended:
+		return "AMENDED"
+	}
+
+	return "UNKNOWN"
+}
+
+// Event is execution report of single order. Incoming order is accepted
+// first, then it's partially filled or filled by every trade, and finally it
+// either rests, or is rejected. Resting order can be filled or cancelled
+// later. Every change of open order's volume or price other than fill, i.e.
+// by Amend or by decrementing self-trade prevention, is reported as amended
+// with new Price and Leaves. Repriced order then continues as incoming one:
+// it's filled and rests or is rejected.
+type Event struct {
+	Sequence uint64
+	Type     EventType
+
+	OrderID int
+	Side    Side
+	Price   uint64
+
+	// LastVolume and LastPrice describe trade of fill events.
+	LastVolume uint64
+	LastPrice  uint64
+
+	// Leaves is volume which is still open, it's zero once order is filled,
+	// cancelled or rejected. Cumulative is total filled volume, executed at
+	// volume-weighted AveragePrice, which is rounded down.
+	Leaves       uint64
+	Cumulative   uint64
+	AveragePrice uint64
+
+	Reason Reason
+}
+
+// Listener receives events synchronously from the goroutine which calls the
+// book, so it should be fast.
+type Listener interface {
+	OnEvent(event Event)
+}
+
+// WithListener adds listener of execution events.
+func WithListener(listener Listener) Option {
+	return func(orderbook *Orderbook) {
+		orderbook.listeners = append(orderbook.listeners, listener)
+	}
+}
+
+// execution accumulates fills of order for average price: notional is
+// 128-bit sum of volume multiplied by price.
+type execution struct {
+	filled   uint64
+	notional [2]uint64
+}
+
+func (execution *execution) add(volume uint64, price uint64) {
+	high, low := bits.Mul64(volume, price)
+
+	var carry uint64
+
+	execution.filled += volume
+	execution.notional[1], carry = bits.Add64(execution.notional[1], low, 0)
+	execution.notional[0], _ = bits.Add64(execution.notional[0], high, carry)
+}
+
+func (execution *execution) average() uint64 {
+	if execution.filled == 0 || execution.notional[0] >= execution.filled {
+		return 0
+	}
+
+	average, _ := bits.Div64(
+		execution.notional[0],
+		execution.notional[1],
+		execution.filled,
+	)
+
+	return average
+}
+
+// emit reports event of order to listeners.
+func (orderbook *Orderbook) emit(kind EventType, order *Order) {
+	orderbook.report(kind, order, 0, 0)
+}
+
+// executed accounts fill of order and reports it to listeners.
+func (orderbook *Orderbook) executed(
+	order *Order,
+	volume uint64,
+	price uint64,
+) {
+	order.execution.add(volume, price)
+
+	kind := EventPartiallyFilled
+	if order.Volume == 0 {
+		kind = EventFilled
+	}
+
+	orderbook.report(kind, order, volume, price)
+}
+
+func (orderbook *Orderbook) report(
+	kind EventType,
+	order *Order,
+	volume uint64,
+	price uint64,
+) {
+	if len(orderbook.listeners) == 0 {
+		return
+	}
+
+	orderbook.events++
+
+	event := Event{
+		Sequence:     orderbook.events,
+		Type:         kind,
+		OrderID:      order.ID,
+		Side:         order.Side,
+		Price:        order.Price,
+		LastVolume:   volume,
+		LastPrice:    price,
+		Leaves:       order.Volume,
+		Cumulative:   order.execution.filled,
+		AveragePrice: order.execution.average(),
+	}
+
+	if kind == EventCancelled || kind == EventRejected {
+		event.Leaves = 0
+		event.Reason = order.Reason
+	}
+
+	for _, listener := range orderbook.listeners {
+		listener.OnEvent(event)
+	}
+}
+
+// Recorder is listener which keeps every event in memory.
+type Recorder struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+func (recorder *Recorder) OnEvent(event Event) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	recorder.events = append(recorder.events, event)
+}
+
+// Events returns copy of recorded events.
+func (recorder *Recorder) Events() []Event {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	return append([]Event{}, recorder.events...)
+}
+
+func (recorder *Recorder) Reset() {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	recorder.events = nil
+}
+
+// JSONLines is listener which writes every event as single line of JSON.
+// Writes are buffered, call Flush or Close to make them durable. The first
+// write error stops writing and is returned by Flush and Close.
+type JSONLines struct {
+	writer *bufio.Writer
+	closer io.Closer
+	err    error
+}
+
+type jsonEvent struct {
+	Sequence     uint64 `json:"sequence"`
+	Type         string `json:"type"`
+	OrderID      int    `json:"order_id"`
+	Side         string `json:"side"`
+	Price        uint64 `json:"price"`
+	LastVolume   uint64 `json:"last_volume,omitempty"`
+	LastPrice    uint64 `json:"last_price,omitempty"`
+	Leaves       uint64 `json:"leaves"`
+	Cumulative   uint64 `json:"cumulative"`
+	AveragePrice uint64 `json:"average_price"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+func NewJSONLines(writer io.Writer) *JSONLines {
+	return &JSONLines{
+		writer: bufio.NewWriter(writer),
+	}
+}
+
+// OpenJSONLines appends events to file at specified path.
+func OpenJSONLines(path string) (*JSONLines, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := NewJSONLines(file)
+
+	sink.closer = file
+
+	return sink, nil
+}
+
+func (sink *JSONLines) OnEvent(event Event) {
+	if sink.err != nil {
+		return
+	}
+
+	line := jsonEvent{
+		Sequence:     event.Sequence,
+		Type:         event.Type.String(),
+		OrderID:      event.OrderID,
+		Side:         event.Side.String(),
+		Price:        event.Price,
+		LastVolume:   event.LastVolume,
+		LastPrice:    event.LastPrice,
+		Leaves:       event.Leaves,
+		Cumulative:   event.Cumulative,
+		AveragePrice: event.AveragePrice,
+	}
+
+	if event.Reason != ReasonNone {
+		line.Reason = event.Reason.String()
+	}
+
+	encoded, err := json.Marshal(line)
+	if err != nil {
+		sink.err = err
+
+		return
+	}
+
+	if _, sink.err = sink.writer.Write(encoded); sink.err == nil {
+		sink.err = sink.writer.WriteByte('\n')
+	}
+}
+
+func (sink *JSONLines) Flush() error {
+	if sink.err != nil {
+		return sink.err
+	}
+
+	sink.err = sink.writer.Flush()
+
+	return sink.err
+}
+
+// Close flushes events and closes file opened by OpenJSONLines.
+func (sink *JSONLines) Close() error {
+	err := sink.Flush()
+
+	if sink.closer != nil {
+		if closeErr := sink.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
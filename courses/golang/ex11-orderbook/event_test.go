@@ -0,0 +1,186 @@
+package orderbook
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvent_Lifecycle(t *testing.T) {
+	test := assert.New(t)
+
+	recorder := &Recorder{}
+	book := New(WithListener(recorder))
+
+	book.Match(&Order{ID: 1, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 60000000})
+	book.Match(&Order{ID: 2, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 61000000})
+	book.Match(&Order{ID: 3, Side: SideBid, Kind: KindLimit, Volume: 15000, Price: 61000000})
+	book.Cancel(2)
+	book.Match(&Order{ID: 4, Side: SideBid, Kind: KindMarket, Volume: 5000})
+
+	expected := []Event{
+		{Type: EventAccepted, OrderID: 1, Side: SideAsk, Price: 60000000, Leaves: 10000},
+		{Type: EventRested, OrderID: 1, Side: SideAsk, Price: 60000000, Leaves: 10000},
+		{Type: EventAccepted, OrderID: 2, Side: SideAsk, Price: 61000000, Leaves: 10000},
+		{Type: EventRested, OrderID: 2, Side: SideAsk, Price: 61000000, Leaves: 10000},
+		{Type: EventAccepted, OrderID: 3, Side: SideBid, Price: 61000000, Leaves: 15000},
+		{
+			Type:         EventPartiallyFilled,
+			OrderID:      3,
+			Side:         SideBid,
+			Price:        61000000,
+			LastVolume:   10000,
+			LastPrice:    60000000,
+			Leaves:       5000,
+			Cumulative:   10000,
+			AveragePrice: 60000000,
+		},
+		{
+			Type:         EventFilled,
+			OrderID:      1,
+			Side:         SideAsk,
+			Price:        60000000,
+			LastVolume:   10000,
+			LastPrice:    60000000,
+			Cumulative:   10000,
+			AveragePrice: 60000000,
+		},
+		{
+			Type:         EventFilled,
+			OrderID:      3,
+			Side:         SideBid,
+			Price:        61000000,
+			LastVolume:   5000,
+			LastPrice:    61000000,
+			Cumulative:   15000,
+			AveragePrice: 60333333,
+		},
+		{
+			Type:         EventPartiallyFilled,
+			OrderID:      2,
+			Side:         SideAsk,
+			Price:        61000000,
+			LastVolume:   5000,
+			LastPrice:    61000000,
+			Leaves:       5000,
+			Cumulative:   5000,
+			AveragePrice: 61000000,
+		},
+		{
+			Type:         EventCancelled,
+			OrderID:      2,
+			Side:         SideAsk,
+			Price:        61000000,
+			Cumulative:   5000,
+			AveragePrice: 61000000,
+			Reason:       ReasonCancelled,
+		},
+		{Type: EventAccepted, OrderID: 4, Side: SideBid, Leaves: 5000},
+		{Type: EventRejected, OrderID: 4, Side: SideBid, Reason: ReasonNoLiquidity},
+	}
+
+	for i := range expected {
+		expected[i].Sequence = uint64(i + 1)
+	}
+
+	test.Equal(expected, recorder.Events())
+
+	recorder.Reset()
+	test.Empty(recorder.Events())
+}
+
+func TestEvent_Amended(t *testing.T) {
+	test := assert.New(t)
+
+	recorder := &Recorder{}
+	book := New(WithListener(recorder))
+
+	book.Match(&Order{ID: 1, Side: SideAsk, Kind: KindLimit, Account: 1, Volume: 10, Price: 100})
+	book.Match(&Order{ID: 2, Side: SideBid, Kind: KindStopLimit, Volume: 5, Price: 150, StopPrice: 200})
+
+	recorder.Reset()
+
+	book.Amend(2, 3, 160)
+	book.Amend(1, 8, 100)
+	book.Match(&Order{ID: 3, Side: SideBid, Kind: KindLimit, Volume: 5, Price: 90})
+	book.Amend(3, 5, 100)
+	book.Match(&Order{ID: 4, Side: SideBid, Kind: KindLimit, Account: 1, SelfTrade: SelfTradeDecrement, Volume: 1, Price: 100})
+
+	expected := []Event{
+		{Type: EventAmended, OrderID: 2, Side: SideBid, Price: 160, Leaves: 3},
+		{Type: EventAmended, OrderID: 1, Side: SideAsk, Price: 100, Leaves: 8},
+		{Type: EventAccepted, OrderID: 3, Side: SideBid, Price: 90, Leaves: 5},
+		{Type: EventRested, OrderID: 3, Side: SideBid, Price: 90, Leaves: 5},
+		{Type: EventAmended, OrderID: 3, Side: SideBid, Price: 100, Leaves: 5},
+		{
+			Type:         EventFilled,
+			OrderID:      3,
+			Side:         SideBid,
+			Price:        100,
+			LastVolume:   5,
+			LastPrice:    100,
+			Cumulative:   5,
+			AveragePrice: 100,
+		},
+		{
+			Type:         EventPartiallyFilled,
+			OrderID:      1,
+			Side:         SideAsk,
+			Price:        100,
+			LastVolume:   5,
+			LastPrice:    100,
+			Leaves:       3,
+			Cumulative:   5,
+			AveragePrice: 100,
+		},
+		{Type: EventAccepted, OrderID: 4, Side: SideBid, Price: 100, Leaves: 1},
+		{Type: EventAmended, OrderID: 1, Side: SideAsk, Price: 100, Leaves: 2, Cumulative: 5, AveragePrice: 100},
+		{Type: EventRejected, OrderID: 4, Side: SideBid, Price: 100, Reason: ReasonSelfTrade},
+	}
+
+	events := recorder.Events()
+
+	for i := range events {
+		events[i].Sequence = 0
+	}
+
+	test.Equal(expected, events)
+}
+
+func TestEvent_JSONLines(t *testing.T) {
+	test := assert.New(t)
+
+	buffer := &bytes.Buffer{}
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+
+	file, err := OpenJSONLines(path)
+	test.NoError(err)
+
+	book := New(WithListener(NewJSONLines(buffer)), WithListener(file))
+
+	book.Match(&Order{ID: 1, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 60000000})
+	book.Match(&Order{ID: 2, Side: SideBid, Kind: KindLimit, Volume: 10000, Price: 60000000, TimeInForce: TimeInForceFOK})
+	book.Match(&Order{ID: 3, Side: SideBid, Kind: KindMarket, Volume: 5000})
+
+	test.NoError(file.Close())
+
+	lines := []string{
+		`{"sequence":1,"type":"ACCEPTED","order_id":1,"side":"ASK","price":60000000,"leaves":10000,"cumulative":0,"average_price":0}`,
+		`{"sequence":2,"type":"RESTED","order_id":1,"side":"ASK","price":60000000,"leaves":10000,"cumulative":0,"average_price":0}`,
+		`{"sequence":3,"type":"ACCEPTED","order_id":2,"side":"BID","price":60000000,"leaves":10000,"cumulative":0,"average_price":0}`,
+		`{"sequence":4,"type":"FILLED","order_id":2,"side":"BID","price":60000000,"last_volume":10000,"last_price":60000000,"leaves":0,"cumulative":10000,"average_price":60000000}`,
+		`{"sequence":5,"type":"FILLED","order_id":1,"side":"ASK","price":60000000,"last_volume":10000,"last_price":60000000,"leaves":0,"cumulative":10000,"average_price":60000000}`,
+		`{"sequence":6,"type":"ACCEPTED","order_id":3,"side":"BID","price":0,"leaves":5000,"cumulative":0,"average_price":0}`,
+		`{"sequence":7,"type":"REJECTED","order_id":3,"side":"BID","price":0,"leaves":0,"cumulative":0,"average_price":0,"reason":"NO_LIQUIDITY"}`,
+	}
+
+	written, err := os.ReadFile(path)
+	test.NoError(err)
+	test.Equal(strings.Join(lines, "\n")+"\n", string(written))
+
+	test.Empty(buffer.String(), "events are buffered until flush")
+}
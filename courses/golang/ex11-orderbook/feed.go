@@ -0,0 +1,144 @@
+package orderbook
+
+type UpdateAction int8
+
+const (
+	UpdateAdd    UpdateAction = 1
+	UpdateChange UpdateAction = 2
+	UpdateRemove UpdateAction = 3
+)
+
+func (action UpdateAction) String() string {
+	switch action {
+	case UpdateAdd:
+		return "ADD"
+	case UpdateChange:
+		return "CHANGE"
+	case UpdateRemove:
+		return "REMOVE"
+	}
+
+	return "UNKNOWN"
+}
+
+// Update is incremental change of aggregated price level. Applying updates
+// with sequence greater than sequence of depth snapshot to that snapshot
+// reproduces current state of the book.
+type Update struct {
+	Sequence uint64
+	Action   UpdateAction
+
+	Side   Side
+	Price  uint64
+	Volume uint64
+	Count  int
+}
+
+type subscriber struct {
+	id      int
+	handler func(Update)
+}
+
+type feed struct {
+	sequence uint64
+
+	subscribers []subscriber
+	subscribed  int
+
+	dirty []*level
+}
+
+// Subscribe registers handler which is called synchronously with every depth
+// update produced by Match, Cancel or Amend. Returned function removes the
+// subscription.
+func (orderbook *Orderbook) Subscribe(handler func(Update)) func() {
+	feed := &orderbook.feed
+
+	feed.subscribed++
+
+	id := feed.subscribed
+
+	feed.subscribers = append(
+		feed.subscribers,
+		subscriber{id: id, handler: handler},
+	)
+
+	return func() {
+		for i, subscriber := range feed.subscribers {
+			if subscriber.id == id {
+				feed.subscribers = append(
+					feed.subscribers[:i],
+					feed.subscribers[i+1:]...,
+				)
+
+				return
+			}
+		}
+	}
+}
+
+func (feed *feed) touch(level *level) {
+	if level.dirty {
+		return
+	}
+
+	level.dirty = true
+
+	feed.dirty = append(feed.dirty, level)
+}
+
+// flush publishes changes of every level touched since previous flush and
+// returns removed levels to the pool.
+func (feed *feed) flush(pool *levelPool) {
+	for i, level := range feed.dirty {
+		level.dirty = false
+
+		feed.dirty[i] = nil
+
+		if update, ok := feed.publish(level); ok {
+			for _, subscriber := range feed.subscribers {
+				subscriber.handler(update)
+			}
+		}
+
+		if level.count == 0 {
+			pool.put(level)
+		}
+	}
+
+	feed.dirty = feed.dirty[:0]
+}
+
+// publish returns update which brings subscribers to the current state of
+// level, if there is any difference.
+func (feed *feed) publish(level *level) (Update, bool) {
+	update := Update{
+		Side:   level.side,
+		Price:  level.price,
+		Volume: level.volume,
+		Count:  level.count,
+	}
+
+	switch {
+	case level.count == 0 && level.published:
+		update.Action = UpdateRemove
+	case level.count == 0:
+		return update, false
+	case !level.published:
+		update.Action = UpdateAdd
+	case level.shown != level.volume || level.shownCount != level.count:
+		update.Action = UpdateChange
+	default:
+		return update, false
+	}
+
+	level.published = level.count > 0
+	level.shown = level.volume
+	level.shownCount = level.count
+
+	feed.sequence++
+
+	update.Sequence = feed.sequence
+
+	return update, true
+}
@@ -0,0 +1,287 @@
+package orderbook
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// operationSize is number of fuzz input bytes decoded into single operation.
+const operationSize = 4
+
+type operation struct {
+	action Action
+	order  Order
+}
+
+// decodeOperations turns arbitrary bytes into sequence of limit, market and
+// cancel operations. Prices are taken from narrow range around 100.0000, so
+// orders often cross and share price levels, and cancellations target
+// orders which were already submitted.
+func decodeOperations(data []byte) []operation {
+	var operations []operation
+
+	for id := 1; len(data) >= operationSize; data = data[operationSize:] {
+		code, price, volume, target := data[0], data[1], data[2], data[3]
+
+		if code%5 == 4 {
+			operations = append(operations, operation{
+				action: ActionCancel,
+				order:  Order{ID: 1 + int(target)%id},
+			})
+
+			continue
+		}
+
+		order := Order{
+			ID:     id,
+			Side:   SideBid,
+			Kind:   KindLimit,
+			Volume: (1 + uint64(volume)%16) * 1000,
+			Price:  (90 + uint64(price)%21) * 10000,
+		}
+
+		if code%2 == 1 {
+			order.Side = SideAsk
+		}
+
+		if code%5 >= 2 {
+			order.Kind = KindMarket
+			order.Price = 0
+		}
+
+		operations = append(operations, operation{action: ActionNew, order: order})
+
+		id++
+	}
+
+	return operations
+}
+
+// checker drives operations through the book and the reference
+// implementation and checks invariants after every step:
+//
+//   - book is never crossed;
+//   - volume of every order is either traded, resting, or removed by
+//     rejection or cancellation;
+//   - incoming order consumes resting orders in price-time priority;
+//   - market order is rejected only if it exhausts the opposite side;
+//   - trades, rejections and depth are the same as in reference book.
+type checker struct {
+	book      *Orderbook
+	reference *reference
+
+	// submitted is initial volume of every order, and traded and removed
+	// are its volume which was traded or left the book otherwise.
+	submitted map[int]uint64
+	traded    map[int]uint64
+	removed   map[int]uint64
+}
+
+func newChecker() *checker {
+	return &checker{
+		book:      New(),
+		reference: &reference{},
+		submitted: map[int]uint64{},
+		traded:    map[int]uint64{},
+		removed:   map[int]uint64{},
+	}
+}
+
+func (checker *checker) run(operations []operation) error {
+	for step, operation := range operations {
+		if err := checker.step(operation); err != nil {
+			return fmt.Errorf("step %d (%s %+v): %w", step, operation.action, operation.order, err)
+		}
+	}
+
+	return nil
+}
+
+func (checker *checker) step(operation operation) error {
+	if operation.action == ActionCancel {
+		return checker.cancel(operation.order.ID)
+	}
+
+	order := operation.order
+	before := checker.book.SnapshotOrders(0)
+
+	checker.submitted[order.ID] = order.Volume
+
+	expected, rejected := checker.reference.match(referenceOrder{
+		id:     order.ID,
+		side:   order.Side,
+		market: order.Kind == KindMarket,
+		volume: order.Volume,
+		price:  order.Price,
+	})
+
+	trades, reject := checker.book.Match(&order)
+
+	var actual []referenceTrade
+
+	for _, trade := range trades {
+		actual = append(actual, referenceTrade{
+			bid:    trade.Bid.ID,
+			ask:    trade.Ask.ID,
+			volume: trade.Volume,
+			price:  trade.Price,
+		})
+
+		checker.traded[trade.Bid.ID] += trade.Volume
+		checker.traded[trade.Ask.ID] += trade.Volume
+	}
+
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		return fmt.Errorf("trades %v, reference %v", actual, expected)
+	}
+
+	if (reject != nil) != rejected {
+		return fmt.Errorf("rejected %v, reference %v", reject != nil, rejected)
+	}
+
+	if reject != nil {
+		checker.removed[order.ID] = reject.Volume
+
+		if order.Kind != KindMarket || reject.Reason != ReasonNoLiquidity {
+			return fmt.Errorf("unexpected rejection %s", reject.Reason)
+		}
+
+		if depth := checker.book.Snapshot(1); len(checker.levels(depth, order.Side, true)) > 0 {
+			return fmt.Errorf("market order rejected while opposite side is not empty")
+		}
+	}
+
+	if err := checker.priority(before, order.Side, trades); err != nil {
+		return err
+	}
+
+	return checker.invariants()
+}
+
+func (checker *checker) cancel(id int) error {
+	var volume uint64
+
+	for _, side := range []Side{SideBid, SideAsk} {
+		for _, order := range *checker.reference.side(side) {
+			if order.id == id {
+				volume = order.volume
+			}
+		}
+	}
+
+	ok := checker.book.Cancel(id)
+
+	if expected := checker.reference.cancel(id); ok != expected {
+		return fmt.Errorf("cancelled %v, reference %v", ok, expected)
+	}
+
+	if ok {
+		checker.removed[id] = volume
+	}
+
+	return checker.invariants()
+}
+
+// levels returns levels of the same side as specified one, or of the
+// opposite one.
+func (checker *checker) levels(depth *Depth, side Side, opposite bool) []DepthLevel {
+	if (side == SideBid) != opposite {
+		return depth.Bids
+	}
+
+	return depth.Asks
+}
+
+// priority checks that trades of incoming order consumed resting orders of
+// the opposite side in their priority order before the step: every order but
+// the last one is filled completely.
+func (checker *checker) priority(before *Depth, side Side, trades []*Trade) error {
+	var queue []DepthOrder
+
+	for _, level := range checker.levels(before, side, true) {
+		queue = append(queue, level.Orders...)
+	}
+
+	for i, trade := range trades {
+		resting := trade.Ask
+		if side == SideAsk {
+			resting = trade.Bid
+		}
+
+		if i >= len(queue) || queue[i].ID != resting.ID {
+			return fmt.Errorf("trade %d is against order %d out of priority", i, resting.ID)
+		}
+
+		if i < len(trades)-1 && trade.Volume != queue[i].Volume {
+			return fmt.Errorf("order %d is left partially filled", resting.ID)
+		}
+	}
+
+	return nil
+}
+
+func (checker *checker) invariants() error {
+	depth := checker.book.SnapshotOrders(0)
+
+	if len(depth.Bids) > 0 && len(depth.Asks) > 0 &&
+		depth.Bids[0].Price >= depth.Asks[0].Price {
+		return fmt.Errorf("book is crossed: %d >= %d", depth.Bids[0].Price, depth.Asks[0].Price)
+	}
+
+	resting := map[int]uint64{}
+
+	for _, side := range []Side{SideBid, SideAsk} {
+		levels := checker.levels(depth, side, false)
+		expected := checker.reference.depth(side)
+
+		if len(levels) != len(expected) {
+			return fmt.Errorf("%s levels %d, reference %d", side, len(levels), len(expected))
+		}
+
+		for i, level := range levels {
+			for _, order := range level.Orders {
+				resting[order.ID] += order.Volume
+			}
+
+			if level.Price != expected[i].Price ||
+				level.Volume != expected[i].Volume ||
+				level.Count != expected[i].Count {
+				return fmt.Errorf("%s level %+v, reference %+v", side, level, expected[i])
+			}
+		}
+	}
+
+	for id, volume := range checker.submitted {
+		if total := checker.traded[id] + resting[id] + checker.removed[id]; total != volume {
+			return fmt.Errorf("order %d volume %d is not conserved: %d", id, volume, total)
+		}
+	}
+
+	return nil
+}
+
+func FuzzOrderbook(f *testing.F) {
+	f.Add([]byte{0, 10, 5, 0, 1, 10, 5, 0})
+	f.Add([]byte{0, 5, 3, 0, 0, 7, 3, 0, 1, 12, 9, 0, 3, 0, 15, 0, 4, 0, 0, 1})
+	f.Add([]byte{1, 20, 15, 0, 1, 19, 2, 0, 2, 0, 4, 0, 4, 0, 0, 1, 0, 0, 15, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := newChecker().run(decodeOperations(data)); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestOrderbook_Properties(t *testing.T) {
+	for seed := int64(1); seed <= 200; seed++ {
+		random := rand.New(rand.NewSource(seed))
+
+		data := make([]byte, operationSize*300)
+		random.Read(data)
+
+		if err := newChecker().run(decodeOperations(data)); err != nil {
+			t.Fatalf("seed %d: %s", seed, err)
+		}
+	}
+}
@@ -0,0 +1,503 @@
+package orderbook
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrSessionActive = errors.New("session is already active")
+
+// Gateway accepts order-entry sessions over TCP and routes their orders to
+// the book. Each session logs on with unique sender ID and receives
+// execution reports of its orders: ack when order is accepted, amended or
+// held, partial fill and fill for every trade, reject when order or its
+// remainder is rejected, and cancel when order is cancelled.
+//
+// New, cancel and amend orders of client and execution reports of gateway
+// are sequenced per session starting from 1. Gateway ignores client messages
+// with already processed sequence numbers and answers to gaps with resend
+// request. Execution reports are kept for the lifetime of gateway, and are
+// resent from sequence number requested on logon or by resend request, so
+// session can reconnect without losing reports. Both sides send heartbeat if
+// they have nothing to send during heartbeat interval, and gateway closes
+// connection which is silent for two intervals.
+type Gateway struct {
+	book      *Orderbook
+	heartbeat time.Duration
+
+	mutex    sync.Mutex
+	sessions map[string]*session
+	orders   map[int]*entry
+	sequence int
+	touched  []*entry
+
+	listeners   map[net.Listener]struct{}
+	connections map[net.Conn]struct{}
+	closed      bool
+	wait        sync.WaitGroup
+}
+
+// entry is order of session in the book.
+type entry struct {
+	session *session
+	id      int
+	order   *Order
+
+	leaves     uint64
+	cumulative uint64
+	touched    bool
+}
+
+func NewGateway(book *Orderbook, heartbeat time.Duration) *Gateway {
+	return &Gateway{
+		book:        book,
+		heartbeat:   heartbeat,
+		sessions:    map[string]*session{},
+		orders:      map[int]*entry{},
+		listeners:   map[net.Listener]struct{}{},
+		connections: map[net.Conn]struct{}{},
+	}
+}
+
+// Serve accepts connections speaking specified protocol until listener is
+// closed or gateway is closed, in which case it returns ErrClosed.
+func (gateway *Gateway) Serve(listener net.Listener, protocol Protocol) error {
+	gateway.mutex.Lock()
+
+	if gateway.closed {
+		gateway.mutex.Unlock()
+
+		return ErrClosed
+	}
+
+	gateway.listeners[listener] = struct{}{}
+
+	gateway.mutex.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			gateway.mutex.Lock()
+			defer gateway.mutex.Unlock()
+
+			delete(gateway.listeners, listener)
+
+			if gateway.closed {
+				return ErrClosed
+			}
+
+			return err
+		}
+
+		if !gateway.track(conn) {
+			conn.Close()
+
+			return ErrClosed
+		}
+
+		go gateway.handle(conn, newCodec(protocol))
+	}
+}
+
+// Close stops serving and closes every connection.
+func (gateway *Gateway) Close() error {
+	gateway.mutex.Lock()
+
+	gateway.closed = true
+
+	for listener := range gateway.listeners {
+		listener.Close()
+	}
+
+	for conn := range gateway.connections {
+		conn.Close()
+	}
+
+	gateway.mutex.Unlock()
+
+	gateway.wait.Wait()
+
+	return nil
+}
+
+func (gateway *Gateway) track(conn net.Conn) bool {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+
+	if gateway.closed {
+		return false
+	}
+
+	gateway.connections[conn] = struct{}{}
+	gateway.wait.Add(1)
+
+	return true
+}
+
+func (gateway *Gateway) untrack(conn net.Conn) {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+
+	delete(gateway.connections, conn)
+
+	conn.Close()
+}
+
+// handle serves single connection: the first message must be logon, after
+// which messages are read by this goroutine and written by another one.
+func (gateway *Gateway) handle(conn net.Conn, codec codec) {
+	defer gateway.wait.Done()
+	defer gateway.untrack(conn)
+
+	reader := bufio.NewReaderSize(conn, textMaxLine)
+	writer := bufio.NewWriter(conn)
+
+	gateway.deadline(conn)
+
+	logon, err := codec.read(reader)
+	if err != nil || logon.Type != MessageLogon || logon.SenderID == "" {
+		return
+	}
+
+	connection := newConnection()
+
+	session, err := gateway.logon(logon.SenderID, connection, logon.NextExpected)
+	if err != nil {
+		reply := Message{Type: MessageLogout, Text: err.Error()}
+
+		if codec.write(writer, &reply) == nil {
+			writer.Flush()
+		}
+
+		return
+	}
+
+	defer session.detach(connection)
+
+	reply := Message{
+		Type:         MessageLogon,
+		SenderID:     logon.SenderID,
+		NextExpected: gateway.incoming(session),
+	}
+
+	if codec.write(writer, &reply) != nil || writer.Flush() != nil {
+		return
+	}
+
+	written := make(chan struct{})
+
+	go func() {
+		defer close(written)
+
+		if session.write(connection, writer, codec, gateway.heartbeat) != nil {
+			conn.Close()
+		}
+	}()
+
+	connection.notify()
+
+	for {
+		gateway.deadline(conn)
+
+		message, err := codec.read(reader)
+		if err != nil || !gateway.receive(session, &message) {
+			break
+		}
+	}
+
+	close(connection.done)
+
+	<-written
+}
+
+func (gateway *Gateway) deadline(conn net.Conn) {
+	if gateway.heartbeat > 0 {
+		conn.SetReadDeadline(time.Now().Add(2 * gateway.heartbeat))
+	}
+}
+
+func (gateway *Gateway) logon(
+	id string,
+	connection *connection,
+	next uint64,
+) (*session, error) {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+
+	session, ok := gateway.sessions[id]
+	if !ok {
+		session = newSession(id)
+
+		gateway.sessions[id] = session
+	}
+
+	if !session.attach(connection, next) {
+		return nil, ErrSessionActive
+	}
+
+	return session, nil
+}
+
+func (gateway *Gateway) incoming(session *session) uint64 {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+
+	return session.incoming
+}
+
+// receive handles client message and returns false if connection should be
+// closed.
+func (gateway *Gateway) receive(session *session, message *Message) bool {
+	switch message.Type {
+	case MessageLogout:
+		session.send(Message{Type: MessageLogout})
+
+		return false
+	case MessageResendRequest:
+		session.resend(message.NextExpected)
+
+		return true
+	}
+
+	if !message.Type.sequenced() || message.Type == MessageExecutionReport {
+		return true
+	}
+
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+
+	switch {
+	case message.Sequence < session.incoming:
+		return true
+	case message.Sequence > session.incoming:
+		session.send(Message{
+			Type:         MessageResendRequest,
+			NextExpected: session.incoming,
+		})
+
+		return true
+	}
+
+	session.incoming++
+
+	switch message.Type {
+	case MessageNewOrder:
+		gateway.submit(session, message)
+	case MessageCancelOrder:
+		gateway.cancel(session, message)
+	case MessageAmendOrder:
+		gateway.amend(session, message)
+	}
+
+	return true
+}
+
+func (gateway *Gateway) submit(session *session, message *Message) {
+	if _, ok := session.orders[message.OrderID]; ok || message.OrderID <= 0 {
+		gateway.refuse(session, message.OrderID, "invalid order ID")
+
+		return
+	}
+
+	order := &Order{
+		Side:          message.Side,
+		Kind:          message.Kind,
+		TimeInForce:   message.TimeInForce,
+		Volume:        message.Volume,
+		Price:         message.Price,
+		StopPrice:     message.StopPrice,
+		DisplayVolume: message.DisplayVolume,
+	}
+
+	if !gateway.valid(order) {
+		gateway.refuse(session, message.OrderID, "invalid order")
+
+		return
+	}
+
+	gateway.sequence++
+
+	order.ID = gateway.sequence
+
+	entry := &entry{
+		session: session,
+		id:      message.OrderID,
+		order:   order,
+		leaves:  order.Volume,
+	}
+
+	gateway.orders[order.ID] = entry
+	session.orders[entry.id] = order.ID
+
+	trades, reject := gateway.book.Match(order)
+
+	if reject == nil || len(trades) > 0 {
+		gateway.report(entry, ExecAck, 0, 0)
+	}
+
+	gateway.execute(entry, trades)
+}
+
+func (gateway *Gateway) cancel(session *session, message *Message) {
+	id, ok := session.orders[message.OrderID]
+	if !ok || !gateway.book.Cancel(id) {
+		gateway.refuse(session, message.OrderID, "unknown order")
+
+		return
+	}
+
+	entry := gateway.orders[id]
+
+	entry.leaves = 0
+
+	gateway.report(entry, ExecCancelled, 0, 0)
+	gateway.remove(entry)
+}
+
+func (gateway *Gateway) amend(session *session, message *Message) {
+	id, ok := session.orders[message.OrderID]
+	if !ok {
+		gateway.refuse(session, message.OrderID, "unknown order")
+
+		return
+	}
+
+	entry := gateway.orders[id]
+
+	trades, ok := gateway.book.Amend(id, message.Volume, message.Price)
+	if !ok {
+		gateway.refuse(session, message.OrderID, "unknown order")
+
+		return
+	}
+
+	entry.leaves = message.Volume
+
+	if message.Volume == 0 {
+		gateway.report(entry, ExecCancelled, 0, 0)
+		gateway.remove(entry)
+
+		return
+	}
+
+	gateway.report(entry, ExecAck, 0, 0)
+	gateway.execute(entry, trades)
+}
+
+// execute reports trades to owners of both orders and then settles every
+// order touched by them: filled orders are forgotten, while orders which
+// left the book with remaining volume are reported as rejected, if it's the
+// incoming one, or cancelled otherwise.
+func (gateway *Gateway) execute(incoming *entry, trades []*Trade) {
+	gateway.touch(incoming)
+
+	for _, trade := range trades {
+		for _, order := range []*Order{trade.Bid, trade.Ask} {
+			entry, ok := gateway.orders[order.ID]
+			if !ok {
+				continue
+			}
+
+			entry.leaves -= trade.Volume
+			entry.cumulative += trade.Volume
+
+			exec := ExecPartialFill
+			if entry.leaves == 0 {
+				exec = ExecFill
+			}
+
+			gateway.report(entry, exec, trade.Volume, trade.Price)
+			gateway.touch(entry)
+		}
+	}
+
+	for i, entry := range gateway.touched {
+		gateway.touched[i] = nil
+
+		entry.touched = false
+
+		if _, ok := gateway.book.orders[entry.order.ID]; ok {
+			continue
+		}
+
+		if entry.leaves > 0 {
+			exec := ExecCancelled
+			if entry == incoming {
+				exec = ExecReject
+			}
+
+			entry.leaves = 0
+
+			gateway.report(entry, exec, 0, 0)
+		}
+
+		gateway.remove(entry)
+	}
+
+	gateway.touched = gateway.touched[:0]
+}
+
+func (gateway *Gateway) touch(entry *entry) {
+	if entry.touched {
+		return
+	}
+
+	entry.touched = true
+
+	gateway.touched = append(gateway.touched, entry)
+}
+
+func (gateway *Gateway) remove(entry *entry) {
+	delete(gateway.orders, entry.order.ID)
+	delete(entry.session.orders, entry.id)
+}
+
+func (gateway *Gateway) report(
+	entry *entry,
+	exec ExecType,
+	volume uint64,
+	price uint64,
+) {
+	report := Message{
+		Type:       MessageExecutionReport,
+		OrderID:    entry.id,
+		Exec:       exec,
+		LastVolume: volume,
+		LastPrice:  price,
+		Leaves:     entry.leaves,
+		Cumulative: entry.cumulative,
+	}
+
+	if exec == ExecReject || exec == ExecCancelled {
+		report.Reason = entry.order.Reason
+	}
+
+	entry.session.send(report)
+}
+
+// refuse rejects message which never reached the book.
+func (gateway *Gateway) refuse(session *session, id int, text string) {
+	session.send(Message{
+		Type:    MessageExecutionReport,
+		OrderID: id,
+		Exec:    ExecReject,
+		Text:    text,
+	})
+}
+
+func (gateway *Gateway) valid(order *Order) bool {
+	switch {
+	case order.Side != SideBid && order.Side != SideAsk:
+		return false
+	case order.Kind < KindMarket || order.Kind > KindStopLimit:
+		return false
+	case order.TimeInForce < TimeInForceGTC ||
+		order.TimeInForce > TimeInForcePostOnly:
+		return false
+	}
+
+	return order.Volume > 0
+}
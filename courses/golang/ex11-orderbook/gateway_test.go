@@ -0,0 +1,410 @@
+package orderbook
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage_Codecs(t *testing.T) {
+	test := assert.New(t)
+
+	messages := []Message{
+		{Type: MessageLogon, SenderID: "alice", NextExpected: 3},
+		{Type: MessageHeartbeat},
+		{Type: MessageResendRequest, NextExpected: 7},
+		{Type: MessageLogout, Text: "session is already active"},
+		{
+			Type:          MessageNewOrder,
+			Sequence:      1,
+			OrderID:       7,
+			Side:          SideBid,
+			Kind:          KindStopLimit,
+			TimeInForce:   TimeInForceIOC,
+			Volume:        10000,
+			Price:         60000000,
+			StopPrice:     59000000,
+			DisplayVolume: 1000,
+		},
+		{Type: MessageCancelOrder, Sequence: 2, OrderID: 7},
+		{Type: MessageAmendOrder, Sequence: 3, OrderID: 7, Volume: 5000, Price: 61000000},
+		{
+			Type:              MessageExecutionReport,
+			Sequence:          4,
+			PossibleDuplicate: true,
+			OrderID:           7,
+			Exec:              ExecReject,
+			LastVolume:        1000,
+			LastPrice:         60000000,
+			Leaves:            0,
+			Cumulative:        1000,
+			Reason:            ReasonImmediateOrCancel,
+			Text:              "unknown order",
+		},
+	}
+
+	for _, protocol := range []Protocol{ProtocolText, ProtocolBinary} {
+		buffer := &bytes.Buffer{}
+		writer := bufio.NewWriter(buffer)
+		codec := newCodec(protocol)
+
+		for i := range messages {
+			test.NoError(codec.write(writer, &messages[i]))
+		}
+
+		test.NoError(writer.Flush())
+
+		reader := bufio.NewReader(buffer)
+
+		for _, expected := range messages {
+			message, err := codec.read(reader)
+			test.NoError(err)
+			test.Equal(expected, message, "protocol %d", protocol)
+		}
+	}
+
+	_, err := newCodec(ProtocolText).read(
+		bufio.NewReader(bytes.NewBufferString("35=D|38=ten\n")),
+	)
+	test.ErrorIs(err, ErrMalformedMessage)
+}
+
+func TestGateway_Execution(t *testing.T) {
+	test := assert.New(t)
+
+	gateway, text, binary := serveGateway(test, time.Second)
+	defer gateway.Close()
+
+	alice := dialGateway(test, text, ProtocolText, "alice", 0)
+	bob := dialGateway(test, binary, ProtocolBinary, "bob", 0)
+
+	alice.send(Message{
+		Type:    MessageNewOrder,
+		OrderID: 1,
+		Side:    SideAsk,
+		Kind:    KindLimit,
+		Volume:  10000,
+		Price:   60000000,
+	})
+
+	alice.expect(Message{OrderID: 1, Exec: ExecAck, Leaves: 10000}, 1)
+
+	bob.send(Message{
+		Type:        MessageNewOrder,
+		OrderID:     1,
+		Side:        SideBid,
+		Kind:        KindLimit,
+		TimeInForce: TimeInForceIOC,
+		Volume:      15000,
+		Price:       60000000,
+	})
+
+	bob.expect(Message{OrderID: 1, Exec: ExecAck, Leaves: 15000}, 1)
+	bob.expect(Message{
+		OrderID:    1,
+		Exec:       ExecPartialFill,
+		LastVolume: 10000,
+		LastPrice:  60000000,
+		Leaves:     5000,
+		Cumulative: 10000,
+	}, 2)
+	bob.expect(Message{
+		OrderID:    1,
+		Exec:       ExecReject,
+		Cumulative: 10000,
+		Reason:     ReasonImmediateOrCancel,
+	}, 3)
+
+	alice.expect(Message{
+		OrderID:    1,
+		Exec:       ExecFill,
+		LastVolume: 10000,
+		LastPrice:  60000000,
+		Cumulative: 10000,
+	}, 2)
+
+	alice.send(Message{Type: MessageCancelOrder, OrderID: 1})
+	alice.expect(Message{OrderID: 1, Exec: ExecReject, Text: "unknown order"}, 3)
+
+	alice.send(Message{
+		Type:    MessageNewOrder,
+		OrderID: 2,
+		Side:    SideAsk,
+		Kind:    KindLimit,
+		Volume:  10000,
+		Price:   61000000,
+	})
+	alice.expect(Message{OrderID: 2, Exec: ExecAck, Leaves: 10000}, 4)
+
+	alice.send(Message{Type: MessageAmendOrder, OrderID: 2, Volume: 5000, Price: 61000000})
+	alice.expect(Message{OrderID: 2, Exec: ExecAck, Leaves: 5000}, 5)
+
+	alice.send(Message{Type: MessageCancelOrder, OrderID: 2})
+	alice.expect(Message{OrderID: 2, Exec: ExecCancelled, Reason: ReasonCancelled}, 6)
+}
+
+func TestGateway_Resend(t *testing.T) {
+	test := assert.New(t)
+
+	gateway, text, _ := serveGateway(test, time.Second)
+	defer gateway.Close()
+
+	alice := dialGateway(test, text, ProtocolText, "alice", 0)
+
+	alice.send(Message{
+		Type:    MessageNewOrder,
+		OrderID: 1,
+		Side:    SideAsk,
+		Kind:    KindLimit,
+		Volume:  10000,
+		Price:   60000000,
+	})
+	alice.expect(Message{OrderID: 1, Exec: ExecAck, Leaves: 10000}, 1)
+
+	intruder := dialGateway(test, text, ProtocolText, "alice", 0)
+	test.Equal(MessageLogout, intruder.logon.Type)
+	test.Equal(ErrSessionActive.Error(), intruder.logon.Text)
+
+	alice.conn.Close()
+
+	bob := dialGateway(test, text, ProtocolText, "bob", 0)
+
+	bob.send(Message{
+		Type:    MessageNewOrder,
+		OrderID: 1,
+		Side:    SideBid,
+		Kind:    KindMarket,
+		Volume:  4000,
+	})
+	bob.expect(Message{OrderID: 1, Exec: ExecAck, Leaves: 4000}, 1)
+
+	var reconnected *gatewayClient
+
+	for i := 0; i < 100 && reconnected == nil; i++ {
+		client := dialGateway(test, text, ProtocolText, "alice", 2)
+
+		if client.logon.Type == MessageLogon {
+			reconnected = client
+		} else {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if !test.NotNil(reconnected) {
+		return
+	}
+
+	alice = reconnected
+	alice.sequence = 1
+
+	test.Equal(uint64(2), alice.logon.NextExpected)
+
+	partial := Message{
+		OrderID:    1,
+		Exec:       ExecPartialFill,
+		LastVolume: 4000,
+		LastPrice:  60000000,
+		Leaves:     6000,
+		Cumulative: 4000,
+	}
+
+	alice.expect(partial, 2)
+
+	alice.send(Message{Type: MessageResendRequest, NextExpected: 1})
+
+	alice.expect(Message{
+		OrderID:           1,
+		Exec:              ExecAck,
+		Leaves:            10000,
+		PossibleDuplicate: true,
+	}, 1)
+
+	partial.PossibleDuplicate = true
+
+	alice.expect(partial, 2)
+
+	alice.sequence = 0
+
+	alice.send(Message{Type: MessageCancelOrder, OrderID: 1})
+
+	alice.sequence = 2
+
+	alice.send(Message{Type: MessageCancelOrder, OrderID: 1})
+
+	message := alice.receive()
+	test.Equal(MessageResendRequest, message.Type)
+	test.Equal(uint64(2), message.NextExpected)
+
+	alice.sequence = 1
+
+	alice.send(Message{Type: MessageCancelOrder, OrderID: 1})
+	alice.expect(Message{
+		OrderID:    1,
+		Exec:       ExecCancelled,
+		Cumulative: 4000,
+		Reason:     ReasonCancelled,
+	}, 3)
+}
+
+func TestGateway_Heartbeat(t *testing.T) {
+	test := assert.New(t)
+
+	gateway, text, _ := serveGateway(test, 50*time.Millisecond)
+	defer gateway.Close()
+
+	alice := dialGateway(test, text, ProtocolText, "alice", 0)
+
+	alice.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	message, err := alice.codec.read(alice.reader)
+	test.NoError(err)
+	test.Equal(MessageHeartbeat, message.Type)
+
+	for err == nil {
+		_, err = alice.codec.read(alice.reader)
+	}
+
+	if timeout, ok := err.(net.Error); ok {
+		test.False(timeout.Timeout(), "silent session is disconnected")
+	}
+}
+
+func serveGateway(
+	test *assert.Assertions,
+	heartbeat time.Duration,
+) (*Gateway, string, string) {
+	gateway := NewGateway(New(), heartbeat)
+
+	var addresses []string
+
+	for _, protocol := range []Protocol{ProtocolText, ProtocolBinary} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		test.NoError(err)
+
+		addresses = append(addresses, listener.Addr().String())
+
+		go gateway.Serve(listener, protocol)
+	}
+
+	return gateway, addresses[0], addresses[1]
+}
+
+type gatewayClient struct {
+	test *assert.Assertions
+
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	codec  codec
+
+	logon    Message
+	sequence uint64
+}
+
+func dialGateway(
+	test *assert.Assertions,
+	address string,
+	protocol Protocol,
+	sender string,
+	next uint64,
+) *gatewayClient {
+	conn, err := net.Dial("tcp", address)
+	test.NoError(err)
+
+	client := &gatewayClient{
+		test:   test,
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+		codec:  newCodec(protocol),
+	}
+
+	client.send(Message{
+		Type:         MessageLogon,
+		SenderID:     sender,
+		NextExpected: next,
+	})
+
+	client.logon = client.receive()
+
+	return client
+}
+
+func (client *gatewayClient) send(message Message) {
+	if message.Type.sequenced() {
+		client.sequence++
+
+		message.Sequence = client.sequence
+	}
+
+	client.test.NoError(client.codec.write(client.writer, &message))
+	client.test.NoError(client.writer.Flush())
+}
+
+// receive returns the next message which is not heartbeat.
+func (client *gatewayClient) receive() Message {
+	client.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	for {
+		message, err := client.codec.read(client.reader)
+		if !client.test.NoError(err) || message.Type != MessageHeartbeat {
+			return message
+		}
+	}
+}
+
+// expect receives execution report with specified sequence number.
+func (client *gatewayClient) expect(expected Message, sequence uint64) {
+	expected.Type = MessageExecutionReport
+	expected.Sequence = sequence
+
+	client.test.Equal(expected, client.receive())
+}
+
+// failingWriter fails every write, like connection which was reset.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, net.ErrClosed
+}
+
+func TestSession_FailedWrite(t *testing.T) {
+	test := assert.New(t)
+
+	session := newSession("alice")
+	connection := newConnection()
+
+	test.True(session.attach(connection, 0))
+
+	session.send(Message{Type: MessageExecutionReport, OrderID: 1, Exec: ExecAck})
+
+	writer := bufio.NewWriter(failingWriter{})
+
+	test.Error(session.write(connection, writer, newCodec(ProtocolText), 0))
+
+	session.detach(connection)
+
+	// Report which wasn't flushed is written again on logon with zero next
+	// expected sequence number, as it might have reached client partially.
+	connection = newConnection()
+
+	test.True(session.attach(connection, 0))
+
+	messages, end := session.pending()
+	if test.Len(messages, 1) {
+		test.Equal(uint64(1), messages[0].Sequence)
+		test.True(messages[0].PossibleDuplicate)
+	}
+
+	session.flushed(end)
+	session.detach(connection)
+
+	test.True(session.attach(newConnection(), 0))
+
+	messages, _ = session.pending()
+	test.Empty(messages)
+}
@@ -0,0 +1,449 @@
+package orderbook
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// Journal record layout:
+//
+//	length:   4 bytes, length of payload
+//	checksum: 4 bytes, CRC-32 (IEEE) of payload
+//	payload:  record kind (1 byte) followed by record fields
+//
+// All integers are big endian.
+const recordHeaderSize = 8
+
+type recordKind uint8
+
+const (
+	recordMatch      recordKind = 1
+	recordCancel     recordKind = 2
+	recordAmend      recordKind = 3
+	recordCheckpoint recordKind = 4
+	recordAuction    recordKind = 5
+	recordUncross    recordKind = 6
+)
+
+var (
+	ErrCorruptJournal = errors.New("corrupt journal")
+	ErrNoCheckpoint   = errors.New("journal has no checkpoint")
+)
+
+// Journal appends every input of the book to underlying writer. Each record
+// is written with single Write call, so durability of records depends only
+// on the writer, e.g. file opened with O_SYNC.
+type Journal struct {
+	writer io.Writer
+
+	// interval is number of input records between checkpoints, zero
+	// disables periodic checkpoints.
+	interval int
+	records  int
+
+	encoder encoder
+}
+
+func NewJournal(writer io.Writer, interval int) *Journal {
+	return &Journal{
+		writer:   writer,
+		interval: interval,
+	}
+}
+
+func (journal *Journal) begin(kind recordKind) *encoder {
+	journal.encoder.buffer = append(
+		journal.encoder.buffer[:0],
+		0, 0, 0, 0,
+		0, 0, 0, 0,
+		byte(kind),
+	)
+
+	return &journal.encoder
+}
+
+func (journal *Journal) commit() error {
+	buffer := journal.encoder.buffer
+	payload := buffer[recordHeaderSize:]
+
+	binary.BigEndian.PutUint32(buffer[0:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buffer[4:], crc32.ChecksumIEEE(payload))
+
+	_, err := journal.writer.Write(buffer)
+
+	return err
+}
+
+// Journaled is orderbook which writes every Match, Cancel, Amend,
+// BeginAuction and Uncross call to journal before applying it. Book can be
+// rebuilt from the journal with Replay or Recover. Wrapped book isn't
+// exposed, as changes made to it directly would be missing in the journal.
+type Journaled struct {
+	book    *Orderbook
+	journal *Journal
+}
+
+// NewJournaled starts journal with checkpoint of specified book, so journal
+// doesn't depend on any state written before. Book must not be used directly
+// afterwards.
+func NewJournaled(book *Orderbook, journal *Journal) (*Journaled, error) {
+	journaled := &Journaled{
+		book:    book,
+		journal: journal,
+	}
+
+	if err := journaled.Checkpoint(); err != nil {
+		return nil, err
+	}
+
+	return journaled, nil
+}
+
+func (journaled *Journaled) Match(order *Order) ([]*Trade, *Order, error) {
+	journaled.journal.begin(recordMatch).order(order)
+
+	if err := journaled.write(); err != nil {
+		return nil, nil, err
+	}
+
+	trades, reject := journaled.book.Match(order)
+
+	return trades, reject, journaled.tick()
+}
+
+func (journaled *Journaled) Cancel(id int) (bool, error) {
+	journaled.journal.begin(recordCancel).uint64(uint64(id))
+
+	if err := journaled.write(); err != nil {
+		return false, err
+	}
+
+	ok := journaled.book.Cancel(id)
+
+	return ok, journaled.tick()
+}
+
+func (journaled *Journaled) Amend(
+	id int,
+	volume uint64,
+	price uint64,
+) ([]*Trade, bool, error) {
+	encoder := journaled.journal.begin(recordAmend)
+
+	encoder.uint64(uint64(id))
+	encoder.uint64(volume)
+	encoder.uint64(price)
+
+	if err := journaled.write(); err != nil {
+		return nil, false, err
+	}
+
+	trades, ok := journaled.book.Amend(id, volume, price)
+
+	return trades, ok, journaled.tick()
+}
+
+func (journaled *Journaled) BeginAuction(reference uint64) error {
+	journaled.journal.begin(recordAuction).uint64(reference)
+
+	if err := journaled.write(); err != nil {
+		return err
+	}
+
+	journaled.book.BeginAuction(reference)
+
+	return journaled.tick()
+}
+
+func (journaled *Journaled) Uncross() ([]*Trade, []*Order, error) {
+	journaled.journal.begin(recordUncross)
+
+	if err := journaled.write(); err != nil {
+		return nil, nil, err
+	}
+
+	trades, rejects := journaled.book.Uncross()
+
+	return trades, rejects, journaled.tick()
+}
+
+// Checkpoint writes complete state of the book to the journal. Recovery
+// starts from the latest checkpoint instead of the start of journal.
+func (journaled *Journaled) Checkpoint() error {
+	journaled.book.checkpoint(journaled.journal.begin(recordCheckpoint))
+
+	journaled.journal.records = 0
+
+	return journaled.journal.commit()
+}
+
+func (journaled *Journaled) Auction() bool {
+	return journaled.book.Auction()
+}
+
+func (journaled *Journaled) Indicative() (uint64, uint64) {
+	return journaled.book.Indicative()
+}
+
+func (journaled *Journaled) Snapshot(levels int) *Depth {
+	return journaled.book.Snapshot(levels)
+}
+
+func (journaled *Journaled) SnapshotOrders(levels int) *Depth {
+	return journaled.book.SnapshotOrders(levels)
+}
+
+func (journaled *Journaled) Subscribe(handler func(Update)) func() {
+	return journaled.book.Subscribe(handler)
+}
+
+func (journaled *Journaled) write() error {
+	journaled.journal.records++
+
+	return journaled.journal.commit()
+}
+
+// tick writes periodic checkpoint after input record is applied.
+func (journaled *Journaled) tick() error {
+	interval := journaled.journal.interval
+	if interval == 0 || journaled.journal.records < interval {
+		return nil
+	}
+
+	return journaled.Checkpoint()
+}
+
+// Replay rebuilds book from journal. It returns rebuilt book and trades
+// produced by records after the latest checkpoint. Incomplete record at the
+// end of journal, left by interrupted write, is ignored. Options must be the
+// same as options of journaled book, e.g. its allocator.
+func Replay(
+	journal io.Reader,
+	options ...Option,
+) (*Orderbook, []*Trade, error) {
+	var (
+		book   *Orderbook
+		trades []*Trade
+		reader = journalReader{reader: journal}
+	)
+
+	for {
+		kind, payload, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if kind == recordCheckpoint {
+			book, err = restore(payload, options)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			trades = nil
+
+			continue
+		}
+
+		if book == nil {
+			return nil, nil, ErrNoCheckpoint
+		}
+
+		traded, err := book.apply(kind, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		trades = append(trades, traded...)
+	}
+
+	if book == nil {
+		return nil, nil, ErrNoCheckpoint
+	}
+
+	return book, trades, nil
+}
+
+// Recover rebuilds book like Replay, but reads only record headers until the
+// latest checkpoint and replays journal from it. Checkpoint which is
+// incomplete or doesn't match its checksum, e.g. torn by crash, ends the
+// journal, so book is recovered from the previous one.
+func Recover(
+	journal io.ReadSeeker,
+	options ...Option,
+) (*Orderbook, []*Trade, error) {
+	var (
+		offset  int64
+		found   bool
+		end     int64
+		torn    bool
+		header  [recordHeaderSize + 1]byte
+		payload []byte
+	)
+
+	position, err := journal.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		_, err := io.ReadFull(journal, header[:])
+		if err != nil {
+			break
+		}
+
+		length := int64(binary.BigEndian.Uint32(header[0:]))
+		checksum := binary.BigEndian.Uint32(header[4:])
+
+		if recordKind(header[recordHeaderSize]) != recordCheckpoint {
+			position, err = journal.Seek(length-1, io.SeekCurrent)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			continue
+		}
+
+		if length == 0 {
+			end, torn = position, true
+
+			break
+		}
+
+		if cap(payload) < int(length) {
+			payload = make([]byte, length)
+		}
+
+		payload = payload[:length]
+		payload[0] = header[recordHeaderSize]
+
+		_, err = io.ReadFull(journal, payload[1:])
+		if err != nil || crc32.ChecksumIEEE(payload) != checksum {
+			end, torn = position, true
+
+			break
+		}
+
+		offset, found = position, true
+		position += recordHeaderSize + length
+	}
+
+	if !found {
+		return nil, nil, ErrNoCheckpoint
+	}
+
+	if _, err := journal.Seek(offset, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+
+	if torn {
+		return Replay(io.LimitReader(journal, end-offset), options...)
+	}
+
+	return Replay(journal, options...)
+}
+
+type journalReader struct {
+	reader io.Reader
+	header [recordHeaderSize]byte
+	buffer []byte
+}
+
+func (reader *journalReader) next() (recordKind, []byte, error) {
+	_, err := io.ReadFull(reader.reader, reader.header[:])
+	if err == io.ErrUnexpectedEOF {
+		return 0, nil, io.EOF
+	}
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(reader.header[0:])
+	checksum := binary.BigEndian.Uint32(reader.header[4:])
+
+	if length == 0 {
+		return 0, nil, ErrCorruptJournal
+	}
+
+	if cap(reader.buffer) < int(length) {
+		reader.buffer = make([]byte, length)
+	}
+
+	payload := reader.buffer[:length]
+
+	_, err = io.ReadFull(reader.reader, payload)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return 0, nil, io.EOF
+	}
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return 0, nil, ErrCorruptJournal
+	}
+
+	return recordKind(payload[0]), payload[1:], nil
+}
+
+func (orderbook *Orderbook) apply(
+	kind recordKind,
+	payload []byte,
+) ([]*Trade, error) {
+	decoder := decoder{payload: payload}
+
+	switch kind {
+	case recordMatch:
+		order := decoder.order()
+		if decoder.failed {
+			return nil, ErrCorruptJournal
+		}
+
+		trades, _ := orderbook.Match(order)
+
+		return trades, nil
+	case recordCancel:
+		id := int(decoder.uint64())
+		if decoder.failed {
+			return nil, ErrCorruptJournal
+		}
+
+		orderbook.Cancel(id)
+
+		return nil, nil
+	case recordAmend:
+		id := int(decoder.uint64())
+		volume := decoder.uint64()
+		price := decoder.uint64()
+
+		if decoder.failed {
+			return nil, ErrCorruptJournal
+		}
+
+		trades, _ := orderbook.Amend(id, volume, price)
+
+		return trades, nil
+	case recordAuction:
+		reference := decoder.uint64()
+		if decoder.failed {
+			return nil, ErrCorruptJournal
+		}
+
+		orderbook.BeginAuction(reference)
+
+		return nil, nil
+	case recordUncross:
+		trades, _ := orderbook.Uncross()
+
+		return trades, nil
+	}
+
+	return nil, ErrCorruptJournal
+}
@@ -0,0 +1,252 @@
+package orderbook
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal_Replay_SameTrades(t *testing.T) {
+	test := assert.New(t)
+
+	journal := &bytes.Buffer{}
+
+	book, err := NewJournaled(New(), NewJournal(journal, 0))
+	test.NoError(err)
+
+	trades := journalize(test, book, fabricateJournal(3000, 0))
+
+	replayed, replayedTrades, err := Replay(bytes.NewReader(journal.Bytes()))
+	test.NoError(err)
+
+	assertTrades(test, trades, replayedTrades)
+	test.Equal(book.SnapshotOrders(0), replayed.SnapshotOrders(0))
+}
+
+func TestJournal_Replay_Allocator(t *testing.T) {
+	test := assert.New(t)
+
+	journal := &bytes.Buffer{}
+	allocator := WithAllocator(Hybrid{TopOrder: 2, Minimum: 1})
+
+	book, err := NewJournaled(New(allocator), NewJournal(journal, 256))
+	test.NoError(err)
+
+	trades := journalize(test, book, fabricateJournal(3000, 0))
+
+	replayed, replayedTrades, err := Replay(
+		bytes.NewReader(journal.Bytes()),
+		allocator,
+	)
+	test.NoError(err)
+
+	assertTrades(test, trades[len(trades)-len(replayedTrades):], replayedTrades)
+	test.Equal(book.SnapshotOrders(0), replayed.SnapshotOrders(0))
+}
+
+func TestJournal_Recover_FromCheckpoint(t *testing.T) {
+	test := assert.New(t)
+
+	journal := &bytes.Buffer{}
+
+	book, err := NewJournaled(New(), NewJournal(journal, 128))
+	test.NoError(err)
+
+	trades := journalize(test, book, fabricateJournal(1000, 0))
+
+	recovered, recoveredTrades, err := Recover(bytes.NewReader(journal.Bytes()))
+	test.NoError(err)
+
+	test.Less(len(recoveredTrades), len(trades))
+	assertTrades(test, trades[len(trades)-len(recoveredTrades):], recoveredTrades)
+	test.Equal(book.SnapshotOrders(0), recovered.SnapshotOrders(0))
+
+	inputs := fabricateJournal(1000, 1)
+	cloned := cloneJournal(inputs)
+
+	continued := &bytes.Buffer{}
+
+	resumed, err := NewJournaled(recovered, NewJournal(continued, 0))
+	test.NoError(err)
+
+	assertTrades(
+		test,
+		journalize(test, book, inputs),
+		journalize(test, resumed, cloned),
+	)
+	test.Equal(book.SnapshotOrders(0), resumed.SnapshotOrders(0))
+}
+
+func TestJournal_TornTail(t *testing.T) {
+	test := assert.New(t)
+
+	journal := &bytes.Buffer{}
+
+	book, err := NewJournaled(New(), NewJournal(journal, 0))
+	test.NoError(err)
+
+	_, _, err = book.Match(&Order{ID: 1, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 60000000})
+	test.NoError(err)
+
+	size := journal.Len()
+
+	_, _, err = book.Match(&Order{ID: 2, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 61000000})
+	test.NoError(err)
+
+	replayed, _, err := Replay(bytes.NewReader(journal.Bytes()[:journal.Len()-3]))
+	test.NoError(err)
+
+	test.Equal(
+		[]DepthLevel{{Price: 60000000, Volume: 10000, Count: 1}},
+		replayed.Snapshot(0).Asks,
+	)
+
+	corrupted := append([]byte{}, journal.Bytes()...)
+	corrupted[size+recordHeaderSize+3]++
+
+	_, _, err = Replay(bytes.NewReader(corrupted))
+	test.ErrorIs(err, ErrCorruptJournal)
+
+	_, _, err = Replay(bytes.NewReader(journal.Bytes()[size:]))
+	test.ErrorIs(err, ErrNoCheckpoint)
+}
+
+func TestJournal_Recover_TornCheckpoint(t *testing.T) {
+	test := assert.New(t)
+
+	journal := &bytes.Buffer{}
+
+	book, err := NewJournaled(New(), NewJournal(journal, 0))
+	test.NoError(err)
+
+	trades := journalize(test, book, fabricateJournal(500, 0))
+
+	size := journal.Len()
+
+	test.NoError(book.Checkpoint())
+
+	// Crash tears the latest checkpoint, so book is recovered from the first
+	// one and records after it.
+	for _, torn := range [][]byte{
+		journal.Bytes()[:size+(journal.Len()-size)/2],
+		journal.Bytes()[:size+recordHeaderSize+1],
+	} {
+		recovered, recoveredTrades, err := Recover(bytes.NewReader(torn))
+		if !test.NoError(err) {
+			continue
+		}
+
+		assertTrades(test, trades, recoveredTrades)
+		test.Equal(book.SnapshotOrders(0), recovered.SnapshotOrders(0))
+	}
+
+	corrupted := append([]byte{}, journal.Bytes()...)
+	corrupted[len(corrupted)-1]++
+
+	recovered, recoveredTrades, err := Recover(bytes.NewReader(corrupted))
+	if test.NoError(err) {
+		assertTrades(test, trades, recoveredTrades)
+		test.Equal(book.SnapshotOrders(0), recovered.SnapshotOrders(0))
+	}
+
+	_, _, err = Recover(bytes.NewReader(journal.Bytes()[:recordHeaderSize+3]))
+	test.ErrorIs(err, ErrNoCheckpoint)
+}
+
+// journalInput is either order to match, or cancel/amend of resting order if
+// order kind is zero.
+type journalInput struct {
+	order  Order
+	id     int
+	volume uint64
+	price  uint64
+}
+
+func fabricateJournal(count int, generation int) []journalInput {
+	fabric := new(fabricator)
+
+	fabric.chances.buy = 0.5
+	fabric.chances.market = 0.2
+	fabric.volume.min = 1
+	fabric.volume.max = 5
+	fabric.price.min = 90
+	fabric.price.max = 110
+
+	orders := make([]Order, count)
+
+	fabric.fabricate(orders, generation)
+
+	inputs := make([]journalInput, 0, count)
+
+	for i, order := range orders {
+		switch i % 10 {
+		case 3:
+			order.Kind = KindStop
+			order.StopPrice = order.Price
+		case 5:
+			order.DisplayVolume = order.Volume / 3
+		case 7:
+			inputs = append(inputs, journalInput{id: order.ID - 20})
+		case 9:
+			inputs = append(inputs, journalInput{
+				id:     order.ID - 30,
+				volume: order.Volume,
+				price:  order.Price,
+			})
+		}
+
+		inputs = append(inputs, journalInput{order: order})
+	}
+
+	return inputs
+}
+
+func cloneJournal(inputs []journalInput) []journalInput {
+	return append([]journalInput{}, inputs...)
+}
+
+func journalize(
+	test *assert.Assertions,
+	book *Journaled,
+	inputs []journalInput,
+) []*Trade {
+	var trades []*Trade
+
+	for i := range inputs {
+		input := &inputs[i]
+
+		switch {
+		case input.order.Kind != 0:
+			traded, _, err := book.Match(&input.order)
+			test.NoError(err)
+
+			trades = append(trades, traded...)
+		case input.volume == 0:
+			_, err := book.Cancel(input.id)
+			test.NoError(err)
+		default:
+			traded, _, err := book.Amend(input.id, input.volume, input.price)
+			test.NoError(err)
+
+			trades = append(trades, traded...)
+		}
+	}
+
+	return trades
+}
+
+func assertTrades(test *assert.Assertions, expected, actual []*Trade) {
+	if !test.Len(actual, len(expected), "trades number mismatch") {
+		return
+	}
+
+	for i := range expected {
+		test.Equal(expected[i].Bid.ID, actual[i].Bid.ID, "trade %d bid", i)
+		test.Equal(expected[i].Ask.ID, actual[i].Ask.ID, "trade %d ask", i)
+		test.Equal(expected[i].Volume, actual[i].Volume, "trade %d volume", i)
+		test.Equal(expected[i].Price, actual[i].Price, "trade %d price", i)
+		test.Equal(expected[i].BidHidden, actual[i].BidHidden, "trade %d bid hidden", i)
+		test.Equal(expected[i].AskHidden, actual[i].AskHidden, "trade %d ask hidden", i)
+	}
+}
@@ -0,0 +1,163 @@
+package orderbook
+
+import "sort"
+
+// level is a FIFO queue of resting orders sharing the same price. Orders are
+// linked intrusively, so adding or removing one never allocates. Displayed
+// and hidden volume of iceberg orders are accounted separately.
+type level struct {
+	side    Side
+	price   uint64
+	volume  uint64
+	reserve uint64
+	count   int
+
+	head *Order
+	tail *Order
+
+	dirty      bool
+	published  bool
+	shown      uint64
+	shownCount int
+}
+
+func (level *level) push(order *Order) {
+	order.level = level
+	order.prev = level.tail
+	order.next = nil
+
+	if level.tail != nil {
+		level.tail.next = order
+	} else {
+		level.head = order
+	}
+
+	level.tail = order
+	level.count++
+	level.attach(order)
+}
+
+func (level *level) remove(order *Order) {
+	if order.prev != nil {
+		order.prev.next = order.next
+	} else {
+		level.head = order.next
+	}
+
+	if order.next != nil {
+		order.next.prev = order.prev
+	} else {
+		level.tail = order.prev
+	}
+
+	level.count--
+	level.detach(order)
+
+	order.level = nil
+	order.prev = nil
+	order.next = nil
+}
+
+func (level *level) attach(order *Order) {
+	level.volume += order.visible
+	level.reserve += order.Volume - order.visible
+}
+
+func (level *level) detach(order *Order) {
+	level.volume -= order.visible
+	level.reserve -= order.Volume - order.visible
+}
+
+// ladder holds price levels of one side of the book in sorted array. Levels
+// are sorted from the worst price to the best one, so the best level is
+// always the last and can be consumed without shifting the array, while
+// lookup is done by binary search. Levels are taken from pool shared by both
+// sides of the book.
+type ladder struct {
+	side   Side
+	levels []*level
+	pool   *levelPool
+}
+
+func newLadder(side Side, pool *levelPool) ladder {
+	return ladder{
+		side: side,
+		pool: pool,
+	}
+}
+
+func (ladder *ladder) better(price, than uint64) bool {
+	if ladder.side == SideBid {
+		return price > than
+	}
+
+	return price < than
+}
+
+func (ladder *ladder) best() *level {
+	if len(ladder.levels) == 0 {
+		return nil
+	}
+
+	return ladder.levels[len(ladder.levels)-1]
+}
+
+// available returns volume which can be matched against specified order,
+// stopping as soon as order volume is covered.
+func (ladder *ladder) available(order *Order) uint64 {
+	var volume uint64
+
+	for i := len(ladder.levels) - 1; i >= 0; i-- {
+		if volume >= order.Volume || !order.crosses(ladder.levels[i].price) {
+			break
+		}
+
+		volume += ladder.levels[i].volume + ladder.levels[i].reserve
+	}
+
+	return volume
+}
+
+func (ladder *ladder) search(price uint64) int {
+	return sort.Search(len(ladder.levels), func(i int) bool {
+		return !ladder.better(price, ladder.levels[i].price)
+	})
+}
+
+func (ladder *ladder) insert(order *Order) {
+	position := ladder.search(order.Price)
+
+	if position < len(ladder.levels) &&
+		ladder.levels[position].price == order.Price {
+		ladder.levels[position].push(order)
+
+		return
+	}
+
+	target := ladder.pool.get(ladder.side, order.Price)
+
+	ladder.levels = append(ladder.levels, nil)
+	copy(ladder.levels[position+1:], ladder.levels[position:])
+	ladder.levels[position] = target
+
+	target.push(order)
+}
+
+// remove unlinks order from its level and removes level from the ladder once
+// it's empty. Empty level is returned to the pool only after its removal is
+// published by the feed.
+func (ladder *ladder) remove(order *Order) {
+	target := order.level
+
+	target.remove(order)
+
+	if target.count > 0 {
+		return
+	}
+
+	position := ladder.search(target.price)
+
+	copy(ladder.levels[position:], ladder.levels[position+1:])
+	ladder.levels[len(ladder.levels)-1] = nil
+	ladder.levels = ladder.levels[:len(ladder.levels)-1]
+}
@@ -0,0 +1,515 @@
+package orderbook
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var ErrMalformedMessage = errors.New("malformed message")
+
+type MessageType int8
+
+const (
+	MessageLogon           MessageType = 1
+	MessageHeartbeat       MessageType = 2
+	MessageResendRequest   MessageType = 3
+	MessageLogout          MessageType = 4
+	MessageNewOrder        MessageType = 5
+	MessageCancelOrder     MessageType = 6
+	MessageAmendOrder      MessageType = 7
+	MessageExecutionReport MessageType = 8
+)
+
+func (kind MessageType) String() string {
+	switch kind {
+	case MessageLogon:
+		return "LOGON"
+	case MessageHeartbeat:
+		return "HEARTBEAT"
+	case MessageResendRequest:
+		return "RESEND_REQUEST"
+	case MessageLogout:
+		return "LOGOUT"
+	case MessageNewOrder:
+		return "NEW_ORDER"
+	case MessageCancelOrder:
+		return "CANCEL_ORDER"
+	case MessageAmendOrder:
+		return "AMEND_ORDER"
+	case MessageExecutionReport:
+		return "EXECUTION_REPORT"
+	}
+
+	return "UNKNOWN"
+}
+
+// sequenced reports whether messages of the type carry sequence number and
+// can be resent. Session level messages are not sequenced.
+func (kind MessageType) sequenced() bool {
+	return kind >= MessageNewOrder
+}
+
+type ExecType int8
+
+const (
+	ExecAck         ExecType = 1
+	ExecPartialFill ExecType = 2
+	ExecFill        ExecType = 3
+	ExecReject      ExecType = 4
+	ExecCancelled   ExecType = 5
+)
+
+func (exec ExecType) String() string {
+	switch exec {
+	case ExecAck:
+		return "ACK"
+	case ExecPartialFill:
+		return "PARTIAL_FILL"
+	case ExecFill:
+		return "FILL"
+	case ExecReject:
+		return "REJECT"
+	case ExecCancelled:
+		return "CANCELLED"
+	}
+
+	return "UNKNOWN"
+}
+
+type Protocol int8
+
+const (
+	ProtocolText   Protocol = 1
+	ProtocolBinary Protocol = 2
+)
+
+// Message is a message of order-entry protocol. Only fields relevant for its
+// type are transferred.
+type Message struct {
+	Type     MessageType
+	Sequence uint64
+
+	// PossibleDuplicate is set on messages which are sent again on resend
+	// request.
+	PossibleDuplicate bool
+
+	// SenderID identifies session on logon.
+	SenderID string
+
+	// NextExpected is the next sequence number which sender of logon or
+	// resend request expects to receive.
+	NextExpected uint64
+
+	// OrderID is order identifier assigned by the client, it must be unique
+	// within session.
+	OrderID       int
+	Side          Side
+	Kind          Kind
+	TimeInForce   TimeInForce
+	Volume        uint64
+	Price         uint64
+	StopPrice     uint64
+	DisplayVolume uint64
+
+	Exec       ExecType
+	LastVolume uint64
+	LastPrice  uint64
+	Leaves     uint64
+	Cumulative uint64
+	Reason     Reason
+	Text       string
+}
+
+type codec interface {
+	read(reader *bufio.Reader) (Message, error)
+	write(writer *bufio.Writer, message *Message) error
+}
+
+func newCodec(protocol Protocol) codec {
+	if protocol == ProtocolBinary {
+		return &binaryCodec{}
+	}
+
+	return &textCodec{}
+}
+
+// textCodec implements simplified FIX-like protocol: message is a line of
+// tag=value fields separated by '|', e.g.
+//
+//	35=D|34=1|11=7|54=1|40=2|38=10000|44=60000000
+//
+// Zero fields are omitted.
+type textCodec struct {
+	buffer []byte
+}
+
+const textMaxLine = 4096
+
+const (
+	tagMessageType       = 35
+	tagSequence          = 34
+	tagPossibleDuplicate = 43
+	tagSenderID          = 49
+	tagNextExpected      = 789
+	tagOrderID           = 11
+	tagSide              = 54
+	tagKind              = 40
+	tagTimeInForce       = 59
+	tagVolume            = 38
+	tagPrice             = 44
+	tagStopPrice         = 99
+	tagDisplayVolume     = 1138
+	tagExec              = 150
+	tagLastVolume        = 32
+	tagLastPrice         = 31
+	tagLeaves            = 151
+	tagCumulative        = 14
+	tagReason            = 103
+	tagText              = 58
+)
+
+var textMessageTypes = map[MessageType]string{
+	MessageLogon:           "A",
+	MessageHeartbeat:       "0",
+	MessageResendRequest:   "2",
+	MessageLogout:          "5",
+	MessageNewOrder:        "D",
+	MessageCancelOrder:     "F",
+	MessageAmendOrder:      "G",
+	MessageExecutionReport: "8",
+}
+
+func (codec *textCodec) write(writer *bufio.Writer, message *Message) error {
+	buffer := append(codec.buffer[:0], "35="...)
+	buffer = append(buffer, textMessageTypes[message.Type]...)
+
+	for _, field := range []struct {
+		tag   int
+		value uint64
+	}{
+		{tagSequence, message.Sequence},
+		{tagNextExpected, message.NextExpected},
+		{tagOrderID, uint64(message.OrderID)},
+		{tagSide, uint64(message.Side)},
+		{tagKind, uint64(message.Kind)},
+		{tagTimeInForce, uint64(message.TimeInForce)},
+		{tagVolume, message.Volume},
+		{tagPrice, message.Price},
+		{tagStopPrice, message.StopPrice},
+		{tagDisplayVolume, message.DisplayVolume},
+		{tagExec, uint64(message.Exec)},
+		{tagLastVolume, message.LastVolume},
+		{tagLastPrice, message.LastPrice},
+		{tagLeaves, message.Leaves},
+		{tagCumulative, message.Cumulative},
+		{tagReason, uint64(message.Reason)},
+	} {
+		if field.value == 0 {
+			continue
+		}
+
+		buffer = append(buffer, '|')
+		buffer = strconv.AppendInt(buffer, int64(field.tag), 10)
+		buffer = append(buffer, '=')
+		buffer = strconv.AppendUint(buffer, field.value, 10)
+	}
+
+	if message.PossibleDuplicate {
+		buffer = append(buffer, "|43=Y"...)
+	}
+
+	for _, field := range []struct {
+		tag   int
+		value string
+	}{
+		{tagSenderID, message.SenderID},
+		{tagText, message.Text},
+	} {
+		if field.value == "" {
+			continue
+		}
+
+		if strings.ContainsAny(field.value, "|\n") {
+			return ErrMalformedMessage
+		}
+
+		buffer = append(buffer, '|')
+		buffer = strconv.AppendInt(buffer, int64(field.tag), 10)
+		buffer = append(buffer, '=')
+		buffer = append(buffer, field.value...)
+	}
+
+	buffer = append(buffer, '\n')
+
+	codec.buffer = buffer
+
+	_, err := writer.Write(buffer)
+
+	return err
+}
+
+func (codec *textCodec) read(reader *bufio.Reader) (Message, error) {
+	var message Message
+
+	line, err := reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return message, ErrMalformedMessage
+	}
+
+	if err != nil {
+		return message, err
+	}
+
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'})
+
+	for len(line) > 0 {
+		var field []byte
+
+		if i := bytes.IndexByte(line, '|'); i >= 0 {
+			field, line = line[:i], line[i+1:]
+		} else {
+			field, line = line, nil
+		}
+
+		i := bytes.IndexByte(field, '=')
+		if i <= 0 {
+			return message, ErrMalformedMessage
+		}
+
+		tag, err := strconv.Atoi(string(field[:i]))
+		if err != nil {
+			return message, ErrMalformedMessage
+		}
+
+		if err := codec.field(&message, tag, string(field[i+1:])); err != nil {
+			return message, err
+		}
+	}
+
+	if message.Type == 0 {
+		return message, ErrMalformedMessage
+	}
+
+	return message, nil
+}
+
+func (codec *textCodec) field(message *Message, tag int, value string) error {
+	switch tag {
+	case tagMessageType:
+		for kind, code := range textMessageTypes {
+			if code == value {
+				message.Type = kind
+			}
+		}
+
+		if message.Type == 0 {
+			return ErrMalformedMessage
+		}
+
+		return nil
+	case tagPossibleDuplicate:
+		message.PossibleDuplicate = value == "Y"
+
+		return nil
+	case tagSenderID:
+		message.SenderID = value
+
+		return nil
+	case tagText:
+		message.Text = value
+
+		return nil
+	}
+
+	number, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return ErrMalformedMessage
+	}
+
+	switch tag {
+	case tagSequence:
+		message.Sequence = number
+	case tagNextExpected:
+		message.NextExpected = number
+	case tagOrderID:
+		message.OrderID = int(number)
+	case tagSide:
+		message.Side = Side(number)
+	case tagKind:
+		message.Kind = Kind(number)
+	case tagTimeInForce:
+		message.TimeInForce = TimeInForce(number)
+	case tagVolume:
+		message.Volume = number
+	case tagPrice:
+		message.Price = number
+	case tagStopPrice:
+		message.StopPrice = number
+	case tagDisplayVolume:
+		message.DisplayVolume = number
+	case tagExec:
+		message.Exec = ExecType(number)
+	case tagLastVolume:
+		message.LastVolume = number
+	case tagLastPrice:
+		message.LastPrice = number
+	case tagLeaves:
+		message.Leaves = number
+	case tagCumulative:
+		message.Cumulative = number
+	case tagReason:
+		message.Reason = Reason(number)
+	default:
+		return ErrMalformedMessage
+	}
+
+	return nil
+}
+
+// binaryCodec implements compact binary framing:
+//
+//	length:  2 bytes, length of payload
+//	payload: type (1 byte), sequence (8 bytes), flags (1 byte) and fields
+//	         of the message type
+//
+// All integers are big endian, strings are prefixed with 1 byte length.
+type binaryCodec struct {
+	encoder encoder
+	buffer  []byte
+}
+
+const binaryPossibleDuplicate = 1
+
+func (codec *binaryCodec) write(writer *bufio.Writer, message *Message) error {
+	encoder := &codec.encoder
+
+	encoder.buffer = append(encoder.buffer[:0], 0, 0, byte(message.Type))
+	encoder.uint64(message.Sequence)
+
+	if message.PossibleDuplicate {
+		encoder.byte(binaryPossibleDuplicate)
+	} else {
+		encoder.byte(0)
+	}
+
+	switch message.Type {
+	case MessageLogon:
+		encoder.string(message.SenderID)
+		encoder.uint64(message.NextExpected)
+	case MessageResendRequest:
+		encoder.uint64(message.NextExpected)
+	case MessageLogout:
+		encoder.string(message.Text)
+	case MessageNewOrder:
+		encoder.uint64(uint64(message.OrderID))
+		encoder.byte(byte(message.Side))
+		encoder.byte(byte(message.Kind))
+		encoder.byte(byte(message.TimeInForce))
+		encoder.uint64(message.Volume)
+		encoder.uint64(message.Price)
+		encoder.uint64(message.StopPrice)
+		encoder.uint64(message.DisplayVolume)
+	case MessageCancelOrder:
+		encoder.uint64(uint64(message.OrderID))
+	case MessageAmendOrder:
+		encoder.uint64(uint64(message.OrderID))
+		encoder.uint64(message.Volume)
+		encoder.uint64(message.Price)
+	case MessageExecutionReport:
+		encoder.uint64(uint64(message.OrderID))
+		encoder.byte(byte(message.Exec))
+		encoder.uint64(message.LastVolume)
+		encoder.uint64(message.LastPrice)
+		encoder.uint64(message.Leaves)
+		encoder.uint64(message.Cumulative)
+		encoder.byte(byte(message.Reason))
+		encoder.string(message.Text)
+	}
+
+	binary.BigEndian.PutUint16(encoder.buffer, uint16(len(encoder.buffer)-2))
+
+	_, err := writer.Write(encoder.buffer)
+
+	return err
+}
+
+func (codec *binaryCodec) read(reader *bufio.Reader) (Message, error) {
+	var (
+		message Message
+		header  [2]byte
+	)
+
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return message, err
+	}
+
+	length := int(binary.BigEndian.Uint16(header[:]))
+
+	if cap(codec.buffer) < length {
+		codec.buffer = make([]byte, length)
+	}
+
+	payload := codec.buffer[:length]
+
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return message, err
+	}
+
+	decoder := decoder{payload: payload}
+
+	message.Type = MessageType(decoder.byte())
+	message.Sequence = decoder.uint64()
+	message.PossibleDuplicate = decoder.byte()&binaryPossibleDuplicate != 0
+
+	switch message.Type {
+	case MessageLogon:
+		message.SenderID = decoder.string()
+		message.NextExpected = decoder.uint64()
+	case MessageHeartbeat:
+	case MessageResendRequest:
+		message.NextExpected = decoder.uint64()
+	case MessageLogout:
+		message.Text = decoder.string()
+	case MessageNewOrder:
+		message.OrderID = int(decoder.uint64())
+		message.Side = Side(decoder.byte())
+		message.Kind = Kind(decoder.byte())
+		message.TimeInForce = TimeInForce(decoder.byte())
+		message.Volume = decoder.uint64()
+		message.Price = decoder.uint64()
+		message.StopPrice = decoder.uint64()
+		message.DisplayVolume = decoder.uint64()
+	case MessageCancelOrder:
+		message.OrderID = int(decoder.uint64())
+	case MessageAmendOrder:
+		message.OrderID = int(decoder.uint64())
+		message.Volume = decoder.uint64()
+		message.Price = decoder.uint64()
+	case MessageExecutionReport:
+		message.OrderID = int(decoder.uint64())
+		message.Exec = ExecType(decoder.byte())
+		message.LastVolume = decoder.uint64()
+		message.LastPrice = decoder.uint64()
+		message.Leaves = decoder.uint64()
+		message.Cumulative = decoder.uint64()
+		message.Reason = Reason(decoder.byte())
+		message.Text = decoder.string()
+	default:
+		return message, ErrMalformedMessage
+	}
+
+	if decoder.failed || len(decoder.payload) > 0 {
+		return message, ErrMalformedMessage
+	}
+
+	return message, nil
+}
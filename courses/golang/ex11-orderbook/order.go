@@ -21,8 +21,10 @@ func (side Side) String() string {
 type Kind int8
 
 const (
-	KindMarket Kind = 1
-	KindLimit  Kind = 2
+	KindMarket    Kind = 1
+	KindLimit     Kind = 2
+	KindStop      Kind = 3
+	KindStopLimit Kind = 4
 )
 
 func (kind Kind) String() string {
@@ -31,6 +33,114 @@ func (kind Kind) String() string {
 		return "MARKET"
 	case KindLimit:
 		return "LIMIT"
+	case KindStop:
+		return "STOP"
+	case KindStopLimit:
+		return "STOP_LIMIT"
+	}
+
+	return "UNKNOWN"
+}
+
+// TimeInForce defines what happens with order volume that can't be matched
+// immediately. Zero value is good-till-cancel, i.e. plain limit order.
+type TimeInForce int8
+
+const (
+	TimeInForceGTC      TimeInForce = 0
+	TimeInForceIOC      TimeInForce = 1
+	TimeInForceFOK      TimeInForce = 2
+	TimeInForcePostOnly TimeInForce = 3
+)
+
+func (tif TimeInForce) String() string {
+	switch tif {
+	case TimeInForceGTC:
+		return "GTC"
+	case TimeInForceIOC:
+		return "IOC"
+	case TimeInForceFOK:
+		return "FOK"
+	case TimeInForcePostOnly:
+		return "POST_ONLY"
+	}
+
+	return "UNKNOWN"
+}
+
+// SelfTrade defines what happens when incoming order would match resting
+// order of the same account. Zero value allows such trades.
+type SelfTrade int8
+
+const (
+	SelfTradeAllow        SelfTrade = 0
+	SelfTradeCancelNewest SelfTrade = 1
+	SelfTradeCancelOldest SelfTrade = 2
+	SelfTradeCancelBoth   SelfTrade = 3
+	SelfTradeDecrement    SelfTrade = 4
+)
+
+func (mode SelfTrade) String() string {
+	switch mode {
+	case SelfTradeAllow:
+		return "ALLOW"
+	case SelfTradeCancelNewest:
+		return "CANCEL_NEWEST"
+	case SelfTradeCancelOldest:
+		return "CANCEL_OLDEST"
+	case SelfTradeCancelBoth:
+		return "CANCEL_BOTH"
+	case SelfTradeDecrement:
+		return "DECREMENT_AND_CANCEL"
+	}
+
+	return "UNKNOWN"
+}
+
+// Reason tells why order was rejected or removed from the book.
+type Reason int8
+
+const (
+	ReasonNone              Reason = 0
+	ReasonNoLiquidity       Reason = 1
+	ReasonImmediateOrCancel Reason = 2
+	ReasonFillOrKill        Reason = 3
+	ReasonPostOnly          Reason = 4
+	ReasonSelfTrade         Reason = 5
+	ReasonCancelled         Reason = 6
+	ReasonAuction           Reason = 7
+	ReasonInsufficientFunds Reason = 8
+	ReasonMaxOrderSize      Reason = 9
+	ReasonMaxOpenOrders     Reason = 10
+	ReasonPriceBand         Reason = 11
+)
+
+func (reason Reason) String() string {
+	switch reason {
+	case ReasonNone:
+		return "NONE"
+	case ReasonNoLiquidity:
+		return "NO_LIQUIDITY"
+	case ReasonImmediateOrCancel:
+		return "IMMEDIATE_OR_CANCEL"
+	case ReasonFillOrKill:
+		return "FILL_OR_KILL"
+	case ReasonPostOnly:
+		return "POST_ONLY"
+	case ReasonSelfTrade:
+		return "SELF_TRADE"
+	case ReasonCancelled:
+		return "CANCELLED"
+	case ReasonAuction:
+		return "AUCTION"
+	case ReasonInsufficientFunds:
+		return "INSUFFICIENT_FUNDS"
+	case ReasonMaxOrderSize:
+		return "MAX_ORDER_SIZE"
+	case ReasonMaxOpenOrders:
+		return "MAX_OPEN_ORDERS"
+	case ReasonPriceBand:
+		return "PRICE_BAND"
 	}
 
 	return "UNKNOWN"
@@ -39,9 +149,99 @@ func (kind Kind) String() string {
 type Order struct {
 	ID int
 
-	Side Side
-	Kind Kind
+	Side        Side
+	Kind        Kind
+	TimeInForce TimeInForce
+
+	// Account identifies participant who owns the order. Self-trade
+	// prevention is applied only to orders with non-zero account, using mode
+	// of incoming order.
+	Account   uint64
+	SelfTrade SelfTrade
 
 	Volume uint64
 	Price  uint64
+
+	// StopPrice is trigger price of stop and stop-limit orders. Bid stop
+	// triggers when last trade price rises to or above it, ask stop triggers
+	// when last trade price falls to or below it.
+	StopPrice uint64
+
+	// DisplayVolume turns limit order into iceberg order which displays only
+	// specified slice of its volume while resting. Once displayed slice is
+	// consumed, it is replenished from hidden reserve and order moves to the
+	// back of its price level. Zero means that whole volume is displayed.
+	DisplayVolume uint64
+
+	// Reason is set by the book when order is rejected or removed from the
+	// book without being filled.
+	Reason Reason
+
+	sequence  uint64
+	execution execution
+
+	visible   uint64
+	refreshed bool
+
+	level *level
+	prev  *Order
+	next  *Order
+}
+
+func (order *Order) crosses(price uint64) bool {
+	switch {
+	case order.Kind == KindMarket:
+		return true
+	case order.Side == SideBid:
+		return order.Price >= price
+	default:
+		return order.Price <= price
+	}
+}
+
+func (order *Order) stop() bool {
+	return order.Kind == KindStop || order.Kind == KindStopLimit
+}
+
+func (order *Order) triggers(last uint64) bool {
+	if last == 0 {
+		return false
+	}
+
+	if order.Side == SideBid {
+		return last >= order.StopPrice
+	}
+
+	return last <= order.StopPrice
+}
+
+// trigger converts stop order into market order and stop-limit order into
+// limit one.
+func (order *Order) trigger() {
+	if order.Kind == KindStop {
+		order.Kind = KindMarket
+	} else {
+		order.Kind = KindLimit
+	}
+}
+
+// display returns volume which resting order should display.
+func (order *Order) display() uint64 {
+	if order.DisplayVolume > 0 && order.DisplayVolume < order.Volume {
+		return order.DisplayVolume
+	}
+
+	return order.Volume
+}
+
+func (order *Order) priced() bool {
+	return order.Kind == KindLimit || order.Kind == KindStopLimit
+}
+
+// prevents reports whether self-trade prevention forbids order to match
+// resting one.
+func (order *Order) prevents(resting *Order) bool {
+	return order.SelfTrade != SelfTradeAllow &&
+		order.Account != 0 &&
+		order.Account == resting.Account
 }
@@ -1,15 +1,553 @@
 package orderbook
 
 type Orderbook struct {
-	// TODO
+	bids ladder
+	asks ladder
+
+	bidStops stops
+	askStops stops
+
+	orders map[int]*Order
+
+	last     uint64
+	sequence uint64
+
+	refreshed []*Order
+
+	feed   feed
+	levels levelPool
+
+	scratch []Trade
+
+	pool    *Pool
+	retired []*Order
+
+	auction auction
+
+	allocator   Allocator
+	allocations []Allocation
+
+	listeners []Listener
+	events    uint64
 }
 
-func New() *Orderbook {
-	// TODO
-	return &Orderbook{}
+func New(options ...Option) *Orderbook {
+	orderbook := &Orderbook{
+		bidStops:  stops{side: SideBid},
+		askStops:  stops{side: SideAsk},
+		orders:    map[int]*Order{},
+		allocator: FIFO{},
+	}
+
+	orderbook.bids = newLadder(SideBid, &orderbook.levels)
+	orderbook.asks = newLadder(SideAsk, &orderbook.levels)
+
+	for _, option := range options {
+		option(orderbook)
+	}
+
+	return orderbook
 }
 
+// Match executes incoming order against resting ones. Stop orders are held
+// off-book until last trade price reaches their trigger price. Trades of
+// incoming order are followed by trades of every stop order triggered by them,
+// in trigger order. Only incoming order can be rejected: unmatched volume of
+// triggered stop orders is discarded.
 func (orderbook *Orderbook) Match(order *Order) ([]*Trade, *Order) {
-	// TODO
-	return nil, nil
+	var reject *Order
+
+	orderbook.scratch, reject = orderbook.execute(order, orderbook.scratch[:0])
+
+	return pointers(orderbook.scratch), reject
+}
+
+// pointers copies trades to the heap.
+func pointers(scratch []Trade) []*Trade {
+	if len(scratch) == 0 {
+		return nil
+	}
+
+	values := make([]Trade, len(scratch))
+	trades := make([]*Trade, len(scratch))
+
+	copy(values, scratch)
+
+	for i := range values {
+		trades[i] = &values[i]
+	}
+
+	return trades
+}
+
+// MatchTo works like Match, but appends trades to specified buffer instead
+// of allocating them. Together with Recycle it allows matching without any
+// allocations once buffers are warmed up.
+func (orderbook *Orderbook) MatchTo(
+	order *Order,
+	trades []Trade,
+) ([]Trade, *Order) {
+	return orderbook.execute(order, trades)
+}
+
+func (orderbook *Orderbook) execute(
+	order *Order,
+	trades []Trade,
+) ([]Trade, *Order) {
+	orderbook.recycle()
+	orderbook.emit(EventAccepted, order)
+
+	return orderbook.enter(order, trades)
+}
+
+// enter matches accepted order, holds it if it's untriggered stop, or
+// collects it during auction.
+func (orderbook *Orderbook) enter(
+	order *Order,
+	trades []Trade,
+) ([]Trade, *Order) {
+	if order.stop() {
+		if !order.triggers(orderbook.last) {
+			orderbook.hold(order)
+
+			return trades, nil
+		}
+
+		order.trigger()
+	}
+
+	if orderbook.auction.active {
+		reject := orderbook.collect(order)
+
+		orderbook.flush()
+
+		return trades, reject
+	}
+
+	trades, reject := orderbook.match(order, trades)
+	trades = orderbook.cascade(trades)
+
+	orderbook.flush()
+
+	return trades, reject
+}
+
+// cascade matches every stop order triggered by last trade price, including
+// ones triggered by trades of previously triggered stops.
+func (orderbook *Orderbook) cascade(trades []Trade) []Trade {
+	for {
+		stop := orderbook.triggered()
+		if stop == nil {
+			return trades
+		}
+
+		orderbook.unlink(stop)
+		stop.trigger()
+
+		trades, _ = orderbook.match(stop, trades)
+	}
+}
+
+func (orderbook *Orderbook) match(
+	order *Order,
+	trades []Trade,
+) ([]Trade, *Order) {
+	opposite := orderbook.opposite(order.Side)
+
+	switch order.TimeInForce {
+	case TimeInForcePostOnly:
+		if best := opposite.best(); best != nil && order.crosses(best.price) {
+			return trades, orderbook.reject(order, ReasonPostOnly)
+		}
+	case TimeInForceFOK:
+		if opposite.available(order) < order.Volume {
+			return trades, orderbook.reject(order, ReasonFillOrKill)
+		}
+	}
+
+	start := len(trades)
+	prevented := false
+
+	_, fifo := orderbook.allocator.(FIFO)
+
+	for order.Volume > 0 && !prevented {
+		best := opposite.best()
+		if best == nil || !order.crosses(best.price) {
+			break
+		}
+
+		if fifo {
+			if order.prevents(best.head) {
+				trades, prevented = orderbook.prevent(trades, order, best.head)
+
+				continue
+			}
+
+			volume := order.Volume
+			if best.head.visible < volume {
+				volume = best.head.visible
+			}
+
+			trades = orderbook.fill(trades, start, order, best.head, volume)
+
+			continue
+		}
+
+		if resting := orderbook.conflict(order, best); resting != nil {
+			trades, prevented = orderbook.prevent(trades, order, resting)
+
+			continue
+		}
+
+		trades = orderbook.allocate(trades, start, order, best)
+	}
+
+	for _, iceberg := range orderbook.refreshed {
+		iceberg.refreshed = false
+	}
+
+	orderbook.refreshed = orderbook.refreshed[:0]
+
+	switch {
+	case prevented:
+		return trades, orderbook.reject(order, ReasonSelfTrade)
+	case order.Volume == 0:
+		orderbook.retire(order)
+
+		return trades, nil
+	case order.Kind == KindMarket:
+		return trades, orderbook.reject(order, ReasonNoLiquidity)
+	case order.TimeInForce == TimeInForceIOC:
+		return trades, orderbook.reject(order, ReasonImmediateOrCancel)
+	}
+
+	orderbook.rest(order)
+
+	return trades, nil
+}
+
+// prevent applies self-trade prevention mode of incoming order to resting
+// order of the same account. Every change of resting order is reported as
+// trade without exchange. It returns true if incoming order is cancelled.
+func (orderbook *Orderbook) prevent(
+	trades []Trade,
+	order *Order,
+	resting *Order,
+) ([]Trade, bool) {
+	if order.SelfTrade == SelfTradeCancelNewest {
+		return trades, true
+	}
+
+	volume := resting.Volume
+	if order.SelfTrade == SelfTradeDecrement && order.Volume < volume {
+		volume = order.Volume
+	}
+
+	trade := Trade{
+		Volume:    volume,
+		Price:     resting.Price,
+		SelfTrade: order.SelfTrade,
+	}
+
+	if order.Side == SideBid {
+		trade.Bid, trade.Ask = order, resting
+	} else {
+		trade.Bid, trade.Ask = resting, order
+	}
+
+	if volume == resting.Volume {
+		orderbook.unlink(resting)
+		orderbook.retire(resting)
+
+		resting.Reason = ReasonSelfTrade
+
+		orderbook.emit(EventCancelled, resting)
+	} else {
+		resting.level.detach(resting)
+
+		resting.Volume -= volume
+		if resting.visible > resting.Volume {
+			resting.visible = resting.Volume
+		}
+
+		resting.level.attach(resting)
+
+		orderbook.feed.touch(resting.level)
+		orderbook.emit(EventAmended, resting)
+	}
+
+	switch order.SelfTrade {
+	case SelfTradeCancelBoth:
+		return append(trades, trade), true
+	case SelfTradeDecrement:
+		order.Volume -= volume
+
+		return append(trades, trade), order.Volume == 0
+	}
+
+	return append(trades, trade), false
+}
+
+func (orderbook *Orderbook) reject(order *Order, reason Reason) *Order {
+	order.Reason = reason
+
+	orderbook.retire(order)
+	orderbook.emit(EventRejected, order)
+
+	return order
+}
+
+// Cancel removes resting or held stop order with specified ID from the book.
+// It returns false if there is no such order.
+func (orderbook *Orderbook) Cancel(id int) bool {
+	orderbook.recycle()
+
+	order, ok := orderbook.orders[id]
+	if !ok {
+		return false
+	}
+
+	orderbook.unlink(order)
+	orderbook.retire(order)
+	orderbook.flush()
+
+	order.Reason = ReasonCancelled
+
+	orderbook.emit(EventCancelled, order)
+
+	return true
+}
+
+// Amend changes volume and price of resting order with specified ID. Volume
+// reduction keeps order position in the queue, while price change or volume
+// increase moves order to the back of its price level. Repriced order may
+// cross the spread, in which case it is matched as incoming one and resulting
+// trades are returned. Post-only order repriced across the spread is rejected
+// and therefore removed from the book. Held stop order is amended in place
+// without changing its trigger priority. Zero volume cancels the order.
+func (orderbook *Orderbook) Amend(
+	id int,
+	volume uint64,
+	price uint64,
+) ([]*Trade, bool) {
+	orderbook.recycle()
+
+	order, ok := orderbook.orders[id]
+	if !ok {
+		return nil, false
+	}
+
+	if volume == 0 {
+		orderbook.unlink(order)
+		orderbook.retire(order)
+		orderbook.flush()
+
+		order.Reason = ReasonCancelled
+
+		orderbook.emit(EventCancelled, order)
+
+		return nil, true
+	}
+
+	if order.level == nil {
+		order.Volume = volume
+		order.Price = price
+
+		orderbook.emit(EventAmended, order)
+
+		return nil, true
+	}
+
+	if price == order.Price && volume <= order.Volume {
+		order.level.detach(order)
+
+		order.Volume = volume
+		if order.visible > volume {
+			order.visible = volume
+		}
+
+		order.level.attach(order)
+
+		orderbook.feed.touch(order.level)
+		orderbook.flush()
+		orderbook.emit(EventAmended, order)
+
+		return nil, true
+	}
+
+	orderbook.unlink(order)
+
+	order.Volume = volume
+	order.Price = price
+
+	orderbook.emit(EventAmended, order)
+
+	orderbook.scratch, _ = orderbook.enter(order, orderbook.scratch[:0])
+
+	return pointers(orderbook.scratch), true
+}
+
+// fill executes volume of incoming order against displayed volume of resting
+// one. Fills against the same iceberg order which stays at the head of its
+// level after replenishment are merged into single trade.
+func (orderbook *Orderbook) fill(
+	trades []Trade,
+	start int,
+	order *Order,
+	resting *Order,
+	volume uint64,
+) []Trade {
+	var hidden uint64
+	if resting.refreshed {
+		hidden = volume
+	}
+
+	order.Volume -= volume
+	resting.Volume -= volume
+	resting.visible -= volume
+	resting.level.volume -= volume
+
+	orderbook.last = resting.Price
+	orderbook.feed.touch(resting.level)
+
+	orderbook.executed(order, volume, resting.Price)
+	orderbook.executed(resting, volume, resting.Price)
+
+	if resting.Volume == 0 {
+		orderbook.unlink(resting)
+		orderbook.retire(resting)
+	} else if resting.visible == 0 {
+		orderbook.replenish(resting)
+	}
+
+	if count := len(trades); count > start {
+		trade := &trades[count-1]
+
+		if trade.SelfTrade == SelfTradeAllow &&
+			(trade.Bid == resting || trade.Ask == resting) {
+			trade.Volume += volume
+
+			if resting.Side == SideBid {
+				trade.BidHidden += hidden
+			} else {
+				trade.AskHidden += hidden
+			}
+
+			return trades
+		}
+	}
+
+	trade := Trade{
+		Volume: volume,
+		Price:  resting.Price,
+	}
+
+	if order.Side == SideBid {
+		trade.Bid, trade.Ask = order, resting
+		trade.AskHidden = hidden
+	} else {
+		trade.Bid, trade.Ask = resting, order
+		trade.BidHidden = hidden
+	}
+
+	return append(trades, trade)
+}
+
+// replenish displays next slice of iceberg order from its hidden reserve and
+// moves it to the back of its price level.
+func (orderbook *Orderbook) replenish(iceberg *Order) {
+	level := iceberg.level
+
+	level.remove(iceberg)
+
+	iceberg.visible = iceberg.display()
+
+	level.push(iceberg)
+
+	if !iceberg.refreshed {
+		iceberg.refreshed = true
+
+		orderbook.refreshed = append(orderbook.refreshed, iceberg)
+	}
+}
+
+func (orderbook *Orderbook) rest(order *Order) {
+	order.visible = order.display()
+
+	orderbook.same(order.Side).insert(order)
+	orderbook.orders[order.ID] = order
+
+	orderbook.feed.touch(order.level)
+	orderbook.emit(EventRested, order)
+}
+
+func (orderbook *Orderbook) hold(order *Order) {
+	orderbook.sequence++
+
+	order.sequence = orderbook.sequence
+
+	orderbook.held(order.Side).insert(order)
+	orderbook.orders[order.ID] = order
+}
+
+// triggered returns held stop order which should be triggered next by last
+// trade price. If stops of both sides are triggered, the earlier one wins.
+func (orderbook *Orderbook) triggered() *Order {
+	bid := orderbook.bidStops.next()
+	if bid != nil && !bid.triggers(orderbook.last) {
+		bid = nil
+	}
+
+	ask := orderbook.askStops.next()
+	if ask != nil && !ask.triggers(orderbook.last) {
+		ask = nil
+	}
+
+	if bid == nil || (ask != nil && ask.sequence < bid.sequence) {
+		return ask
+	}
+
+	return bid
+}
+
+func (orderbook *Orderbook) unlink(order *Order) {
+	switch {
+	case order.level != nil:
+		orderbook.feed.touch(order.level)
+		orderbook.same(order.Side).remove(order)
+	case order.stop():
+		orderbook.held(order.Side).remove(order)
+	default:
+		orderbook.auction.remove(order)
+	}
+
+	delete(orderbook.orders, order.ID)
+}
+
+func (orderbook *Orderbook) flush() {
+	orderbook.feed.flush(&orderbook.levels)
+}
+
+func (orderbook *Orderbook) same(side Side) *ladder {
+	if side == SideBid {
+		return &orderbook.bids
+	}
+
+	return &orderbook.asks
+}
+
+func (orderbook *Orderbook) held(side Side) *stops {
+	if side == SideBid {
+		return &orderbook.bidStops
+	}
+
+	return &orderbook.askStops
+}
+
+func (orderbook *Orderbook) opposite(side Side) *ladder {
+	if side == SideBid {
+		return &orderbook.asks
+	}
+
+	return &orderbook.bids
 }
@@ -1,6 +1,11 @@
 package orderbook
 
 import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -198,10 +203,733 @@ func TestMarket_Ask_HighestPriceFirst_SamePricePoint(t *testing.T) {
 		Assert(t)
 }
 
+func TestCancel_Resting(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderLimit(SideAsk, 10000, 61000000).
+		Cancel(1, true).
+		OrderMarket(SideBid, 15000).
+		Trade(10000, 61000000).
+		Reject(3, 5000).
+		Assert(t)
+}
+
+func TestCancel_Unknown(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		Cancel(2, false).
+		Assert(t)
+}
+
+func TestCancel_Filled(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderLimit(SideBid, 10000, 60000000).
+		Cancel(1, false).
+		Trade(10000, 60000000).
+		Assert(t)
+}
+
+func TestCancel_Twice(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideBid, 10000, 60000000).
+		Cancel(1, true).
+		Cancel(1, false).
+		OrderMarket(SideAsk, 10000).
+		Reject(2, 10000).
+		Assert(t)
+}
+
+func TestAmend_Unknown(t *testing.T) {
+	new(testcase).
+		Amend(1, 10000, 60000000, false).
+		Assert(t)
+}
+
+func TestAmend_ReduceVolume_KeepsPriority(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderLimit(SideAsk, 10000, 60000000).
+		Amend(1, 4000, 60000000, true).
+		OrderMarket(SideBid, 6000).
+		TradeBetween(3, 1, 4000, 60000000).
+		TradeBetween(3, 2, 2000, 60000000).
+		Assert(t)
+}
+
+func TestAmend_IncreaseVolume_LosesPriority(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderLimit(SideAsk, 10000, 60000000).
+		Amend(1, 12000, 60000000, true).
+		OrderMarket(SideBid, 15000).
+		TradeBetween(3, 2, 10000, 60000000).
+		TradeBetween(3, 1, 5000, 60000000).
+		Assert(t)
+}
+
+func TestAmend_ChangePrice_LosesPriority(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideBid, 10000, 60000000).
+		OrderLimit(SideBid, 10000, 59000000).
+		Amend(1, 10000, 59000000, true).
+		OrderMarket(SideAsk, 15000).
+		TradeBetween(2, 3, 10000, 59000000).
+		TradeBetween(1, 3, 5000, 59000000).
+		Assert(t)
+}
+
+func TestAmend_ChangePrice_Crosses(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 61000000).
+		OrderLimit(SideBid, 15000, 60000000).
+		Amend(2, 15000, 61000000, true).
+		TradeBetween(2, 1, 10000, 61000000).
+		OrderMarket(SideAsk, 5000).
+		TradeBetween(2, 3, 5000, 61000000).
+		Assert(t)
+}
+
+func TestAmend_ZeroVolume_Cancels(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		Amend(1, 0, 60000000, true).
+		Cancel(1, false).
+		Assert(t)
+}
+
+func TestIOC_PartialMatch(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderLimit(SideBid, 15000, 60000000).
+		TimeInForce(TimeInForceIOC).
+		OrderLimit(SideAsk, 5000, 60000000).
+		Trade(10000, 60000000).
+		Reject(2, 5000).
+		Because(ReasonImmediateOrCancel).
+		Assert(t)
+}
+
+func TestIOC_NoMatch(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 61000000).
+		OrderLimit(SideBid, 10000, 60000000).
+		TimeInForce(TimeInForceIOC).
+		Reject(2, 10000).
+		Assert(t)
+}
+
+func TestFOK_NotEnoughLiquidity(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderLimit(SideAsk, 10000, 62000000).
+		OrderLimit(SideBid, 15000, 61000000).
+		TimeInForce(TimeInForceFOK).
+		OrderMarket(SideBid, 10000).
+		Trade(10000, 60000000).
+		Reject(3, 15000).
+		Because(ReasonFillOrKill).
+		Assert(t)
+}
+
+func TestFOK_DifferentPrices(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderLimit(SideAsk, 10000, 61000000).
+		OrderLimit(SideBid, 15000, 61000000).
+		TimeInForce(TimeInForceFOK).
+		Trade(10000, 60000000).
+		Trade(5000, 61000000).
+		Assert(t)
+}
+
+func TestFOK_Market(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideBid, 10000, 60000000).
+		OrderMarket(SideAsk, 15000).
+		TimeInForce(TimeInForceFOK).
+		OrderMarket(SideAsk, 10000).
+		TimeInForce(TimeInForceFOK).
+		Reject(2, 15000).
+		Trade(10000, 60000000).
+		Assert(t)
+}
+
+func TestPostOnly_Crosses(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderLimit(SideBid, 10000, 60000000).
+		TimeInForce(TimeInForcePostOnly).
+		OrderMarket(SideBid, 10000).
+		Trade(10000, 60000000).
+		Reject(2, 10000).
+		Because(ReasonPostOnly).
+		Assert(t)
+}
+
+func TestPostOnly_Rests(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 61000000).
+		OrderLimit(SideBid, 10000, 60000000).
+		TimeInForce(TimeInForcePostOnly).
+		OrderMarket(SideAsk, 10000).
+		TradeBetween(2, 3, 10000, 60000000).
+		Assert(t)
+}
+
+func TestStop_Bid_Trigger(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderLimit(SideAsk, 10000, 61000000).
+		OrderStop(SideBid, 10000, 61000000).
+		OrderMarket(SideBid, 5000).
+		Returned(1).
+		OrderMarket(SideBid, 10000).
+		Returned(3).
+		TradeBetween(4, 1, 5000, 60000000).
+		TradeBetween(5, 1, 5000, 60000000).
+		TradeBetween(5, 2, 5000, 61000000).
+		TradeBetween(3, 2, 5000, 61000000).
+		Assert(t)
+}
+
+func TestStop_Ask_Trigger(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideBid, 10000, 60000000).
+		OrderLimit(SideBid, 10000, 59000000).
+		OrderStop(SideAsk, 15000, 60000000).
+		OrderMarket(SideAsk, 5000).
+		Returned(3).
+		TradeBetween(1, 4, 5000, 60000000).
+		TradeBetween(1, 3, 5000, 60000000).
+		TradeBetween(2, 3, 10000, 59000000).
+		Assert(t)
+}
+
+func TestStop_Cascade(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderLimit(SideAsk, 10000, 61000000).
+		OrderLimit(SideAsk, 10000, 62000000).
+		OrderStop(SideBid, 10000, 61000000).
+		OrderStop(SideBid, 10000, 60000000).
+		OrderLimit(SideBid, 10000, 60000000).
+		Returned(3).
+		TradeBetween(6, 1, 10000, 60000000).
+		TradeBetween(5, 2, 10000, 61000000).
+		TradeBetween(4, 3, 10000, 62000000).
+		Assert(t)
+}
+
+func TestStop_Cascade_SameTriggerPrice(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderLimit(SideAsk, 10000, 61000000).
+		OrderStop(SideBid, 5000, 60000000).
+		OrderStop(SideBid, 5000, 60000000).
+		OrderMarket(SideBid, 10000).
+		Returned(3).
+		TradeBetween(5, 1, 10000, 60000000).
+		TradeBetween(3, 2, 5000, 61000000).
+		TradeBetween(4, 2, 5000, 61000000).
+		Assert(t)
+}
+
+func TestStop_Reject_Discarded(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderStop(SideBid, 10000, 60000000).
+		OrderMarket(SideBid, 10000).
+		Returned(1).
+		OrderLimit(SideAsk, 10000, 60000000).
+		Returned(0).
+		Trade(10000, 60000000).
+		Assert(t)
+}
+
+func TestStopLimit_Rests(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 5000, 60000000).
+		OrderLimit(SideAsk, 10000, 62000000).
+		OrderStopLimit(SideBid, 10000, 60000000, 61000000).
+		OrderLimit(SideBid, 5000, 60000000).
+		Returned(1).
+		OrderMarket(SideAsk, 15000).
+		TradeBetween(4, 1, 5000, 60000000).
+		TradeBetween(3, 5, 10000, 61000000).
+		Reject(5, 5000).
+		Assert(t)
+}
+
+func TestStop_AlreadyTriggered(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderMarket(SideBid, 5000).
+		OrderStop(SideBid, 5000, 59000000).
+		Returned(1).
+		TradeBetween(2, 1, 5000, 60000000).
+		TradeBetween(3, 1, 5000, 60000000).
+		Assert(t)
+}
+
+func TestStop_Cancel(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderLimit(SideAsk, 10000, 61000000).
+		OrderStop(SideBid, 10000, 60000000).
+		Cancel(3, true).
+		OrderMarket(SideBid, 5000).
+		Returned(1).
+		TradeBetween(4, 1, 5000, 60000000).
+		Assert(t)
+}
+
+func TestIceberg_Replenish_LosesPriority(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 20000, 60000000).
+		Display(10000).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderMarket(SideBid, 10000).
+		OrderMarket(SideBid, 10000).
+		TradeBetween(3, 1, 10000, 60000000).
+		TradeBetween(4, 2, 10000, 60000000).
+		Assert(t)
+}
+
+func TestIceberg_Replenish_BehindOthers(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 30000, 60000000).
+		Display(10000).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderMarket(SideBid, 25000).
+		TradeBetween(3, 1, 10000, 60000000).
+		TradeBetween(3, 2, 10000, 60000000).
+		TradeBetween(3, 1, 5000, 60000000).
+		Hidden(SideAsk, 5000).
+		Assert(t)
+}
+
+func TestIceberg_Replenish_Alone(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideBid, 30000, 60000000).
+		Display(10000).
+		OrderLimit(SideBid, 10000, 59000000).
+		OrderMarket(SideAsk, 35000).
+		TradeBetween(1, 3, 30000, 60000000).
+		Hidden(SideBid, 20000).
+		TradeBetween(2, 3, 5000, 59000000).
+		Assert(t)
+}
+
+func TestIceberg_FOK_MatchesHidden(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 30000, 60000000).
+		Display(10000).
+		OrderLimit(SideBid, 25000, 60000000).
+		TimeInForce(TimeInForceFOK).
+		TradeBetween(2, 1, 25000, 60000000).
+		Hidden(SideAsk, 15000).
+		Assert(t)
+}
+
+func TestIceberg_AmendReduce(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 30000, 60000000).
+		Display(10000).
+		Amend(1, 5000, 60000000, true).
+		OrderMarket(SideBid, 10000).
+		TradeBetween(2, 1, 5000, 60000000).
+		Reject(2, 5000).
+		Assert(t)
+}
+
+func TestSelfTrade_CancelNewest(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		Account(1, SelfTradeAllow).
+		OrderLimit(SideAsk, 10000, 60000000).
+		Account(2, SelfTradeAllow).
+		OrderMarket(SideBid, 15000).
+		Account(1, SelfTradeCancelNewest).
+		Reject(3, 15000).
+		Because(ReasonSelfTrade).
+		Assert(t)
+}
+
+func TestSelfTrade_CancelOldest(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		Account(1, SelfTradeAllow).
+		OrderLimit(SideAsk, 10000, 60000000).
+		Account(2, SelfTradeAllow).
+		OrderMarket(SideBid, 15000).
+		Account(1, SelfTradeCancelOldest).
+		TradeBetween(3, 1, 10000, 60000000).
+		SelfTrade(SelfTradeCancelOldest).
+		TradeBetween(3, 2, 10000, 60000000).
+		Reject(3, 5000).
+		Because(ReasonNoLiquidity).
+		Cancel(1, false).
+		Assert(t)
+}
+
+func TestSelfTrade_CancelBoth(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		Account(1, SelfTradeAllow).
+		OrderLimit(SideAsk, 10000, 60000000).
+		Account(2, SelfTradeAllow).
+		OrderLimit(SideBid, 15000, 60000000).
+		Account(1, SelfTradeCancelBoth).
+		TradeBetween(3, 1, 10000, 60000000).
+		SelfTrade(SelfTradeCancelBoth).
+		Reject(3, 15000).
+		Because(ReasonSelfTrade).
+		Cancel(1, false).
+		Cancel(3, false).
+		Assert(t)
+}
+
+func TestSelfTrade_Decrement_Resting(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		Account(1, SelfTradeAllow).
+		OrderLimit(SideAsk, 10000, 60000000).
+		Account(2, SelfTradeAllow).
+		OrderMarket(SideBid, 15000).
+		Account(1, SelfTradeDecrement).
+		TradeBetween(3, 1, 10000, 60000000).
+		SelfTrade(SelfTradeDecrement).
+		TradeBetween(3, 2, 5000, 60000000).
+		Cancel(1, false).
+		Cancel(2, true).
+		Assert(t)
+}
+
+func TestSelfTrade_Decrement_Incoming(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		Account(1, SelfTradeAllow).
+		OrderLimit(SideBid, 4000, 60000000).
+		Account(1, SelfTradeDecrement).
+		OrderMarket(SideBid, 8000).
+		Account(2, SelfTradeDecrement).
+		TradeBetween(2, 1, 4000, 60000000).
+		SelfTrade(SelfTradeDecrement).
+		TradeBetween(3, 1, 6000, 60000000).
+		Reject(2, 0).
+		Because(ReasonSelfTrade).
+		Reject(3, 2000).
+		Because(ReasonNoLiquidity).
+		Assert(t)
+}
+
+func TestSelfTrade_NoAccount(t *testing.T) {
+	new(testcase).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderMarket(SideBid, 10000).
+		Account(0, SelfTradeCancelNewest).
+		Trade(10000, 60000000).
+		Assert(t)
+}
+
+func TestAllocation_Level(t *testing.T) {
+	for _, row := range []struct {
+		name      string
+		allocator Allocator
+		amount    uint64
+		trades    [][3]uint64
+	}{
+		{
+			name:      "fifo",
+			allocator: FIFO{},
+			amount:    20000,
+			trades:    [][3]uint64{{1, 20000, 60000000}},
+		},
+		{
+			name:      "pro-rata",
+			allocator: ProRata{},
+			amount:    20000,
+			trades: [][3]uint64{
+				{1, 12000, 60000000},
+				{2, 6000, 60000000},
+				{3, 2000, 60000000},
+			},
+		},
+		{
+			name:      "pro-rata with minimum",
+			allocator: ProRata{Minimum: 3000},
+			amount:    20000,
+			trades: [][3]uint64{
+				{1, 14000, 60000000},
+				{2, 6000, 60000000},
+			},
+		},
+		{
+			name:      "pro-rata through levels",
+			allocator: ProRata{},
+			amount:    120000,
+			trades: [][3]uint64{
+				{1, 60000, 60000000},
+				{2, 30000, 60000000},
+				{3, 10000, 60000000},
+				{4, 5000, 60001000},
+			},
+		},
+		{
+			name:      "hybrid",
+			allocator: Hybrid{TopOrder: 20000},
+			amount:    40000,
+			trades: [][3]uint64{
+				{1, 30000, 60000000},
+				{2, 7500, 60000000},
+				{3, 2500, 60000000},
+			},
+		},
+		{
+			name:      "hybrid without top order limit",
+			allocator: Hybrid{},
+			amount:    70000,
+			trades: [][3]uint64{
+				{1, 60000, 60000000},
+				{2, 7500, 60000000},
+				{3, 2500, 60000000},
+			},
+		},
+	} {
+		t.Run(row.name, func(t *testing.T) {
+			testcase := new(testcase).
+				Allocate(row.allocator).
+				OrderLimit(SideAsk, 60000, 60000000).
+				OrderLimit(SideAsk, 30000, 60000000).
+				OrderLimit(SideAsk, 10000, 60000000).
+				OrderLimit(SideAsk, 5000, 60001000).
+				OrderLimit(SideBid, row.amount, 60001000)
+
+			for _, trade := range row.trades {
+				testcase.TradeBetween(5, int(trade[0]), trade[1], trade[2])
+			}
+
+			testcase.Assert(t)
+		})
+	}
+}
+
+// TestAllocation_PricePriority checks that allocation doesn't affect matching
+// of levels with single order.
+func TestAllocation_PricePriority(t *testing.T) {
+	for _, allocator := range []Allocator{
+		FIFO{},
+		ProRata{Minimum: 5000},
+		Hybrid{TopOrder: 5000, Minimum: 5000},
+	} {
+		new(testcase).
+			Allocate(allocator).
+			OrderLimit(SideBid, 15000, 60000000).
+			OrderLimit(SideBid, 15000, 61000000).
+			OrderLimit(SideBid, 15000, 61100000).
+			OrderLimit(SideAsk, 50000, 60000000).
+			TradeBetween(3, 4, 15000, 61100000).
+			TradeBetween(2, 4, 15000, 61000000).
+			TradeBetween(1, 4, 15000, 60000000).
+			OrderMarket(SideBid, 10000).
+			TradeBetween(5, 4, 5000, 60000000).
+			Reject(5, 5000).
+			Because(ReasonNoLiquidity).
+			Assert(t)
+	}
+}
+
+func TestAllocation_ProRata_Iceberg(t *testing.T) {
+	new(testcase).
+		Allocate(ProRata{}).
+		OrderLimit(SideAsk, 30000, 60000000).
+		Display(10000).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderLimit(SideBid, 40000, 60000000).
+		TradeBetween(3, 1, 10000, 60000000).
+		TradeBetween(3, 2, 10000, 60000000).
+		TradeBetween(3, 1, 20000, 60000000).
+		Hidden(SideAsk, 20000).
+		Assert(t)
+}
+
+func TestAllocation_ProRata_SelfTrade(t *testing.T) {
+	new(testcase).
+		Allocate(ProRata{}).
+		OrderLimit(SideAsk, 10000, 60000000).
+		OrderLimit(SideAsk, 30000, 60000000).
+		Account(1, SelfTradeAllow).
+		OrderLimit(SideBid, 20000, 60000000).
+		Account(1, SelfTradeCancelOldest).
+		TradeBetween(3, 2, 30000, 60000000).
+		SelfTrade(SelfTradeCancelOldest).
+		TradeBetween(3, 1, 10000, 60000000).
+		Cancel(3, true).
+		Assert(t)
+}
+
+func TestMatchTo_Buffer(t *testing.T) {
+	test := assert.New(t)
+
+	book := New()
+
+	book.Match(&Order{ID: 1, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 60000000})
+	book.Match(&Order{ID: 2, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 61000000})
+
+	buffer := []Trade{{Volume: 1}}
+
+	trades, reject := book.MatchTo(
+		&Order{ID: 3, Side: SideBid, Kind: KindMarket, Volume: 25000},
+		buffer,
+	)
+
+	test.Len(trades, 3)
+	test.Equal(uint64(1), trades[0].Volume)
+	test.Equal(uint64(10000), trades[1].Volume)
+	test.Equal(1, trades[1].Ask.ID)
+	test.Equal(uint64(10000), trades[2].Volume)
+	test.Equal(2, trades[2].Ask.ID)
+	test.Equal(3, reject.ID)
+	test.Equal(uint64(5000), reject.Volume)
+}
+
+func TestRecycle(t *testing.T) {
+	test := assert.New(t)
+
+	pool := NewPool(3)
+
+	book := New()
+	book.Recycle(pool)
+
+	ask := pool.Get()
+	*ask = Order{ID: 1, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 60000000}
+
+	book.Match(ask)
+
+	bid := pool.Get()
+	*bid = Order{ID: 2, Side: SideBid, Kind: KindLimit, Volume: 10000, Price: 60000000}
+
+	trades, _ := book.Match(bid)
+
+	test.Len(pool.free, 1)
+	test.Equal(1, trades[0].Ask.ID, "filled orders are valid until next call")
+
+	test.False(book.Cancel(1))
+	test.Len(pool.free, 3)
+	test.Equal(Order{}, *ask)
+	test.Equal(Order{}, *bid)
+}
+
+// TestFixtures replays every recorded order stream in testdata and compares
+// its trades with recorded ones. New fixture is recorded with:
+//
+//	orderbook-sim -trades testdata/name.trades.csv testdata/name.orders.csv
+func TestFixtures(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.orders.*"))
+	if !assert.NoError(t, err) || !assert.NotEmpty(t, paths) {
+		return
+	}
+
+	for _, path := range paths {
+		name := strings.SplitN(filepath.Base(path), ".", 2)[0]
+
+		t.Run(name, func(t *testing.T) {
+			test := assert.New(t)
+
+			format := InputCSV
+			if filepath.Ext(path) == ".jsonl" {
+				format = InputJSONLines
+			}
+
+			file, err := os.Open(path)
+			if !test.NoError(err) {
+				return
+			}
+			defer file.Close()
+
+			expected, err := os.ReadFile(filepath.Join("testdata", name+".trades.csv"))
+			if !test.NoError(err) {
+				return
+			}
+
+			book := New()
+			reader := NewInputReader(file, format)
+			buffer := &bytes.Buffer{}
+			writer := NewTradeWriter(buffer)
+
+			for {
+				input, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+
+				if !test.NoError(err) {
+					return
+				}
+
+				trades, _, _ := input.Apply(book)
+
+				for _, trade := range trades {
+					test.NoError(writer.Write(trade))
+				}
+			}
+
+			test.NoError(writer.Flush())
+			test.Equal(string(expected), buffer.String())
+		})
+	}
+}
+
+func TestInputReader_Errors(t *testing.T) {
+	test := assert.New(t)
+
+	testcases := []struct {
+		format InputFormat
+		stream string
+		line   int
+		column string
+		err    error
+	}{
+		{InputCSV, "id,sides\n", 1, "sides", ErrUnknownColumn},
+		{InputCSV, "id,side\n\n1,BID\n2,BUY\n", 4, "side", ErrInvalidValue},
+		{InputCSV, "id,volume\n1,-5\n", 2, "volume", ErrInvalidValue},
+		{InputJSONLines, `{"id":1,"kind":"ICEBERG"}`, 1, "kind", ErrInvalidValue},
+		{InputJSONLines, `{"id":1,"price":[1]}`, 1, "price", ErrInvalidValue},
+	}
+
+	for _, testcase := range testcases {
+		reader := NewInputReader(strings.NewReader(testcase.stream), testcase.format)
+
+		var err error
+		for err == nil {
+			_, err = reader.Read()
+		}
+
+		var inputErr *InputError
+
+		if test.ErrorAs(err, &inputErr, testcase.stream) {
+			test.Equal(testcase.line, inputErr.Line, testcase.stream)
+			test.Equal(testcase.column, inputErr.Column, testcase.stream)
+			test.ErrorIs(err, testcase.err, testcase.stream)
+		}
+	}
+}
+
 type testcase struct {
 	Orders  []*Order
 	Trades  []*Trade
 	Rejects []*Order
+
+	options  []Option
+	steps    []func(*assert.Assertions, *Orderbook) ([]*Trade, *Order)
+	returned map[int]int
+}
+
+func (testcase *testcase) Allocate(allocator Allocator) *testcase {
+	testcase.options = append(testcase.options, WithAllocator(allocator))
+
+	return testcase
 }
 
 func (testcase *testcase) OrderLimit(
@@ -218,6 +946,7 @@ func (testcase *testcase) OrderLimit(
 	}
 
 	testcase.Orders = append(testcase.Orders, order)
+	testcase.match(order)
 
 	return testcase
 }
@@ -234,6 +963,113 @@ func (testcase *testcase) OrderMarket(
 	}
 
 	testcase.Orders = append(testcase.Orders, order)
+	testcase.match(order)
+
+	return testcase
+}
+
+func (testcase *testcase) OrderStop(
+	side Side,
+	amount uint64,
+	stop uint64,
+) *testcase {
+	order := &Order{
+		ID:        len(testcase.Orders) + 1,
+		Side:      side,
+		Kind:      KindStop,
+		Volume:    amount,
+		StopPrice: stop,
+	}
+
+	testcase.Orders = append(testcase.Orders, order)
+	testcase.match(order)
+
+	return testcase
+}
+
+func (testcase *testcase) OrderStopLimit(
+	side Side,
+	amount uint64,
+	stop uint64,
+	price uint64,
+) *testcase {
+	order := &Order{
+		ID:        len(testcase.Orders) + 1,
+		Side:      side,
+		Kind:      KindStopLimit,
+		Volume:    amount,
+		Price:     price,
+		StopPrice: stop,
+	}
+
+	testcase.Orders = append(testcase.Orders, order)
+	testcase.match(order)
+
+	return testcase
+}
+
+func (testcase *testcase) TimeInForce(tif TimeInForce) *testcase {
+	testcase.Orders[len(testcase.Orders)-1].TimeInForce = tif
+
+	return testcase
+}
+
+func (testcase *testcase) Account(account uint64, mode SelfTrade) *testcase {
+	order := testcase.Orders[len(testcase.Orders)-1]
+
+	order.Account = account
+	order.SelfTrade = mode
+
+	return testcase
+}
+
+func (testcase *testcase) Display(amount uint64) *testcase {
+	testcase.Orders[len(testcase.Orders)-1].DisplayVolume = amount
+
+	return testcase
+}
+
+func (testcase *testcase) Cancel(id int, ok bool) *testcase {
+	testcase.steps = append(
+		testcase.steps,
+		func(test *assert.Assertions, book *Orderbook) ([]*Trade, *Order) {
+			test.Equal(ok, book.Cancel(id), "cancel %d result mismatch", id)
+
+			return nil, nil
+		},
+	)
+
+	return testcase
+}
+
+func (testcase *testcase) Amend(
+	id int,
+	amount uint64,
+	price uint64,
+	ok bool,
+) *testcase {
+	testcase.steps = append(
+		testcase.steps,
+		func(test *assert.Assertions, book *Orderbook) ([]*Trade, *Order) {
+			trades, amended := book.Amend(id, amount, price)
+
+			test.Equal(ok, amended, "amend %d result mismatch", id)
+
+			return trades, nil
+		},
+	)
+
+	return testcase
+}
+
+// Returned expects previous step to return specified number of trades, which
+// includes trades of every stop order triggered by it.
+func (testcase *testcase) Returned(count int) *testcase {
+	if testcase.returned == nil {
+		testcase.returned = map[int]int{}
+	}
+
+	testcase.returned[len(testcase.steps)-1] = count
 
 	return testcase
 }
@@ -250,6 +1086,46 @@ func (testcase *testcase) Trade(amount uint64, price uint64) *testcase {
 	return testcase
 }
 
+func (testcase *testcase) TradeBetween(
+	bid int,
+	ask int,
+	amount uint64,
+	price uint64,
+) *testcase {
+	testcase.Trades = append(
+		testcase.Trades,
+		&Trade{
+			Bid:    &Order{ID: bid},
+			Ask:    &Order{ID: ask},
+			Volume: amount,
+			Price:  price,
+		},
+	)
+
+	return testcase
+}
+
+// Hidden expects previous trade to execute specified volume against hidden
+// reserve of iceberg order of specified side.
+func (testcase *testcase) Hidden(side Side, amount uint64) *testcase {
+	trade := testcase.Trades[len(testcase.Trades)-1]
+
+	if side == SideBid {
+		trade.BidHidden = amount
+	} else {
+		trade.AskHidden = amount
+	}
+
+	return testcase
+}
+
+// SelfTrade expects previous trade to be reported by self-trade prevention.
+func (testcase *testcase) SelfTrade(mode SelfTrade) *testcase {
+	testcase.Trades[len(testcase.Trades)-1].SelfTrade = mode
+
+	return testcase
+}
+
 func (testcase *testcase) Reject(uuid int, amount uint64) *testcase {
 	testcase.Rejects = append(
 		testcase.Rejects,
@@ -262,18 +1138,29 @@ func (testcase *testcase) Reject(uuid int, amount uint64) *testcase {
 	return testcase
 }
 
+// Because expects previous reject to have specified reason.
+func (testcase *testcase) Because(reason Reason) *testcase {
+	testcase.Rejects[len(testcase.Rejects)-1].Reason = reason
+
+	return testcase
+}
+
 func (testcase *testcase) Assert(t *testing.T) {
 	test := assert.New(t)
 
-	book := New()
+	book := New(testcase.options...)
 
 	var (
 		trades  []*Trade
 		rejects []*Order
 	)
 
-	for _, order := range testcase.Orders {
-		traded, reject := book.Match(order)
+	for i, step := range testcase.steps {
+		traded, reject := step(test, book)
+
+		if count, ok := testcase.returned[i]; ok {
+			test.Len(traded, count, "step %d trades number mismatch", i)
+		}
 
 		trades = append(trades, traded...)
 
@@ -296,6 +1183,40 @@ func (testcase *testcase) Assert(t *testing.T) {
 			int(trades[i].Price),
 			"trade %d price mismatch", i,
 		)
+
+		test.Equal(
+			int(testcase.Trades[i].BidHidden),
+			int(trades[i].BidHidden),
+			"trade %d bid hidden volume mismatch", i,
+		)
+
+		test.Equal(
+			int(testcase.Trades[i].AskHidden),
+			int(trades[i].AskHidden),
+			"trade %d ask hidden volume mismatch", i,
+		)
+
+		test.Equal(
+			testcase.Trades[i].SelfTrade,
+			trades[i].SelfTrade,
+			"trade %d self-trade mismatch", i,
+		)
+
+		if testcase.Trades[i].Bid != nil {
+			test.Equal(
+				testcase.Trades[i].Bid.ID,
+				trades[i].Bid.ID,
+				"trade %d bid mismatch", i,
+			)
+		}
+
+		if testcase.Trades[i].Ask != nil {
+			test.Equal(
+				testcase.Trades[i].Ask.ID,
+				trades[i].Ask.ID,
+				"trade %d ask mismatch", i,
+			)
+		}
 	}
 
 	test.Len(rejects, len(testcase.Rejects), "rejects number mismatch")
@@ -312,5 +1233,22 @@ func (testcase *testcase) Assert(t *testing.T) {
 			int(rejects[i].Volume),
 			"reject %d volume mismatch", i,
 		)
+
+		if testcase.Rejects[i].Reason != ReasonNone {
+			test.Equal(
+				testcase.Rejects[i].Reason,
+				rejects[i].Reason,
+				"reject %d reason mismatch", i,
+			)
+		}
 	}
 }
+
+func (testcase *testcase) match(order *Order) {
+	testcase.steps = append(
+		testcase.steps,
+		func(_ *assert.Assertions, book *Orderbook) ([]*Trade, *Order) {
+			return book.Match(order)
+		},
+	)
+}
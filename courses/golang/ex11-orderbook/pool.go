@@ -0,0 +1,91 @@
+package orderbook
+
+// Pool recycles orders, so matching doesn't allocate them. Orders are
+// preallocated in single contiguous block.
+type Pool struct {
+	free []*Order
+}
+
+func NewPool(capacity int) *Pool {
+	orders := make([]Order, capacity)
+
+	pool := &Pool{
+		free: make([]*Order, capacity),
+	}
+
+	for i := range orders {
+		pool.free[i] = &orders[i]
+	}
+
+	return pool
+}
+
+// Get returns zeroed order, allocating new one only if pool is exhausted.
+func (pool *Pool) Get() *Order {
+	count := len(pool.free)
+	if count == 0 {
+		return &Order{}
+	}
+
+	order := pool.free[count-1]
+
+	pool.free = pool.free[:count-1]
+
+	return order
+}
+
+func (pool *Pool) Put(order *Order) {
+	*order = Order{}
+
+	pool.free = append(pool.free, order)
+}
+
+// Recycle makes book own every order passed to it and return orders which
+// left the book (filled, cancelled or rejected ones) to specified pool.
+// Orders are returned on the next call to the book, so orders referenced by
+// trades and rejects stay valid until then. Nil pool disables recycling.
+func (orderbook *Orderbook) Recycle(pool *Pool) {
+	orderbook.recycle()
+
+	orderbook.pool = pool
+}
+
+func (orderbook *Orderbook) retire(order *Order) {
+	if orderbook.pool != nil {
+		orderbook.retired = append(orderbook.retired, order)
+	}
+}
+
+func (orderbook *Orderbook) recycle() {
+	for i, order := range orderbook.retired {
+		orderbook.pool.Put(order)
+
+		orderbook.retired[i] = nil
+	}
+
+	orderbook.retired = orderbook.retired[:0]
+}
+
+// levelPool recycles price levels of both sides of the book.
+type levelPool struct {
+	free []*level
+}
+
+func (pool *levelPool) get(side Side, price uint64) *level {
+	count := len(pool.free)
+	if count == 0 {
+		return &level{side: side, price: price}
+	}
+
+	reused := pool.free[count-1]
+
+	pool.free = pool.free[:count-1]
+
+	*reused = level{side: side, price: price}
+
+	return reused
+}
+
+func (pool *levelPool) put(level *level) {
+	pool.free = append(pool.free, level)
+}
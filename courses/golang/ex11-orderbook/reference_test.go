@@ -0,0 +1,151 @@
+package orderbook
+
+// reference is naive orderbook used to check the optimized one: each side is
+// plain slice of orders sorted from the best price, and orders of the same
+// price keep arrival order. It supports limit and market orders and
+// cancellation.
+type reference struct {
+	bids []*referenceOrder
+	asks []*referenceOrder
+}
+
+type referenceOrder struct {
+	id     int
+	side   Side
+	market bool
+	volume uint64
+	price  uint64
+}
+
+type referenceTrade struct {
+	bid    int
+	ask    int
+	volume uint64
+	price  uint64
+}
+
+func (reference *reference) side(side Side) *[]*referenceOrder {
+	if side == SideBid {
+		return &reference.bids
+	}
+
+	return &reference.asks
+}
+
+func (reference *reference) opposite(side Side) *[]*referenceOrder {
+	if side == SideBid {
+		return &reference.asks
+	}
+
+	return &reference.bids
+}
+
+// better reports whether price a is better than price b for side.
+func (reference *reference) better(side Side, a uint64, b uint64) bool {
+	if side == SideBid {
+		return a > b
+	}
+
+	return a < b
+}
+
+// match returns trades of incoming order and whether it was rejected.
+func (reference *reference) match(order referenceOrder) ([]referenceTrade, bool) {
+	var trades []referenceTrade
+
+	opposite := reference.opposite(order.side)
+
+	for order.volume > 0 && len(*opposite) > 0 {
+		resting := (*opposite)[0]
+
+		if !order.market && reference.better(order.side, resting.price, order.price) {
+			break
+		}
+
+		volume := order.volume
+		if resting.volume < volume {
+			volume = resting.volume
+		}
+
+		trade := referenceTrade{
+			bid:    order.id,
+			ask:    resting.id,
+			volume: volume,
+			price:  resting.price,
+		}
+
+		if order.side == SideAsk {
+			trade.bid, trade.ask = resting.id, order.id
+		}
+
+		trades = append(trades, trade)
+
+		order.volume -= volume
+		resting.volume -= volume
+
+		if resting.volume == 0 {
+			*opposite = (*opposite)[1:]
+		}
+	}
+
+	if order.volume == 0 {
+		return trades, false
+	}
+
+	if order.market {
+		return trades, true
+	}
+
+	same := reference.side(order.side)
+
+	position := len(*same)
+
+	for i, resting := range *same {
+		if reference.better(order.side, order.price, resting.price) {
+			position = i
+
+			break
+		}
+	}
+
+	*same = append(*same, nil)
+	copy((*same)[position+1:], (*same)[position:])
+	(*same)[position] = &order
+
+	return trades, false
+}
+
+func (reference *reference) cancel(id int) bool {
+	for _, side := range []Side{SideBid, SideAsk} {
+		orders := reference.side(side)
+
+		for i, order := range *orders {
+			if order.id == id {
+				*orders = append((*orders)[:i], (*orders)[i+1:]...)
+
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// depth returns aggregated levels of side like Snapshot does.
+func (reference *reference) depth(side Side) []DepthLevel {
+	var levels []DepthLevel
+
+	for _, order := range *reference.side(side) {
+		count := len(levels)
+
+		if count == 0 || levels[count-1].Price != order.price {
+			levels = append(levels, DepthLevel{Price: order.price})
+			count++
+		}
+
+		levels[count-1].Volume += order.volume
+		levels[count-1].Count++
+	}
+
+	return levels
+}
@@ -0,0 +1,87 @@
+package orderbook
+
+import "sync/atomic"
+
+// ring is bounded lock-free queue with many producers and single consumer,
+// based on Dmitry Vyukov's MPMC queue. Every cell carries sequence number
+// which tells whether it's ready to be written or read at given position.
+type ring struct {
+	mask  uint64
+	cells []cell
+
+	_    [56]byte
+	head atomic.Uint64
+	_    [56]byte
+
+	// tail is owned by the consumer.
+	tail uint64
+}
+
+type cell struct {
+	sequence atomic.Uint64
+	request  request
+}
+
+// newRing creates ring of capacity rounded up to the power of two.
+func newRing(capacity int) *ring {
+	size := 2
+	for size < capacity {
+		size <<= 1
+	}
+
+	ring := &ring{
+		mask:  uint64(size - 1),
+		cells: make([]cell, size),
+	}
+
+	for i := range ring.cells {
+		ring.cells[i].sequence.Store(uint64(i))
+	}
+
+	return ring
+}
+
+// push enqueues request and returns false if ring is full.
+func (ring *ring) push(request request) bool {
+	for {
+		position := ring.head.Load()
+		cell := &ring.cells[position&ring.mask]
+		sequence := cell.sequence.Load()
+
+		switch {
+		case sequence == position:
+			if ring.head.CompareAndSwap(position, position+1) {
+				cell.request = request
+				cell.sequence.Store(position + 1)
+
+				return true
+			}
+		case sequence < position:
+			return false
+		}
+	}
+}
+
+// pop dequeues request and returns false if ring is empty. It must be called
+// only by the consumer.
+func (ring *ring) pop() (request, bool) {
+	cell := &ring.cells[ring.tail&ring.mask]
+
+	if cell.sequence.Load() != ring.tail+1 {
+		return request{}, false
+	}
+
+	next := cell.request
+
+	cell.request = request{}
+	cell.sequence.Store(ring.tail + ring.mask + 1)
+
+	ring.tail++
+
+	return next, true
+}
+
+// empty reports whether consumer has nothing to pop.
+func (ring *ring) empty() bool {
+	return ring.cells[ring.tail&ring.mask].sequence.Load() != ring.tail+1
+}
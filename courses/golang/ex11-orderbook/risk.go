@@ -0,0 +1,392 @@
+package orderbook
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// basisPoints is number of basis points in whole.
+const basisPoints = 10000
+
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// RiskLimits are pre-trade limits applied to every order. Zero value of any
+// limit means that it is not enforced.
+type RiskLimits struct {
+	// Precision is number of implied decimal places of price and volume,
+	// DefaultPrecision is used if it's nil. Zero means whole units.
+	Precision *uint8
+
+	MaxOrderSize  uint64
+	MaxOpenOrders int
+
+	// PriceBand is maximum distance of order price from reference price in
+	// basis points. Reference price is last trade price, or stop price for
+	// stop orders.
+	PriceBand uint64
+}
+
+// Asset is balance of account in single asset. Reserved part backs open
+// orders and can't be withdrawn.
+type Asset struct {
+	Available uint64
+	Reserved  uint64
+}
+
+// Balance of account in base asset, which is traded, and quote asset, in
+// which prices are expressed.
+type Balance struct {
+	Base  Asset
+	Quote Asset
+}
+
+func (balance *Balance) asset(side Side) *Asset {
+	if side == SideBid {
+		return &balance.Quote
+	}
+
+	return &balance.Base
+}
+
+type account struct {
+	balance Balance
+	open    int
+}
+
+// hold is reservation of open order: quote asset at order price for bids
+// and base asset for asks.
+type hold struct {
+	account  *account
+	side     Side
+	price    uint64
+	reserved uint64
+}
+
+// Risk is orderbook which checks every order against pre-trade limits and
+// balance of its account before matching it. Funds are reserved when order is
+// accepted, trades are settled as they happen, and reservation is released
+// when order is filled, cancelled or rejected. Orders failing the checks are
+// rejected before reaching the book with one of risk reasons.
+//
+// Market and stop orders are given protection price at the edge of price
+// band, so market order becomes immediate-or-cancel limit order and stop
+// order becomes stop-limit one. They are rejected if there is no price band
+// or no reference price, as the amount to reserve is unknown, and market
+// orders are therefore not accepted during call phase.
+type Risk struct {
+	*Orderbook
+
+	limits     RiskLimits
+	instrument Instrument
+
+	accounts map[uint64]*account
+	holds    map[int]*hold
+}
+
+// riskListener settles executions of orders reserved by Risk.
+type riskListener struct {
+	risk *Risk
+}
+
+func (listener riskListener) OnEvent(event Event) {
+	listener.risk.settle(event)
+}
+
+// NewRisk wraps book with risk checks. Every order of the book should go
+// through the returned Risk, as orders matched directly are not accounted.
+func NewRisk(book *Orderbook, limits RiskLimits) *Risk {
+	risk := &Risk{
+		Orderbook:  book,
+		limits:     limits,
+		instrument: Instrument{Precision: limits.Precision}.resolved(),
+		accounts:   map[uint64]*account{},
+		holds:      map[int]*hold{},
+	}
+
+	book.listeners = append(book.listeners, riskListener{risk: risk})
+
+	return risk
+}
+
+// Deposit adds funds to available balance of account.
+func (risk *Risk) Deposit(id uint64, base uint64, quote uint64) {
+	account := risk.account(id)
+
+	account.balance.Base.Available += base
+	account.balance.Quote.Available += quote
+}
+
+// Withdraw takes funds from available balance of account. Nothing is taken
+// if any of the amounts exceeds available balance.
+func (risk *Risk) Withdraw(id uint64, base uint64, quote uint64) error {
+	account := risk.account(id)
+
+	if account.balance.Base.Available < base ||
+		account.balance.Quote.Available < quote {
+		return ErrInsufficientFunds
+	}
+
+	account.balance.Base.Available -= base
+	account.balance.Quote.Available -= quote
+
+	return nil
+}
+
+func (risk *Risk) Balance(id uint64) Balance {
+	account, ok := risk.accounts[id]
+	if !ok {
+		return Balance{}
+	}
+
+	return account.balance
+}
+
+// OpenOrders returns number of resting and held stop orders of account.
+func (risk *Risk) OpenOrders(id uint64) int {
+	account, ok := risk.accounts[id]
+	if !ok {
+		return 0
+	}
+
+	return account.open
+}
+
+func (risk *Risk) Match(order *Order) ([]*Trade, *Order) {
+	if reason := risk.check(order); reason != ReasonNone {
+		return nil, risk.Orderbook.reject(order, reason)
+	}
+
+	return risk.Orderbook.Match(order)
+}
+
+func (risk *Risk) MatchTo(order *Order, trades []Trade) ([]Trade, *Order) {
+	if reason := risk.check(order); reason != ReasonNone {
+		return trades, risk.Orderbook.reject(order, reason)
+	}
+
+	return risk.Orderbook.MatchTo(order, trades)
+}
+
+// Amend checks new volume and price of order like Match does and adjusts its
+// reservation before amending it in the book. If amendment fails the checks,
+// order is left unchanged and the reason is returned along with false.
+func (risk *Risk) Amend(
+	id int,
+	volume uint64,
+	price uint64,
+) ([]*Trade, bool, Reason) {
+	hold, ok := risk.holds[id]
+	if !ok || volume == 0 {
+		trades, ok := risk.Orderbook.Amend(id, volume, price)
+
+		return trades, ok, ReasonNone
+	}
+
+	order := risk.orders[id]
+
+	switch {
+	case risk.limits.MaxOrderSize > 0 && volume > risk.limits.MaxOrderSize:
+		return nil, false, ReasonMaxOrderSize
+	case !risk.banded(order, price):
+		return nil, false, ReasonPriceBand
+	}
+
+	reserve := risk.reserve(hold.side, price, volume)
+	asset := hold.account.balance.asset(hold.side)
+
+	if reserve > hold.reserved && reserve-hold.reserved > asset.Available {
+		return nil, false, ReasonInsufficientFunds
+	}
+
+	asset.Available = asset.Available + hold.reserved - reserve
+	asset.Reserved = asset.Reserved - hold.reserved + reserve
+
+	hold.price = price
+	hold.reserved = reserve
+
+	trades, ok := risk.Orderbook.Amend(id, volume, price)
+
+	return trades, ok, ReasonNone
+}
+
+func (risk *Risk) account(id uint64) *account {
+	existing, ok := risk.accounts[id]
+	if !ok {
+		existing = &account{}
+
+		risk.accounts[id] = existing
+	}
+
+	return existing
+}
+
+// check applies limits to order and reserves funds for it. It returns
+// reason of rejection if order can't be accepted.
+func (risk *Risk) check(order *Order) Reason {
+	account := risk.account(order.Account)
+
+	switch {
+	case risk.limits.MaxOrderSize > 0 && order.Volume > risk.limits.MaxOrderSize:
+		return ReasonMaxOrderSize
+	case risk.limits.MaxOpenOrders > 0 && account.open >= risk.limits.MaxOpenOrders:
+		return ReasonMaxOpenOrders
+	case !risk.protect(order):
+		return ReasonPriceBand
+	}
+
+	reserve := risk.reserve(order.Side, order.Price, order.Volume)
+	asset := account.balance.asset(order.Side)
+
+	if asset.Available < reserve {
+		return ReasonInsufficientFunds
+	}
+
+	asset.Available -= reserve
+	asset.Reserved += reserve
+
+	account.open++
+
+	risk.holds[order.ID] = &hold{
+		account:  account,
+		side:     order.Side,
+		price:    order.Price,
+		reserved: reserve,
+	}
+
+	return ReasonNone
+}
+
+// protect gives protection price to market and stop orders and checks that
+// price of other orders is within price band.
+func (risk *Risk) protect(order *Order) bool {
+	if order.priced() {
+		return risk.banded(order, order.Price)
+	}
+
+	low, high, ok := risk.band(risk.reference(order))
+	if !ok {
+		return false
+	}
+
+	order.Price = low
+	if order.Side == SideBid {
+		order.Price = high
+	}
+
+	if order.Kind == KindStop {
+		order.Kind = KindStopLimit
+	} else {
+		order.Kind = KindLimit
+		order.TimeInForce = TimeInForceIOC
+	}
+
+	return true
+}
+
+// banded reports whether price of order is within price band. Any price is
+// accepted if there is no band.
+func (risk *Risk) banded(order *Order, price uint64) bool {
+	low, high, ok := risk.band(risk.reference(order))
+
+	return !ok || (price >= low && price <= high)
+}
+
+func (risk *Risk) reference(order *Order) uint64 {
+	if order.stop() {
+		return order.StopPrice
+	}
+
+	return risk.last
+}
+
+// band returns the lowest and the highest price within price band around
+// reference price.
+func (risk *Risk) band(reference uint64) (uint64, uint64, bool) {
+	if risk.limits.PriceBand == 0 || reference == 0 {
+		return 0, 0, false
+	}
+
+	offset := uint64(math.MaxUint64)
+
+	if hi, lo := bits.Mul64(reference, risk.limits.PriceBand); hi < basisPoints {
+		offset, _ = bits.Div64(hi, lo, basisPoints)
+	}
+
+	low := uint64(0)
+	if offset < reference {
+		low = reference - offset
+	}
+
+	high, carry := bits.Add64(reference, offset, 0)
+	if carry != 0 {
+		high = math.MaxUint64
+	}
+
+	return low, high, true
+}
+
+// reserve returns amount to reserve for order of specified side.
+func (risk *Risk) reserve(side Side, price uint64, volume uint64) uint64 {
+	if side == SideBid {
+		return risk.instrument.Notional(price, volume)
+	}
+
+	return volume
+}
+
+// settle accounts execution event of reserved order. Bid pays for trade
+// from its reservation at trade price and the rest of reservation for
+// traded volume is released, while ask hands over reserved base asset.
+func (risk *Risk) settle(event Event) {
+	hold, ok := risk.holds[event.OrderID]
+	if !ok {
+		return
+	}
+
+	switch event.Type {
+	case EventPartiallyFilled, EventFilled:
+		risk.fill(hold, event.LastVolume, event.LastPrice)
+
+		if event.Type == EventFilled {
+			risk.release(event.OrderID, hold)
+		}
+	case EventCancelled, EventRejected:
+		risk.release(event.OrderID, hold)
+	}
+}
+
+func (risk *Risk) fill(hold *hold, volume uint64, price uint64) {
+	balance := &hold.account.balance
+	notional := risk.instrument.Notional(price, volume)
+
+	if hold.side == SideAsk {
+		hold.reserved -= volume
+		balance.Base.Reserved -= volume
+		balance.Quote.Available += notional
+
+		return
+	}
+
+	// Reservation is rounded down for the whole volume, so it covers sum of
+	// reservations of its parts.
+	reserved := risk.instrument.Notional(hold.price, volume)
+
+	hold.reserved -= reserved
+	balance.Quote.Reserved -= reserved
+	balance.Quote.Available += reserved - notional
+	balance.Base.Available += volume
+}
+
+// release returns the rest of reservation to available balance and closes
+// order.
+func (risk *Risk) release(id int, hold *hold) {
+	asset := hold.account.balance.asset(hold.side)
+
+	asset.Reserved -= hold.reserved
+	asset.Available += hold.reserved
+
+	hold.account.open--
+
+	delete(risk.holds, id)
+}
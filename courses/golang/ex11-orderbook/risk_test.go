@@ -0,0 +1,186 @@
+package orderbook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRisk_Settlement(t *testing.T) {
+	test := assert.New(t)
+
+	risk := NewRisk(New(), RiskLimits{})
+
+	risk.Deposit(1, 20000, 0)
+	risk.Deposit(2, 0, 20000000)
+
+	_, reject := risk.Match(&Order{ID: 1, Account: 1, Side: SideAsk, Kind: KindLimit, Volume: 15000, Price: 600000})
+	test.Nil(reject)
+
+	test.Equal(Balance{Base: Asset{Available: 5000, Reserved: 15000}}, risk.Balance(1))
+	test.Equal(1, risk.OpenOrders(1))
+
+	_, reject = risk.Match(&Order{ID: 2, Account: 2, Side: SideBid, Kind: KindLimit, Volume: 20000, Price: 610000})
+	test.Nil(reject)
+
+	test.Equal(
+		Balance{
+			Base:  Asset{Available: 5000},
+			Quote: Asset{Available: 900000},
+		},
+		risk.Balance(1),
+	)
+	test.Equal(0, risk.OpenOrders(1))
+
+	// Bid reserved 1220000 for the whole volume, 15000 was bought at 600000
+	// for 900000 and the rest of reservation for them was released.
+	test.Equal(
+		Balance{
+			Base:  Asset{Available: 15000},
+			Quote: Asset{Available: 20000000 - 1220000 + 15000, Reserved: 305000},
+		},
+		risk.Balance(2),
+	)
+
+	trades, ok, reason := risk.Amend(2, 5000, 620000)
+	test.Empty(trades)
+	test.True(ok)
+	test.Equal(ReasonNone, reason)
+	test.Equal(Asset{Available: 20000000 - 900000 - 310000, Reserved: 310000}, risk.Balance(2).Quote)
+
+	test.True(risk.Cancel(2))
+	test.Equal(Asset{Available: 20000000 - 900000}, risk.Balance(2).Quote)
+	test.Equal(0, risk.OpenOrders(2))
+
+	test.ErrorIs(risk.Withdraw(1, 5000, 900001), ErrInsufficientFunds)
+	test.NoError(risk.Withdraw(1, 5000, 900000))
+	test.Equal(Balance{}, risk.Balance(1))
+}
+
+func TestRisk_Limits(t *testing.T) {
+	test := assert.New(t)
+
+	limits := RiskLimits{
+		MaxOrderSize:  50000,
+		MaxOpenOrders: 2,
+		PriceBand:     500,
+	}
+
+	testcases := []struct {
+		name   string
+		order  Order
+		reason Reason
+	}{
+		{
+			name:   "funds",
+			order:  Order{Account: 2, Side: SideBid, Kind: KindLimit, Volume: 40000, Price: 1000000},
+			reason: ReasonInsufficientFunds,
+		},
+		{
+			name:   "size",
+			order:  Order{Account: 2, Side: SideBid, Kind: KindLimit, Volume: 60000, Price: 1000000},
+			reason: ReasonMaxOrderSize,
+		},
+		{
+			name:   "open orders",
+			order:  Order{Account: 1, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 1050000},
+			reason: ReasonMaxOpenOrders,
+		},
+		{
+			name:   "band",
+			order:  Order{Account: 2, Side: SideBid, Kind: KindLimit, Volume: 10000, Price: 940000},
+			reason: ReasonPriceBand,
+		},
+		{
+			name:   "stop band",
+			order:  Order{Account: 2, Side: SideBid, Kind: KindStopLimit, Volume: 10000, Price: 1200000, StopPrice: 1100000},
+			reason: ReasonPriceBand,
+		},
+		{
+			name:  "within band",
+			order: Order{Account: 2, Side: SideBid, Kind: KindLimit, Volume: 10000, Price: 950000},
+		},
+	}
+
+	for i, testcase := range testcases {
+		risk := NewRisk(New(), limits)
+
+		risk.Deposit(1, 100000, 0)
+		risk.Deposit(2, 0, 3000000)
+
+		risk.Match(&Order{ID: 1, Account: 1, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 1000000})
+		risk.Match(&Order{ID: 2, Account: 2, Side: SideBid, Kind: KindLimit, Volume: 5000, Price: 1000000})
+		risk.Match(&Order{ID: 3, Account: 1, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 1050000})
+
+		before := risk.Balance(testcase.order.Account)
+
+		order := testcase.order
+		order.ID = 10 + i
+
+		_, reject := risk.Match(&order)
+
+		if testcase.reason == ReasonNone {
+			test.Nil(reject, testcase.name)
+
+			continue
+		}
+
+		if test.NotNil(reject, testcase.name) {
+			test.Equal(testcase.reason, reject.Reason, testcase.name)
+		}
+
+		test.Equal(before, risk.Balance(testcase.order.Account), testcase.name)
+	}
+}
+
+func TestRisk_Protection(t *testing.T) {
+	test := assert.New(t)
+
+	risk := NewRisk(New(), RiskLimits{PriceBand: 100})
+
+	risk.Deposit(1, 30000, 0)
+	risk.Deposit(2, 0, 100000000)
+
+	market := &Order{ID: 1, Account: 2, Side: SideBid, Kind: KindMarket, Volume: 10000}
+
+	_, reject := risk.Match(market)
+	if test.NotNil(reject) {
+		test.Equal(ReasonPriceBand, reject.Reason, "no reference price")
+	}
+
+	risk.Match(&Order{ID: 2, Account: 1, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 1000000})
+	risk.Match(&Order{ID: 3, Account: 2, Side: SideBid, Kind: KindLimit, Volume: 5000, Price: 1000000})
+
+	_, reject = risk.Match(&Order{ID: 4, Account: 1, Side: SideAsk, Kind: KindLimit, Volume: 10000, Price: 1020000})
+	if test.NotNil(reject) {
+		test.Equal(ReasonPriceBand, reject.Reason)
+	}
+
+	market = &Order{ID: 5, Account: 2, Side: SideBid, Kind: KindMarket, Volume: 10000}
+
+	trades, reject := risk.Match(market)
+	test.Len(trades, 1)
+	test.Equal(KindLimit, market.Kind)
+	test.Equal(TimeInForceIOC, market.TimeInForce)
+	test.Equal(uint64(1010000), market.Price)
+
+	if test.NotNil(reject) {
+		test.Equal(ReasonImmediateOrCancel, reject.Reason)
+	}
+
+	test.Equal(
+		Balance{
+			Base:  Asset{Available: 10000},
+			Quote: Asset{Available: 100000000 - 1000000},
+		},
+		risk.Balance(2),
+	)
+
+	stop := &Order{ID: 6, Account: 1, Side: SideAsk, Kind: KindStop, Volume: 10000, StopPrice: 900000}
+
+	_, reject = risk.Match(stop)
+	test.Nil(reject)
+	test.Equal(KindStopLimit, stop.Kind)
+	test.Equal(uint64(891000), stop.Price)
+	test.Equal(Asset{Available: 10000, Reserved: 10000}, risk.Balance(1).Base)
+}
@@ -0,0 +1,239 @@
+package orderbook
+
+import (
+	"bufio"
+	"sync"
+	"time"
+)
+
+// session is identified by sender ID and outlives connections. Every
+// sequenced message sent to the session is kept, so messages missed while
+// disconnected are resent on logon or on resend request.
+type session struct {
+	id string
+
+	// incoming is the next expected sequence number of client message and
+	// orders maps client order IDs to IDs in the book, both are guarded by
+	// gateway mutex.
+	incoming uint64
+	orders   map[int]int
+
+	mutex   sync.Mutex
+	outbox  []Message
+	control []Message
+
+	// cursor is index of the next outbox message to write to connection,
+	// sent is number of messages which were passed to connection at least
+	// once and written is number of them which were flushed successfully.
+	cursor  int
+	sent    int
+	written int
+
+	connection *connection
+	scratch    []Message
+}
+
+// connection is single logon of session.
+type connection struct {
+	wake chan struct{}
+	done chan struct{}
+}
+
+func newSession(id string) *session {
+	return &session{
+		id:       id,
+		incoming: 1,
+		orders:   map[int]int{},
+	}
+}
+
+// send queues message to the session. Sequenced messages are numbered and
+// kept for resend, while session level ones are dropped if session is not
+// connected.
+func (session *session) send(message Message) {
+	session.mutex.Lock()
+
+	if message.Type.sequenced() {
+		message.Sequence = uint64(len(session.outbox) + 1)
+
+		session.outbox = append(session.outbox, message)
+	} else if session.connection != nil {
+		session.control = append(session.control, message)
+	}
+
+	connection := session.connection
+
+	session.mutex.Unlock()
+
+	if connection != nil {
+		connection.notify()
+	}
+}
+
+// attach makes connection current one and rewinds outbox to the next
+// message expected by client. Zero means every message which was not
+// written yet. It returns false if session is already connected.
+func (session *session) attach(connection *connection, next uint64) bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if session.connection != nil {
+		return false
+	}
+
+	session.connection = connection
+	session.control = nil
+
+	if next == 0 {
+		session.cursor = session.written
+	} else {
+		session.rewind(next)
+	}
+
+	return true
+}
+
+func (session *session) detach(connection *connection) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if session.connection == connection {
+		session.connection = nil
+	}
+}
+
+// resend rewinds outbox to the specified sequence number.
+func (session *session) resend(next uint64) {
+	session.mutex.Lock()
+
+	session.rewind(next)
+
+	connection := session.connection
+
+	session.mutex.Unlock()
+
+	if connection != nil {
+		connection.notify()
+	}
+}
+
+func (session *session) rewind(next uint64) {
+	switch {
+	case next == 0:
+		session.cursor = 0
+	case next > uint64(len(session.outbox)):
+		session.cursor = len(session.outbox)
+	default:
+		session.cursor = int(next - 1)
+	}
+}
+
+// pending returns messages which should be written to connection and index
+// of outbox message following them. Messages which were sent before are
+// marked as possible duplicates.
+func (session *session) pending() ([]Message, int) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	messages := append(session.scratch[:0], session.control...)
+
+	session.control = session.control[:0]
+
+	for i := session.cursor; i < len(session.outbox); i++ {
+		message := session.outbox[i]
+
+		message.PossibleDuplicate = i < session.sent
+
+		messages = append(messages, message)
+	}
+
+	session.cursor = len(session.outbox)
+
+	if session.sent < session.cursor {
+		session.sent = session.cursor
+	}
+
+	session.scratch = messages
+
+	return messages, session.cursor
+}
+
+// flushed marks outbox messages before specified index as written, so
+// they're not written again on logon which doesn't request them.
+func (session *session) flushed(end int) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if session.written < end {
+		session.written = end
+	}
+}
+
+// write sends queued messages to connection until it's closed, flushing
+// messages queued before that. Heartbeat is sent if nothing was written
+// during heartbeat interval, zero interval disables heartbeats.
+func (session *session) write(
+	connection *connection,
+	writer *bufio.Writer,
+	codec codec,
+	heartbeat time.Duration,
+) error {
+	timer := time.NewTimer(heartbeat)
+	defer timer.Stop()
+
+	if heartbeat <= 0 {
+		timer.Stop()
+	}
+
+	for {
+		closed := false
+
+		select {
+		case <-connection.done:
+			closed = true
+		case <-connection.wake:
+		case <-timer.C:
+			message := Message{Type: MessageHeartbeat}
+
+			if err := codec.write(writer, &message); err != nil {
+				return err
+			}
+		}
+
+		messages, end := session.pending()
+
+		for _, message := range messages {
+			if err := codec.write(writer, &message); err != nil {
+				return err
+			}
+		}
+
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+
+		session.flushed(end)
+
+		if closed {
+			return nil
+		}
+
+		if heartbeat > 0 {
+			timer.Reset(heartbeat)
+		}
+	}
+}
+
+func newConnection() *connection {
+	return &connection{
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+}
+
+func (connection *connection) notify() {
+	select {
+	case connection.wake <- struct{}{}:
+	default:
+	}
+}
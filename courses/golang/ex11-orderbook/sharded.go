@@ -0,0 +1,235 @@
+package orderbook
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrClosed = errors.New("orderbook is closed")
+
+const shardBatch = 64
+
+type requestKind int8
+
+const (
+	requestMatch  requestKind = 1
+	requestCancel requestKind = 2
+)
+
+type request struct {
+	kind   requestKind
+	order  *Order
+	id     int
+	future *Future
+}
+
+// Future is result of order submitted to Sharded. It's completed by the
+// goroutine which owns the book.
+type Future struct {
+	done chan struct{}
+
+	trades    []*Trade
+	reject    *Order
+	cancelled bool
+	err       error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// Done returns channel which is closed when future is completed.
+func (future *Future) Done() <-chan struct{} {
+	return future.done
+}
+
+// Wait blocks until submitted order is matched and returns its trades and
+// rejected order like Orderbook.Match.
+func (future *Future) Wait() ([]*Trade, *Order, error) {
+	<-future.done
+
+	return future.trades, future.reject, future.err
+}
+
+// Cancelled blocks until submitted cancellation is applied and returns its
+// result like Orderbook.Cancel.
+func (future *Future) Cancelled() (bool, error) {
+	<-future.done
+
+	return future.cancelled, future.err
+}
+
+func (future *Future) fail(err error) *Future {
+	future.err = err
+
+	close(future.done)
+
+	return future
+}
+
+// shard owns single book. Requests are fed through lock-free ring and
+// applied by single writer goroutine in the order they were enqueued, so
+// price-time priority is the same as for sequential calls.
+type shard struct {
+	book *Orderbook
+	ring *ring
+
+	sleeping atomic.Bool
+	closed   atomic.Bool
+	wake     chan struct{}
+}
+
+func (shard *shard) submit(request request) *Future {
+	if shard.closed.Load() {
+		return request.future.fail(ErrClosed)
+	}
+
+	for !shard.ring.push(request) {
+		runtime.Gosched()
+	}
+
+	if shard.sleeping.Load() {
+		select {
+		case shard.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return request.future
+}
+
+func (shard *shard) run(done func()) {
+	defer done()
+
+	var batch [shardBatch]request
+
+	for {
+		count := 0
+
+		for count < len(batch) {
+			request, ok := shard.ring.pop()
+			if !ok {
+				break
+			}
+
+			batch[count] = request
+			count++
+		}
+
+		if count > 0 {
+			shard.apply(batch[:count])
+
+			continue
+		}
+
+		if shard.closed.Load() {
+			return
+		}
+
+		shard.sleeping.Store(true)
+
+		if shard.ring.empty() && !shard.closed.Load() {
+			<-shard.wake
+		}
+
+		shard.sleeping.Store(false)
+	}
+}
+
+// apply matches whole batch and completes futures afterwards.
+func (shard *shard) apply(batch []request) {
+	for i := range batch {
+		request := &batch[i]
+		future := request.future
+
+		switch request.kind {
+		case requestMatch:
+			future.trades, future.reject = shard.book.Match(request.order)
+		case requestCancel:
+			future.cancelled = shard.book.Cancel(request.id)
+		}
+	}
+
+	for i := range batch {
+		close(batch[i].future.done)
+
+		batch[i] = request{}
+	}
+}
+
+// Sharded is thread safe front end for books of many instruments. Each book
+// is owned by its own goroutine, so orders of different instruments are
+// matched in parallel, while orders of the same instrument are matched
+// strictly in submission order.
+type Sharded struct {
+	shards map[string]*shard
+	wait   sync.WaitGroup
+}
+
+// NewSharded starts goroutine per instrument with ring of specified capacity
+// in front of it. Call Close to stop them.
+func NewSharded(symbols []string, capacity int) *Sharded {
+	sharded := &Sharded{
+		shards: make(map[string]*shard, len(symbols)),
+	}
+
+	for _, symbol := range symbols {
+		shard := &shard{
+			book: New(),
+			ring: newRing(capacity),
+			wake: make(chan struct{}, 1),
+		}
+
+		sharded.shards[symbol] = shard
+		sharded.wait.Add(1)
+
+		go shard.run(sharded.wait.Done)
+	}
+
+	return sharded
+}
+
+// Match submits order to the book of instrument. Order must not be modified
+// until returned future is completed.
+func (sharded *Sharded) Match(symbol string, order *Order) *Future {
+	shard, ok := sharded.shards[symbol]
+	if !ok {
+		return newFuture().fail(ErrUnknownInstrument)
+	}
+
+	return shard.submit(request{
+		kind:   requestMatch,
+		order:  order,
+		future: newFuture(),
+	})
+}
+
+func (sharded *Sharded) Cancel(symbol string, id int) *Future {
+	shard, ok := sharded.shards[symbol]
+	if !ok {
+		return newFuture().fail(ErrUnknownInstrument)
+	}
+
+	return shard.submit(request{
+		kind:   requestCancel,
+		id:     id,
+		future: newFuture(),
+	})
+}
+
+// Close waits until already submitted orders are matched and stops
+// goroutines. Match and Cancel must not be called concurrently with Close.
+func (sharded *Sharded) Close() {
+	for _, shard := range sharded.shards {
+		shard.closed.Store(true)
+
+		select {
+		case shard.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	sharded.wait.Wait()
+}
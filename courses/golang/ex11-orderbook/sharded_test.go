@@ -0,0 +1,151 @@
+package orderbook
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharded_SameAsSequential(t *testing.T) {
+	test := assert.New(t)
+
+	symbols := []string{"BTCUSD", "ETHUSD", "XRPUSD"}
+
+	sharded := NewSharded(symbols, 16)
+	defer sharded.Close()
+
+	fabric := new(fabricator)
+
+	fabric.chances.buy = 0.5
+	fabric.chances.market = 0.2
+	fabric.volume.min = 1
+	fabric.volume.max = 5
+	fabric.price.min = 90
+	fabric.price.max = 110
+
+	wait := sync.WaitGroup{}
+
+	for generation, symbol := range symbols {
+		orders := make([]Order, 2000)
+
+		fabric.fabricate(orders, generation)
+
+		sequential := make([]Order, len(orders))
+		copy(sequential, orders)
+
+		wait.Add(1)
+
+		go func(symbol string) {
+			defer wait.Done()
+
+			futures := make([]*Future, len(orders))
+
+			for i := range orders {
+				futures[i] = sharded.Match(symbol, &orders[i])
+			}
+
+			book := New()
+
+			for i := range sequential {
+				expected, expectedReject := book.Match(&sequential[i])
+
+				trades, reject, err := futures[i].Wait()
+				test.NoError(err)
+
+				assertTrades(test, expected, trades)
+				test.Equal(expectedReject == nil, reject == nil)
+			}
+		}(symbol)
+	}
+
+	wait.Wait()
+}
+
+func TestSharded_ConcurrentProducers(t *testing.T) {
+	test := assert.New(t)
+
+	sharded := NewSharded([]string{"BTCUSD"}, 8)
+
+	const (
+		producers = 8
+		orders    = 500
+	)
+
+	wait := sync.WaitGroup{}
+
+	var (
+		mutex  sync.Mutex
+		traded uint64
+	)
+
+	for producer := 0; producer < producers; producer++ {
+		wait.Add(1)
+
+		go func(producer int) {
+			defer wait.Done()
+
+			side := SideBid
+			if producer%2 == 0 {
+				side = SideAsk
+			}
+
+			for i := 0; i < orders; i++ {
+				trades, _, err := sharded.Match("BTCUSD", &Order{
+					ID:     producer*orders + i,
+					Side:   side,
+					Kind:   KindLimit,
+					Volume: 10000,
+					Price:  60000000,
+				}).Wait()
+				test.NoError(err)
+
+				mutex.Lock()
+				for _, trade := range trades {
+					traded += trade.Volume
+				}
+				mutex.Unlock()
+			}
+		}(producer)
+	}
+
+	wait.Wait()
+
+	cancelled, err := sharded.Cancel("BTCUSD", -1).Cancelled()
+	test.NoError(err)
+	test.False(cancelled)
+
+	sharded.Close()
+
+	test.Equal(uint64(producers/2*orders*10000), traded)
+
+	_, _, err = sharded.Match("BTCUSD", &Order{}).Wait()
+	test.ErrorIs(err, ErrClosed)
+
+	_, _, err = sharded.Match("ETHUSD", &Order{}).Wait()
+	test.ErrorIs(err, ErrUnknownInstrument)
+}
+
+func TestRing_Wraparound(t *testing.T) {
+	test := assert.New(t)
+
+	ring := newRing(3)
+
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 4; i++ {
+			test.True(ring.push(request{id: i}), fmt.Sprint(round, i))
+		}
+
+		test.False(ring.push(request{id: 4}))
+
+		for i := 0; i < 4; i++ {
+			request, ok := ring.pop()
+
+			test.True(ok)
+			test.Equal(i, request.id)
+		}
+
+		test.True(ring.empty())
+	}
+}
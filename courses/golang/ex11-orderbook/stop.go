@@ -0,0 +1,54 @@
+package orderbook
+
+import "sort"
+
+// stops holds stop orders of one side which are waiting for trigger. Orders
+// are sorted in reverse trigger order, so the next one to trigger is always
+// the last.
+type stops struct {
+	side   Side
+	orders []*Order
+}
+
+// precedes reports whether stop order triggers before other one. Bid stops
+// trigger on rising price and ask stops on falling one, while stops with the
+// same trigger price keep arrival order.
+func (stops *stops) precedes(order, other *Order) bool {
+	if stops.side == SideBid {
+		return order.StopPrice < other.StopPrice
+	}
+
+	return order.StopPrice > other.StopPrice
+}
+
+func (stops *stops) next() *Order {
+	if len(stops.orders) == 0 {
+		return nil
+	}
+
+	return stops.orders[len(stops.orders)-1]
+}
+
+func (stops *stops) insert(order *Order) {
+	position := sort.Search(len(stops.orders), func(i int) bool {
+		return !stops.precedes(order, stops.orders[i])
+	})
+
+	stops.orders = append(stops.orders, nil)
+	copy(stops.orders[position+1:], stops.orders[position:])
+	stops.orders[position] = order
+}
+
+func (stops *stops) remove(order *Order) {
+	for position, stop := range stops.orders {
+		if stop != order {
+			continue
+		}
+
+		copy(stops.orders[position:], stops.orders[position+1:])
+		stops.orders[len(stops.orders)-1] = nil
+		stops.orders = stops.orders[:len(stops.orders)-1]
+
+		return
+	}
+}
@@ -0,0 +1,426 @@
+package orderbook
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownColumn = errors.New("unknown column")
+	ErrInvalidValue  = errors.New("invalid value")
+)
+
+// InputError is returned by InputReader for record which can't be decoded.
+// Line is 1-based line number in the stream, and Column is name of the
+// column or key which caused the error, if any.
+type InputError struct {
+	Line   int
+	Column string
+	Err    error
+}
+
+func (err *InputError) Error() string {
+	if err.Column == "" {
+		return fmt.Sprintf("line %d: %s", err.Line, err.Err)
+	}
+
+	return fmt.Sprintf("line %d: %s: %s", err.Line, err.Column, err.Err)
+}
+
+func (err *InputError) Unwrap() error {
+	return err.Err
+}
+
+type Action int8
+
+const (
+	ActionNew    Action = 1
+	ActionCancel Action = 2
+	ActionAmend  Action = 3
+)
+
+func (action Action) String() string {
+	switch action {
+	case ActionNew:
+		return "NEW"
+	case ActionCancel:
+		return "CANCEL"
+	case ActionAmend:
+		return "AMEND"
+	}
+
+	return "UNKNOWN"
+}
+
+type InputFormat int8
+
+const (
+	InputCSV       InputFormat = 1
+	InputJSONLines InputFormat = 2
+)
+
+// Input is single record of recorded order stream. New orders use every
+// field of Order, cancel uses only ID, and amend uses ID, Volume and Price.
+// Timestamp is arbitrary time of record in nanoseconds, which allows to
+// replay stream with its original pacing.
+type Input struct {
+	Timestamp int64
+	Action    Action
+	Order     Order
+}
+
+// Apply executes input in the book. Order is copied, so input can be
+// reused. For cancel and amend ok is false if there is no such order.
+func (input *Input) Apply(book *Orderbook) ([]*Trade, *Order, bool) {
+	switch input.Action {
+	case ActionCancel:
+		return nil, nil, book.Cancel(input.Order.ID)
+	case ActionAmend:
+		trades, ok := book.Amend(
+			input.Order.ID,
+			input.Order.Volume,
+			input.Order.Price,
+		)
+
+		return trades, nil, ok
+	}
+
+	order := new(Order)
+	*order = input.Order
+
+	trades, reject := book.Match(order)
+
+	return trades, reject, true
+}
+
+// InputReader decodes recorded order stream. CSV stream starts with header
+// naming its columns, and JSON lines stream has one object per line with the
+// same keys:
+//
+//	time, action, id, side, kind, tif, volume, price, stop_price,
+//	display_volume, account, self_trade
+//
+// Enumerations are written as their String values, e.g. BID or STOP_LIMIT,
+// case-insensitively. Missing or empty values are zero, except action, which
+// is NEW by default. Blank lines are skipped.
+type InputReader struct {
+	format InputFormat
+	lines  *bufio.Scanner
+	line   int
+
+	header []string
+}
+
+func NewInputReader(reader io.Reader, format InputFormat) *InputReader {
+	lines := bufio.NewScanner(reader)
+	lines.Buffer(nil, 1<<20)
+
+	return &InputReader{
+		format: format,
+		lines:  lines,
+	}
+}
+
+// Read returns the next record, or io.EOF at the end of stream.
+func (reader *InputReader) Read() (Input, error) {
+	for reader.lines.Scan() {
+		reader.line++
+
+		line := bytes.TrimSpace(reader.lines.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if reader.format == InputJSONLines {
+			return reader.decodeJSON(line)
+		}
+
+		if reader.header != nil {
+			return reader.decodeCSV(line)
+		}
+
+		if err := reader.decodeHeader(line); err != nil {
+			return Input{}, err
+		}
+	}
+
+	if err := reader.lines.Err(); err != nil {
+		return Input{}, err
+	}
+
+	return Input{}, io.EOF
+}
+
+func (reader *InputReader) decodeHeader(line []byte) error {
+	header, err := csv.NewReader(bytes.NewReader(line)).Read()
+	if err != nil {
+		return &InputError{Line: reader.line, Err: err}
+	}
+
+	for i, column := range header {
+		header[i] = strings.TrimSpace(column)
+
+		if err := (&Input{}).set(header[i], ""); err != nil {
+			return &InputError{Line: reader.line, Column: header[i], Err: err}
+		}
+	}
+
+	reader.header = header
+
+	return nil
+}
+
+func (reader *InputReader) decodeCSV(line []byte) (Input, error) {
+	record, err := csv.NewReader(bytes.NewReader(line)).Read()
+	if err != nil {
+		return Input{}, &InputError{Line: reader.line, Err: err}
+	}
+
+	if len(record) != len(reader.header) {
+		return Input{}, &InputError{Line: reader.line, Err: ErrInvalidValue}
+	}
+
+	input := Input{Action: ActionNew}
+
+	for i, value := range record {
+		if err := input.set(reader.header[i], value); err != nil {
+			return Input{}, &InputError{
+				Line:   reader.line,
+				Column: reader.header[i],
+				Err:    err,
+			}
+		}
+	}
+
+	return input, nil
+}
+
+func (reader *InputReader) decodeJSON(line []byte) (Input, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+
+	var object map[string]interface{}
+
+	if err := decoder.Decode(&object); err != nil {
+		return Input{}, &InputError{Line: reader.line, Err: err}
+	}
+
+	input := Input{Action: ActionNew}
+
+	for key, value := range object {
+		var text string
+
+		switch value := value.(type) {
+		case string:
+			text = value
+		case json.Number:
+			text = value.String()
+		case nil:
+		default:
+			return Input{}, &InputError{
+				Line:   reader.line,
+				Column: key,
+				Err:    ErrInvalidValue,
+			}
+		}
+
+		if err := input.set(key, text); err != nil {
+			return Input{}, &InputError{Line: reader.line, Column: key, Err: err}
+		}
+	}
+
+	return input, nil
+}
+
+// set decodes value of named column.
+func (input *Input) set(column string, value string) error {
+	value = strings.TrimSpace(value)
+
+	var (
+		order = &input.Order
+		ok    = true
+		err   error
+	)
+
+	switch column {
+	case "time":
+		input.Timestamp, err = parseInt(value)
+	case "action":
+		input.Action, ok = parseAction(value)
+	case "id":
+		var id int64
+
+		id, err = parseInt(value)
+		order.ID = int(id)
+	case "side":
+		order.Side, ok = parseSide(value)
+	case "kind":
+		order.Kind, ok = parseKind(value)
+	case "tif":
+		order.TimeInForce, ok = parseTimeInForce(value)
+	case "volume":
+		order.Volume, err = parseUint(value)
+	case "price":
+		order.Price, err = parseUint(value)
+	case "stop_price":
+		order.StopPrice, err = parseUint(value)
+	case "display_volume":
+		order.DisplayVolume, err = parseUint(value)
+	case "account":
+		order.Account, err = parseUint(value)
+	case "self_trade":
+		order.SelfTrade, ok = parseSelfTrade(value)
+	default:
+		return ErrUnknownColumn
+	}
+
+	if err != nil || !ok {
+		return ErrInvalidValue
+	}
+
+	return nil
+}
+
+func parseInt(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func parseUint(value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseUint(value, 10, 64)
+}
+
+func parseAction(value string) (Action, bool) {
+	if value == "" {
+		return ActionNew, true
+	}
+
+	for action := ActionNew; action <= ActionAmend; action++ {
+		if strings.EqualFold(action.String(), value) {
+			return action, true
+		}
+	}
+
+	return 0, false
+}
+
+func parseSide(value string) (Side, bool) {
+	if value == "" {
+		return 0, true
+	}
+
+	for side := SideBid; side <= SideAsk; side++ {
+		if strings.EqualFold(side.String(), value) {
+			return side, true
+		}
+	}
+
+	return 0, false
+}
+
+func parseKind(value string) (Kind, bool) {
+	if value == "" {
+		return 0, true
+	}
+
+	for kind := KindMarket; kind <= KindStopLimit; kind++ {
+		if strings.EqualFold(kind.String(), value) {
+			return kind, true
+		}
+	}
+
+	return 0, false
+}
+
+func parseTimeInForce(value string) (TimeInForce, bool) {
+	if value == "" {
+		return TimeInForceGTC, true
+	}
+
+	for tif := TimeInForceGTC; tif <= TimeInForcePostOnly; tif++ {
+		if strings.EqualFold(tif.String(), value) {
+			return tif, true
+		}
+	}
+
+	return 0, false
+}
+
+func parseSelfTrade(value string) (SelfTrade, bool) {
+	if value == "" {
+		return SelfTradeAllow, true
+	}
+
+	for mode := SelfTradeAllow; mode <= SelfTradeDecrement; mode++ {
+		if strings.EqualFold(mode.String(), value) {
+			return mode, true
+		}
+	}
+
+	return 0, false
+}
+
+// TradeWriter writes trades as CSV with header:
+//
+//	bid, ask, volume, price, bid_hidden, ask_hidden, self_trade
+//
+// where bid and ask are order IDs. Writes are buffered until Flush.
+type TradeWriter struct {
+	writer *csv.Writer
+	header bool
+}
+
+func NewTradeWriter(writer io.Writer) *TradeWriter {
+	return &TradeWriter{
+		writer: csv.NewWriter(writer),
+	}
+}
+
+func (writer *TradeWriter) Write(trade *Trade) error {
+	if !writer.header {
+		writer.header = true
+
+		err := writer.writer.Write([]string{
+			"bid",
+			"ask",
+			"volume",
+			"price",
+			"bid_hidden",
+			"ask_hidden",
+			"self_trade",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.writer.Write([]string{
+		strconv.Itoa(trade.Bid.ID),
+		strconv.Itoa(trade.Ask.ID),
+		strconv.FormatUint(trade.Volume, 10),
+		strconv.FormatUint(trade.Price, 10),
+		strconv.FormatUint(trade.BidHidden, 10),
+		strconv.FormatUint(trade.AskHidden, 10),
+		trade.SelfTrade.String(),
+	})
+}
+
+func (writer *TradeWriter) Flush() error {
+	writer.writer.Flush()
+
+	return writer.writer.Error()
+}
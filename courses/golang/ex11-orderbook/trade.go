@@ -5,4 +5,16 @@ type Trade struct {
 	Ask    *Order
 	Volume uint64
 	Price  uint64
+
+	// BidHidden and AskHidden are parts of Volume executed against hidden
+	// reserve of iceberg bid and ask, i.e. volume which was not displayed.
+	// Only resting order is displayed, so in continuous matching only one
+	// of them is set, while at uncross of auction both orders rest.
+	BidHidden uint64
+	AskHidden uint64
+
+	// SelfTrade is set if no exchange took place, because self-trade
+	// prevention of incoming order cancelled or decremented resting order.
+	// Volume is then volume removed from resting order.
+	SelfTrade SelfTrade
 }
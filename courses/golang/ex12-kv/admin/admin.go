@@ -0,0 +1,304 @@
+// Package admin implements HTTP API which changes membership of the
+// key-value storage cluster, and its client. Every node serves the API on
+// its address, reports its status there and redirects changes to the
+// leader, which applies them through raft configuration changes.
+//
+// Endpoints are:
+//
+//	GET  /status           status of the node and members of the cluster
+//	POST /join             add voter or non-voting replica {id, address, voter}
+//	POST /leave            remove node {id}
+//	POST /promote          make non-voting replica voter {id}
+//	POST /transfer-leader  transfer leadership to node {id} or any voter
+//
+// Changes respond with status of the leader after the change, and errors
+// respond with {error} body.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+var (
+	ErrInvalid     = errors.New("invalid request")
+	ErrNotFound    = errors.New("node is not member of the cluster")
+	ErrUnavailable = errors.New("cluster is unavailable")
+)
+
+// changeTimeout limits time of waiting for change to be started.
+const changeTimeout = 10 * time.Second
+
+// Member is node of the cluster. Suffrage is Voter, Nonvoter or Staging.
+type Member struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Suffrage string `json:"suffrage"`
+}
+
+type Status struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+
+	// State is Leader, Follower, Candidate or Shutdown.
+	State string `json:"state"`
+
+	// Leader is address of the leader, or empty if it's unknown.
+	Leader   string `json:"leader"`
+	LeaderID string `json:"leader_id"`
+
+	Term         uint64 `json:"term"`
+	CommitIndex  uint64 `json:"commit_index"`
+	AppliedIndex uint64 `json:"applied_index"`
+
+	Members []Member `json:"members"`
+}
+
+// Change is body of request changing membership.
+type Change struct {
+	ID      string `json:"id"`
+	Address string `json:"address,omitempty"`
+
+	// Voter reports whether joining node votes, otherwise it's non-voting
+	// replica, which receives log but doesn't count for majority.
+	Voter bool `json:"voter,omitempty"`
+}
+
+type failure struct {
+	Error string `json:"error"`
+}
+
+type handler struct {
+	raft    *raft.Raft
+	id      string
+	address string
+}
+
+// NewHandler returns handler of API of node with specified ID and address.
+func NewHandler(node *raft.Raft, id string, address string) http.Handler {
+	handler := &handler{raft: node, id: id, address: address}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /status", handler.status)
+	mux.HandleFunc("POST /join", handler.join)
+	mux.HandleFunc("POST /leave", handler.leave)
+	mux.HandleFunc("POST /promote", handler.promote)
+	mux.HandleFunc("POST /transfer-leader", handler.transferLeader)
+
+	return mux
+}
+
+func (handler *handler) status(writer http.ResponseWriter, request *http.Request) {
+	handler.report(writer)
+}
+
+func (handler *handler) join(writer http.ResponseWriter, request *http.Request) {
+	change, ok := handler.decode(writer, request)
+	if !ok {
+		return
+	}
+
+	if change.ID == "" || change.Address == "" {
+		fail(writer, ErrInvalid)
+
+		return
+	}
+
+	id := raft.ServerID(change.ID)
+	address := raft.ServerAddress(change.Address)
+
+	if change.Voter {
+		handler.apply(writer, handler.raft.AddVoter(id, address, 0, changeTimeout))
+	} else {
+		handler.apply(writer, handler.raft.AddNonvoter(id, address, 0, changeTimeout))
+	}
+}
+
+func (handler *handler) leave(writer http.ResponseWriter, request *http.Request) {
+	change, ok := handler.decode(writer, request)
+	if !ok {
+		return
+	}
+
+	if _, err := handler.member(change.ID); err != nil {
+		fail(writer, err)
+
+		return
+	}
+
+	handler.apply(writer, handler.raft.RemoveServer(raft.ServerID(change.ID), 0, changeTimeout))
+}
+
+func (handler *handler) promote(writer http.ResponseWriter, request *http.Request) {
+	change, ok := handler.decode(writer, request)
+	if !ok {
+		return
+	}
+
+	member, err := handler.member(change.ID)
+	if err != nil {
+		fail(writer, err)
+
+		return
+	}
+
+	handler.apply(writer, handler.raft.AddVoter(member.ID, member.Address, 0, changeTimeout))
+}
+
+func (handler *handler) transferLeader(writer http.ResponseWriter, request *http.Request) {
+	change, ok := handler.decode(writer, request)
+	if !ok {
+		return
+	}
+
+	if change.ID == "" {
+		handler.apply(writer, handler.raft.LeadershipTransfer())
+
+		return
+	}
+
+	member, err := handler.member(change.ID)
+	if err != nil {
+		fail(writer, err)
+
+		return
+	}
+
+	if member.Suffrage != raft.Voter {
+		fail(writer, ErrInvalid)
+
+		return
+	}
+
+	handler.apply(writer, handler.raft.LeadershipTransferToServer(member.ID, member.Address))
+}
+
+// decode reads change, which is executed only by the leader, so other
+// nodes redirect client to it.
+func (handler *handler) decode(writer http.ResponseWriter, request *http.Request) (Change, bool) {
+	var change Change
+
+	if err := json.NewDecoder(request.Body).Decode(&change); err != nil {
+		fail(writer, ErrInvalid)
+
+		return change, false
+	}
+
+	if handler.raft.State() == raft.Leader {
+		return change, true
+	}
+
+	leader, _ := handler.raft.LeaderWithID()
+	if leader == "" {
+		fail(writer, ErrUnavailable)
+
+		return change, false
+	}
+
+	http.Redirect(writer, request, "http://"+string(leader)+request.URL.Path, http.StatusTemporaryRedirect)
+
+	return change, false
+}
+
+// apply waits for change and replies with status after it.
+func (handler *handler) apply(writer http.ResponseWriter, future raft.Future) {
+	if err := future.Error(); err != nil {
+		fail(writer, err)
+
+		return
+	}
+
+	handler.report(writer)
+}
+
+func (handler *handler) report(writer http.ResponseWriter) {
+	status, err := handler.current()
+	if err != nil {
+		fail(writer, err)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(writer).Encode(status)
+}
+
+// member finds member of the cluster with specified ID.
+func (handler *handler) member(id string) (raft.Server, error) {
+	future := handler.raft.GetConfiguration()
+
+	if err := future.Error(); err != nil {
+		return raft.Server{}, err
+	}
+
+	for _, server := range future.Configuration().Servers {
+		if server.ID == raft.ServerID(id) {
+			return server, nil
+		}
+	}
+
+	return raft.Server{}, ErrNotFound
+}
+
+func (handler *handler) current() (Status, error) {
+	future := handler.raft.GetConfiguration()
+
+	if err := future.Error(); err != nil {
+		return Status{}, err
+	}
+
+	leader, leaderID := handler.raft.LeaderWithID()
+
+	status := Status{
+		ID:           handler.id,
+		Address:      handler.address,
+		State:        handler.raft.State().String(),
+		Leader:       string(leader),
+		LeaderID:     string(leaderID),
+		Term:         handler.raft.CurrentTerm(),
+		CommitIndex:  handler.raft.CommitIndex(),
+		AppliedIndex: handler.raft.AppliedIndex(),
+		Members:      []Member{},
+	}
+
+	for _, server := range future.Configuration().Servers {
+		status.Members = append(status.Members, Member{
+			ID:       string(server.ID),
+			Address:  string(server.Address),
+			Suffrage: server.Suffrage.String(),
+		})
+	}
+
+	return status, nil
+}
+
+// fail replies with error. Errors of raft which mean that change can be
+// retried later are reported as unavailable.
+func fail(writer http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+
+	switch err {
+	case ErrInvalid:
+		code = http.StatusBadRequest
+	case ErrNotFound:
+		code = http.StatusNotFound
+	case ErrUnavailable,
+		raft.ErrNotLeader,
+		raft.ErrLeadershipLost,
+		raft.ErrLeadershipTransferInProgress,
+		raft.ErrEnqueueTimeout,
+		raft.ErrRaftShutdown:
+		code = http.StatusServiceUnavailable
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+
+	json.NewEncoder(writer).Encode(failure{Error: err.Error()})
+}
@@ -0,0 +1,101 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Client calls API of single node, which redirects changes to the leader.
+type Client struct {
+	address string
+	http    *http.Client
+}
+
+// NewClient returns client of node listening on specified address.
+func NewClient(address string) *Client {
+	return &Client{address: address, http: &http.Client{}}
+}
+
+// Status returns status of the node.
+func (client *Client) Status(ctx context.Context) (Status, error) {
+	return client.call(ctx, http.MethodGet, "/status", nil)
+}
+
+// Join adds node as voter or non-voting replica. Node must be started
+// without initial configuration, so it waits to be added.
+func (client *Client) Join(ctx context.Context, id string, address string, voter bool) (Status, error) {
+	return client.call(ctx, http.MethodPost, "/join", &Change{ID: id, Address: address, Voter: voter})
+}
+
+// Leave removes node from the cluster. Removed leader steps down.
+func (client *Client) Leave(ctx context.Context, id string) (Status, error) {
+	return client.call(ctx, http.MethodPost, "/leave", &Change{ID: id})
+}
+
+// Promote makes non-voting replica voter.
+func (client *Client) Promote(ctx context.Context, id string) (Status, error) {
+	return client.call(ctx, http.MethodPost, "/promote", &Change{ID: id})
+}
+
+// TransferLeader transfers leadership to voter with specified ID, or to
+// the most up-to-date voter if ID is empty.
+func (client *Client) TransferLeader(ctx context.Context, id string) (Status, error) {
+	return client.call(ctx, http.MethodPost, "/transfer-leader", &Change{ID: id})
+}
+
+func (client *Client) call(ctx context.Context, method string, path string, change *Change) (Status, error) {
+	var status Status
+
+	body := &bytes.Reader{}
+
+	if change != nil {
+		data, err := json.Marshal(change)
+		if err != nil {
+			return status, err
+		}
+
+		body = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, "http://"+client.address+path, body)
+	if err != nil {
+		return status, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := client.http.Do(request)
+	if err != nil {
+		return status, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var failure failure
+
+		json.NewDecoder(response.Body).Decode(&failure)
+
+		return status, fmt.Errorf("%w: %s", statusError(response.StatusCode), failure.Error)
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&status)
+
+	return status, err
+}
+
+func statusError(code int) error {
+	switch code {
+	case http.StatusBadRequest:
+		return ErrInvalid
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusServiceUnavailable:
+		return ErrUnavailable
+	}
+
+	return fmt.Errorf("unexpected status %d", code)
+}
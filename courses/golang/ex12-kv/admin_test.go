@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/admin"
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/protocol"
+)
+
+func TestAdmin_Membership(t *testing.T) {
+	test := assert.New(t)
+
+	cluster := startCluster(t, test, 1)
+
+	if cluster.leader() < 0 {
+		return
+	}
+
+	ctx := context.Background()
+	first := admin.NewClient(cluster.nodes[0].config.Address)
+
+	// Cluster grows to five nodes, the last one joins as non-voting replica.
+	for i := 1; i < 5; i++ {
+		node := cluster.nodes[cluster.add(t, func(int, *Config) {})]
+
+		status, err := first.Join(ctx, node.config.ID, node.config.Address, i < 4)
+		if !test.NoError(err) {
+			return
+		}
+
+		test.Len(status.Members, i+1)
+	}
+
+	status, err := first.Status(ctx)
+	test.NoError(err)
+	test.Equal("Leader", status.State)
+	test.Equal(cluster.nodes[0].config.Address, status.Leader)
+	test.Equal(map[string]string{
+		"node1": "Voter",
+		"node2": "Voter",
+		"node3": "Voter",
+		"node4": "Voter",
+		"node5": "Nonvoter",
+	}, suffrages(status))
+
+	key := protocol.Key{1}
+
+	test.Equal(protocol.CodeOK, cluster.call(protocol.Request{Op: protocol.OpSetX, Key: key, Value: 10}).Code)
+
+	replica := cluster.nodes[4]
+
+	test.Eventually(func() bool {
+		return call(test, replica.config.Address, protocol.Request{Op: protocol.OpGetStale, Key: key}).Value == 10
+	}, 5*time.Second, 10*time.Millisecond, "replica answers stale reads")
+
+	// Changes sent to follower are redirected to the leader.
+	status, err = admin.NewClient(replica.config.Address).Promote(ctx, "node5")
+	test.NoError(err)
+	test.Equal("Voter", suffrages(status)["node5"])
+
+	_, err = admin.NewClient(replica.config.Address).TransferLeader(ctx, "node3")
+	test.NoError(err)
+
+	test.Eventually(func() bool {
+		status, err := first.Status(ctx)
+
+		return cluster.leader() == 2 && err == nil && status.Leader == cluster.nodes[2].config.Address
+	}, 5*time.Second, 10*time.Millisecond, "leadership is transferred")
+
+	_, err = first.Leave(ctx, "node9")
+	test.ErrorIs(err, admin.ErrNotFound)
+
+	// Cluster shrinks back to the first node, including removal of the
+	// leader, which steps down.
+	for _, i := range []int{4, 3, 2, 1} {
+		test.Eventually(func() bool {
+			_, err := first.Leave(ctx, cluster.nodes[i].config.ID)
+
+			return err == nil
+		}, 10*time.Second, 50*time.Millisecond, "node%d leaves", i+1)
+
+		cluster.stop(i)
+	}
+
+	test.Eventually(func() bool {
+		status, err := first.Status(ctx)
+
+		return err == nil && status.State == "Leader" && len(status.Members) == 1
+	}, 10*time.Second, 10*time.Millisecond, "the first node is the only member")
+
+	test.Equal(
+		protocol.Response{Code: protocol.CodeOK, Value: 11},
+		call(test, cluster.nodes[0].config.Address, protocol.Request{Op: protocol.OpIncX, Key: key, Value: 1}),
+	)
+}
+
+func suffrages(status admin.Status) map[string]string {
+	suffrages := map[string]string{}
+
+	for _, member := range status.Members {
+		suffrages[member.ID] = member.Suffrage
+	}
+
+	return suffrages
+}
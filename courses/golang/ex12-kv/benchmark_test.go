@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/protocol"
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/storage"
+)
+
+// benchmarkBatch is number of entries applied at once, as raft applies at
+// most MaxAppendEntries of them.
+const benchmarkBatch = 64
+
+// BenchmarkFSM measures throughput of writes applied by the FSM and flushed
+// to store once per batch, with fsync on and off. Operation is single
+// entry.
+func BenchmarkFSM(b *testing.B) {
+	for _, op := range []protocol.Op{protocol.OpSetX, protocol.OpIncX} {
+		for _, enabled := range []bool{true, false} {
+			name := op.String() + "/fsync"
+			if !enabled {
+				name = op.String() + "/nofsync"
+			}
+
+			b.Run(name, func(b *testing.B) {
+				benchmarkFSM(b, op, enabled)
+			})
+		}
+	}
+}
+
+func benchmarkFSM(b *testing.B, op protocol.Op, enabled bool) {
+	store, err := storage.Open(b.TempDir(), storage.WithSync(enabled))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	fsm, err := newFSM(store)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer fsm.close()
+
+	// SETX creates new key every time, while INCX changes keys set
+	// beforehand.
+	key := func(n int) protocol.Key {
+		var key protocol.Key
+
+		if op == protocol.OpIncX {
+			n %= 1024
+		}
+
+		binary.BigEndian.PutUint64(key[:], uint64(n))
+
+		return key
+	}
+
+	var index uint64
+
+	if op == protocol.OpIncX {
+		for n := 0; n < 1024; n++ {
+			index++
+
+			data, _ := protocol.Request{Op: protocol.OpSetX, Key: key(n)}.AppendBinary(nil)
+
+			fsm.Apply(&raft.Log{Index: index, Type: raft.LogCommand, Data: data})
+		}
+	}
+
+	logs := make([]*raft.Log, benchmarkBatch)
+
+	for i := range logs {
+		logs[i] = &raft.Log{Type: raft.LogCommand}
+	}
+
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n += len(logs) {
+		batch := logs[:min(len(logs), b.N-n)]
+
+		for i, log := range batch {
+			index++
+
+			log.Index = index
+			log.Data, _ = protocol.Request{Op: op, Key: key(n + i), Value: 1}.AppendBinary(log.Data[:0])
+		}
+
+		for _, response := range fsm.ApplyBatch(batch) {
+			if code := response.(protocol.Response).Code; code != protocol.CodeOK {
+				b.Fatalf("unexpected code %s", code)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Command kvctl changes membership of the key-value storage cluster through
+// HTTP API of any of its nodes, which redirects changes to the leader.
+//
+// Usage:
+//
+//	kvctl [-node address] [-timeout duration] command [arguments]
+//
+// Commands are:
+//
+//	status                       status of the node and members of the cluster
+//	join [-nonvoter] id address  add node started without -peers
+//	leave id                     remove node
+//	promote id                   make non-voting replica voter
+//	transfer-leader [id]         transfer leadership to node or to any voter
+//
+// Every change prints status of the leader after it.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/admin"
+)
+
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	var (
+		node    string
+		timeout time.Duration
+	)
+
+	flag.StringVar(&node, "node", "127.0.0.1:7001", "address of any node of the cluster")
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "time limit of command")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] status|join [-nonvoter] id address|leave id|promote id|transfer-leader [id]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := run(ctx, admin.NewClient(node), flag.Args(), os.Stdout)
+	if err == errUsage {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kvctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, client *admin.Client, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	var (
+		status admin.Status
+		err    error
+	)
+
+	command, args := args[0], args[1:]
+
+	switch {
+	case command == "status" && len(args) == 0:
+		status, err = client.Status(ctx)
+	case command == "join":
+		flags := flag.NewFlagSet("join", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+
+		nonvoter := flags.Bool("nonvoter", false, "add non-voting replica")
+
+		if flags.Parse(args) != nil || flags.NArg() != 2 {
+			return errUsage
+		}
+
+		status, err = client.Join(ctx, flags.Arg(0), flags.Arg(1), !*nonvoter)
+	case command == "leave" && len(args) == 1:
+		status, err = client.Leave(ctx, args[0])
+	case command == "promote" && len(args) == 1:
+		status, err = client.Promote(ctx, args[0])
+	case command == "transfer-leader" && len(args) <= 1:
+		id := ""
+		if len(args) == 1 {
+			id = args[0]
+		}
+
+		status, err = client.TransferLeader(ctx, id)
+	default:
+		return errUsage
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return report(stdout, status)
+}
+
+func report(stdout io.Writer, status admin.Status) error {
+	writer := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(writer, "node\t%s\t%s\t%s\n", status.ID, status.Address, status.State)
+
+	if status.Leader == "" {
+		fmt.Fprintf(writer, "leader\tunknown\n")
+	} else {
+		fmt.Fprintf(writer, "leader\t%s\t%s\n", status.LeaderID, status.Leader)
+	}
+
+	fmt.Fprintf(writer, "term\t%d\n", status.Term)
+	fmt.Fprintf(writer, "commit\t%d\n", status.CommitIndex)
+	fmt.Fprintf(writer, "applied\t%d\n", status.AppliedIndex)
+
+	for _, member := range status.Members {
+		fmt.Fprintf(writer, "member\t%s\t%s\t%s\n", member.ID, member.Address, member.Suffrage)
+	}
+
+	return writer.Flush()
+}
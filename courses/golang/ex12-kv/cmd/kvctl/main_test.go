@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/admin"
+)
+
+func TestRun(t *testing.T) {
+	test := assert.New(t)
+
+	var requests []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var change admin.Change
+
+		json.NewDecoder(request.Body).Decode(&change)
+
+		if change.ID == "node9" {
+			writer.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(writer).Encode(map[string]string{"error": admin.ErrNotFound.Error()})
+
+			return
+		}
+
+		data, _ := json.Marshal(change)
+		requests = append(requests, request.Method+" "+request.URL.Path+" "+string(data))
+
+		json.NewEncoder(writer).Encode(admin.Status{
+			ID:       "node1",
+			Address:  "127.0.0.1:7001",
+			State:    "Leader",
+			Leader:   "127.0.0.1:7001",
+			LeaderID: "node1",
+			Term:     2,
+			Members: []admin.Member{
+				{ID: "node1", Address: "127.0.0.1:7001", Suffrage: "Voter"},
+				{ID: "node2", Address: "127.0.0.1:7002", Suffrage: "Nonvoter"},
+			},
+		})
+	}))
+	defer server.Close()
+
+	client := admin.NewClient(strings.TrimPrefix(server.URL, "http://"))
+	ctx := context.Background()
+
+	stdout := &bytes.Buffer{}
+
+	test.NoError(run(ctx, client, []string{"status"}, stdout))
+	test.Equal(
+		"node     node1  127.0.0.1:7001  Leader\n"+
+			"leader   node1  127.0.0.1:7001\n"+
+			"term     2\n"+
+			"commit   0\n"+
+			"applied  0\n"+
+			"member   node1  127.0.0.1:7001  Voter\n"+
+			"member   node2  127.0.0.1:7002  Nonvoter\n",
+		stdout.String(),
+	)
+
+	for _, args := range [][]string{
+		{"join", "-nonvoter", "node2", "127.0.0.1:7002"},
+		{"join", "node3", "127.0.0.1:7003"},
+		{"promote", "node2"},
+		{"transfer-leader"},
+		{"leave", "node3"},
+	} {
+		test.NoError(run(ctx, client, args, &bytes.Buffer{}), args[0])
+	}
+
+	test.Equal([]string{
+		`GET /status {"id":""}`,
+		`POST /join {"id":"node2","address":"127.0.0.1:7002"}`,
+		`POST /join {"id":"node3","address":"127.0.0.1:7003","voter":true}`,
+		`POST /promote {"id":"node2"}`,
+		`POST /transfer-leader {"id":""}`,
+		`POST /leave {"id":"node3"}`,
+	}, requests)
+
+	test.ErrorIs(run(ctx, client, []string{"leave", "node9"}, stdout), admin.ErrNotFound)
+
+	for _, args := range [][]string{nil, {"leave"}, {"join", "node4"}, {"status", "node1"}, {"restart"}} {
+		test.Equal(errUsage, run(ctx, client, args, stdout), args)
+	}
+}
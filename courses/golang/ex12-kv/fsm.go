@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/protocol"
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/storage"
+)
+
+const (
+	// snapshotHeaderSize is size of snapshot header: index of the last
+	// applied entry and number of keys.
+	snapshotHeaderSize = 8 + 8
+
+	// snapshotRecordSize is size of snapshot record: key and value.
+	snapshotRecordSize = protocol.KeySize + 8
+)
+
+var (
+	errSnapshotInProgress = errors.New("snapshot is in progress")
+	errMalformedSnapshot  = errors.New("malformed snapshot")
+	errChecksum           = errors.New("snapshot checksum mismatch")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// fsm is replicated state of balances. Every log entry is request packet of
+// write operation, and its response is returned to the leader which applied
+// it. Applied entries are flushed to store before returning, and entries
+// which are already in store are skipped when raft replays log on restart.
+//
+// Snapshot doesn't copy state: values are frozen until snapshot is written
+// and released, and writes applied meanwhile go to new map overlaying them,
+// which is merged back on release.
+type fsm struct {
+	mutex  sync.RWMutex
+	values map[protocol.Key]float64
+
+	// snapshot is being written, if any.
+	snapshot *snapshot
+
+	store storage.Store
+
+	// applied is index of the last entry which changed state.
+	applied uint64
+}
+
+func newFSM(store storage.Store) (*fsm, error) {
+	values, applied, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fsm{
+		values:  values,
+		store:   store,
+		applied: applied,
+	}, nil
+}
+
+func (fsm *fsm) get(key protocol.Key) (float64, bool) {
+	fsm.mutex.RLock()
+	defer fsm.mutex.RUnlock()
+
+	return fsm.lookup(key)
+}
+
+func (fsm *fsm) lookup(key protocol.Key) (float64, bool) {
+	if value, ok := fsm.values[key]; ok || fsm.snapshot == nil {
+		return value, ok
+	}
+
+	value, ok := fsm.snapshot.values[key]
+
+	return value, ok
+}
+
+// thaw copies frozen value of key to the overlay before it's changed.
+func (fsm *fsm) thaw(key protocol.Key) {
+	if fsm.snapshot == nil {
+		return
+	}
+
+	if _, ok := fsm.values[key]; ok {
+		return
+	}
+
+	if value, ok := fsm.snapshot.values[key]; ok {
+		fsm.values[key] = value
+	}
+}
+
+func (fsm *fsm) Apply(log *raft.Log) interface{} {
+	return fsm.ApplyBatch([]*raft.Log{log})[0]
+}
+
+// ApplyBatch applies entries and flushes their changes to store once per
+// batch. State can't diverge from the log, so node crashes if it fails to
+// write or flush.
+func (fsm *fsm) ApplyBatch(logs []*raft.Log) []interface{} {
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+
+	responses := make([]interface{}, len(logs))
+
+	for i, log := range logs {
+		if log.Type != raft.LogCommand || log.Index <= fsm.applied {
+			continue
+		}
+
+		var request protocol.Request
+
+		if request.UnmarshalBinary(log.Data) != nil {
+			responses[i] = protocol.Response{Code: protocol.CodeInvalid}
+
+			continue
+		}
+
+		if !request.Op.Write() {
+			responses[i] = protocol.Response{Code: protocol.CodeInvalid}
+
+			continue
+		}
+
+		fsm.thaw(request.Key)
+
+		if request.Op == protocol.OpTransfer {
+			fsm.thaw(request.To)
+		}
+
+		response := protocol.Apply(fsm.values, request)
+
+		if response.Code == protocol.CodeOK {
+			changes := []storage.Change{{Key: request.Key, Value: response.Value}}
+
+			if request.Op == protocol.OpTransfer {
+				changes = append(changes, storage.Change{Key: request.To, Value: response.To})
+			}
+
+			if err := fsm.store.Write(log.Index, changes...); err != nil {
+				panic(fmt.Sprintf("can't write state: %s", err))
+			}
+
+			fsm.applied = log.Index
+		}
+
+		responses[i] = response
+	}
+
+	if err := fsm.store.Sync(); err != nil {
+		panic(fmt.Sprintf("can't flush state: %s", err))
+	}
+
+	return responses
+}
+
+// Snapshot freezes current values. Raft writes single snapshot at a time,
+// so another one isn't taken until it's released.
+func (fsm *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+
+	if fsm.snapshot != nil {
+		return nil, errSnapshotInProgress
+	}
+
+	fsm.snapshot = &snapshot{
+		fsm:     fsm,
+		applied: fsm.applied,
+		values:  fsm.values,
+	}
+
+	fsm.values = map[protocol.Key]float64{}
+
+	return fsm.snapshot, nil
+}
+
+// Restore replaces state with snapshot received from the leader. Snapshot
+// is read as stream and its checksum is verified before state is replaced.
+func (fsm *fsm) Restore(reader io.ReadCloser) error {
+	defer reader.Close()
+
+	buffered := bufio.NewReader(reader)
+	checksum := crc32.New(castagnoli)
+	summed := io.TeeReader(buffered, checksum)
+
+	var header [snapshotHeaderSize]byte
+
+	if _, err := io.ReadFull(summed, header[:]); err != nil {
+		return err
+	}
+
+	applied := binary.BigEndian.Uint64(header[:8])
+	count := binary.BigEndian.Uint64(header[8:])
+
+	values := map[protocol.Key]float64{}
+
+	var (
+		record   [snapshotRecordSize]byte
+		previous protocol.Key
+	)
+
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(summed, record[:]); err != nil {
+			return err
+		}
+
+		var key protocol.Key
+
+		copy(key[:], record[:])
+
+		// Keys are strictly ascending, which also rules out duplicates.
+		if i > 0 && bytes.Compare(previous[:], key[:]) >= 0 {
+			return errMalformedSnapshot
+		}
+
+		values[key] = math.Float64frombits(binary.BigEndian.Uint64(record[protocol.KeySize:]))
+		previous = key
+	}
+
+	var sum [crc32.Size]byte
+
+	if _, err := io.ReadFull(buffered, sum[:]); err != nil {
+		return err
+	}
+
+	if binary.BigEndian.Uint32(sum[:]) != checksum.Sum32() {
+		return errChecksum
+	}
+
+	if _, err := buffered.ReadByte(); err != io.EOF {
+		return errMalformedSnapshot
+	}
+
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+
+	if err := fsm.store.Replace(applied, values); err != nil {
+		return err
+	}
+
+	// Snapshot being written keeps its values, but they're not merged back.
+	fsm.snapshot = nil
+	fsm.values = values
+	fsm.applied = applied
+
+	return nil
+}
+
+// release merges writes applied while snapshot was written into its values.
+func (fsm *fsm) release(snapshot *snapshot) {
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+
+	if fsm.snapshot != snapshot {
+		return
+	}
+
+	for key, value := range fsm.values {
+		snapshot.values[key] = value
+	}
+
+	fsm.values = snapshot.values
+	fsm.snapshot = nil
+}
+
+func (fsm *fsm) close() error {
+	return fsm.store.Close()
+}
+
+// snapshot is written as index of the last applied entry and number of
+// keys, followed by key and value of every key in ascending order of keys
+// and CRC-32C of everything before it. Values are frozen, so it's streamed
+// to sink without copying them.
+type snapshot struct {
+	fsm     *fsm
+	applied uint64
+	values  map[protocol.Key]float64
+}
+
+func (snapshot *snapshot) Persist(sink raft.SnapshotSink) error {
+	keys := make([]protocol.Key, 0, len(snapshot.values))
+
+	for key := range snapshot.values {
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a protocol.Key, b protocol.Key) int {
+		return bytes.Compare(a[:], b[:])
+	})
+
+	checksum := crc32.New(castagnoli)
+	writer := bufio.NewWriter(io.MultiWriter(sink, checksum))
+
+	var buffer [snapshotHeaderSize + snapshotRecordSize]byte
+
+	binary.BigEndian.PutUint64(buffer[:8], snapshot.applied)
+	binary.BigEndian.PutUint64(buffer[8:], uint64(len(keys)))
+
+	_, err := writer.Write(buffer[:snapshotHeaderSize])
+
+	for _, key := range keys {
+		if err != nil {
+			break
+		}
+
+		copy(buffer[:], key[:])
+		binary.BigEndian.PutUint64(buffer[protocol.KeySize:], math.Float64bits(snapshot.values[key]))
+
+		_, err = writer.Write(buffer[:snapshotRecordSize])
+	}
+
+	if err == nil {
+		err = writer.Flush()
+	}
+
+	if err == nil {
+		_, err = sink.Write(binary.BigEndian.AppendUint32(nil, checksum.Sum32()))
+	}
+
+	if err != nil {
+		sink.Cancel()
+
+		return err
+	}
+
+	return sink.Close()
+}
+
+func (snapshot *snapshot) Release() {
+	snapshot.fsm.release(snapshot)
+}
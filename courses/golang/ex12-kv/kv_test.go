@@ -1,13 +1,837 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/raft"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/kvclient"
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/protocol"
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/storage"
 )
 
 func TestKeyValue(t *testing.T) {
 	test := assert.New(t)
 
-	// TODO
+	cluster := startCluster(t, test, 3)
+
+	leader := cluster.leader()
+	if leader < 0 {
+		return
+	}
+
+	address := cluster.nodes[leader].config.Address
+
+	key := protocol.Key{1}
+	missing := protocol.Key{2}
+
+	cases := []struct {
+		Request  protocol.Request
+		Response protocol.Response
+	}{
+		{
+			protocol.Request{Op: protocol.OpGet, Key: key},
+			protocol.Response{Code: protocol.CodeNotFound},
+		},
+		{
+			protocol.Request{Op: protocol.OpSetX, Key: key, Value: 100},
+			protocol.Response{Code: protocol.CodeOK, Value: 100},
+		},
+		{
+			protocol.Request{Op: protocol.OpSetX, Key: key, Value: 50},
+			protocol.Response{Code: protocol.CodeExists, Value: 100},
+		},
+		{
+			protocol.Request{Op: protocol.OpIncX, Key: key, Value: 25.5},
+			protocol.Response{Code: protocol.CodeOK, Value: 125.5},
+		},
+		{
+			protocol.Request{Op: protocol.OpDecX, Key: key, Value: 0.5},
+			protocol.Response{Code: protocol.CodeOK, Value: 125},
+		},
+		{
+			protocol.Request{Op: protocol.OpIncX, Key: missing, Value: 1},
+			protocol.Response{Code: protocol.CodeNotFound},
+		},
+		{
+			protocol.Request{Op: protocol.OpDecX, Key: missing, Value: 1},
+			protocol.Response{Code: protocol.CodeNotFound},
+		},
+		{
+			protocol.Request{Op: protocol.OpGet, Key: key},
+			protocol.Response{Code: protocol.CodeOK, Value: 125},
+		},
+		{
+			protocol.Request{Op: 9, Key: key},
+			protocol.Response{Code: protocol.CodeInvalid},
+		},
+	}
+
+	for _, testcase := range cases {
+		test.Equal(testcase.Response, call(test, address, testcase.Request), testcase.Request.Op.String())
+	}
+
+	// Pipelined requests are answered in order.
+	conn, err := net.Dial("tcp", address)
+	if !test.NoError(err) {
+		return
+	}
+
+	defer conn.Close()
+
+	writer := bufio.NewWriter(conn)
+
+	for i := 0; i < 10; i++ {
+		protocol.WriteRequest(writer, protocol.Request{Op: protocol.OpIncX, Key: key, Value: 1})
+	}
+
+	test.NoError(writer.Flush())
+
+	for i := 1; i <= 10; i++ {
+		response, err := protocol.ReadResponse(conn)
+		test.NoError(err)
+		test.Equal(protocol.Response{Code: protocol.CodeOK, Value: 125 + float64(i)}, response)
+	}
+
+	for i, node := range cluster.nodes {
+		if i == leader {
+			continue
+		}
+
+		for _, op := range []protocol.Op{protocol.OpGet, protocol.OpIncX} {
+			test.Equal(
+				protocol.Response{Code: protocol.CodeNotLeader, Leader: address},
+				call(test, node.config.Address, protocol.Request{Op: op, Key: key, Value: 1}),
+				"follower redirects to the leader",
+			)
+		}
+
+		test.Eventually(func() bool {
+			value, _ := node.server.fsm.get(key)
+
+			return value == 135
+		}, 5*time.Second, 10*time.Millisecond)
+
+		// Follower flushes applied entries to disk.
+		cluster.stop(i)
+
+		test.Equal(map[protocol.Key]float64{key: 135}, stored(test, node.config.Dir))
+	}
+}
+
+func TestKeyValue_Restart(t *testing.T) {
+	test := assert.New(t)
+
+	cluster := startCluster(t, test, 3)
+
+	key := protocol.Key{1}
+
+	test.Equal(protocol.CodeOK, cluster.call(protocol.Request{Op: protocol.OpSetX, Key: key, Value: 10}).Code)
+
+	// Follower which was down catches up after restart.
+	follower := (cluster.leader() + 1) % len(cluster.nodes)
+	cluster.stop(follower)
+
+	for i := 0; i < 3; i++ {
+		test.Equal(protocol.CodeOK, cluster.call(protocol.Request{Op: protocol.OpIncX, Key: key, Value: 5}).Code)
+	}
+
+	cluster.restart(follower)
+
+	test.Eventually(func() bool {
+		value, _ := cluster.nodes[follower].server.fsm.get(key)
+
+		return value == 25
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Entries replayed from the log after restart of the whole cluster are
+	// not applied twice.
+	for i := range cluster.nodes {
+		cluster.stop(i)
+	}
+
+	for i := range cluster.nodes {
+		cluster.restart(i)
+	}
+
+	test.Equal(
+		protocol.Response{Code: protocol.CodeOK, Value: 25},
+		cluster.call(protocol.Request{Op: protocol.OpGet, Key: key}),
+	)
+
+	test.Equal(
+		protocol.Response{Code: protocol.CodeOK, Value: 26},
+		cluster.call(protocol.Request{Op: protocol.OpIncX, Key: key, Value: 1}),
+	)
+}
+
+func TestKeyValue_Client(t *testing.T) {
+	test := assert.New(t)
+
+	cluster := startCluster(t, test, 3)
+
+	var addresses []string
+
+	for _, node := range cluster.nodes {
+		addresses = append(addresses, node.config.Address)
+	}
+
+	client, err := kvclient.New(addresses)
+	if !test.NoError(err) {
+		return
+	}
+
+	defer client.Close()
+
+	ctx := context.Background()
+	key := protocol.Key{1}
+
+	_, err = client.SetX(ctx, key, 10)
+	test.NoError(err)
+
+	_, err = client.SetX(ctx, key, 10)
+	test.ErrorIs(err, kvclient.ErrExists)
+
+	leader := cluster.leader()
+	if leader < 0 {
+		return
+	}
+
+	test.Equal(cluster.nodes[leader].config.Address, client.Leader())
+
+	// Client finds new leader after the old one is stopped.
+	cluster.stop(leader)
+
+	value, err := client.Get(ctx, key)
+	test.NoError(err)
+	test.Equal(10.0, value)
+
+	value, err = client.IncX(ctx, key, 5)
+	test.NoError(err)
+	test.Equal(15.0, value)
+
+	test.NotEqual(cluster.nodes[leader].config.Address, client.Leader())
+}
+
+func TestKeyValue_Transfer(t *testing.T) {
+	test := assert.New(t)
+
+	cluster := startCluster(t, test, 3)
+
+	from := protocol.Key{1}
+	to := protocol.Key{2}
+
+	test.Equal(protocol.CodeOK, cluster.call(protocol.Request{Op: protocol.OpSetX, Key: from, Value: 100}).Code)
+	test.Equal(protocol.CodeOK, cluster.call(protocol.Request{Op: protocol.OpSetX, Key: to, Value: 5}).Code)
+
+	test.Equal(
+		protocol.Response{Code: protocol.CodeOK, Value: 60, Extended: true, To: 45},
+		cluster.call(protocol.Request{Op: protocol.OpTransfer, Key: from, Value: 40, To: to}),
+	)
+
+	test.Equal(
+		protocol.Response{Code: protocol.CodeInsufficientFunds, Value: 60},
+		cluster.call(protocol.Request{Op: protocol.OpTransfer, Key: from, Value: 61, To: to}),
+	)
+
+	test.Equal(
+		protocol.Response{Code: protocol.CodeNotFound},
+		cluster.call(protocol.Request{Op: protocol.OpTransfer, Key: from, Value: 1, To: protocol.Key{3}}),
+	)
+
+	follower := (cluster.leader() + 1) % len(cluster.nodes)
+	node := cluster.nodes[follower]
+
+	test.Eventually(func() bool {
+		value, _ := node.server.fsm.get(to)
+
+		return value == 45
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cluster.stop(follower)
+
+	// Transfer torn by crash is dropped entirely.
+	segments, _ := filepath.Glob(filepath.Join(node.config.Dir, "state", "*.seg"))
+	if !test.NotEmpty(segments) {
+		return
+	}
+
+	path := segments[len(segments)-1]
+
+	info, err := os.Stat(path)
+	if !test.NoError(err) {
+		return
+	}
+
+	test.NoError(os.Truncate(path, info.Size()-storage.RecordSize))
+	test.Equal(map[protocol.Key]float64{from: 100, to: 5}, stored(test, node.config.Dir))
+
+	// Follower applies the transfer again from the log.
+	cluster.restart(follower)
+
+	test.Eventually(func() bool {
+		fromValue, _ := node.server.fsm.get(from)
+		toValue, _ := node.server.fsm.get(to)
+
+		return fromValue == 60 && toValue == 45
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestKeyValue_Snapshot(t *testing.T) {
+	test := assert.New(t)
+
+	tune := func(i int, config *Config) {
+		config.SnapshotThreshold = 20
+		config.SnapshotInterval = 20 * time.Millisecond
+		config.TrailingLogs = 5
+	}
+
+	cluster := startTunedCluster(t, test, 3, tune)
+
+	for i := 0; i < 50; i++ {
+		test.Equal(protocol.CodeOK, cluster.call(protocol.Request{Op: protocol.OpSetX, Key: protocol.Key{byte(i)}, Value: float64(i)}).Code)
+	}
+
+	leader := cluster.leader()
+	if leader < 0 {
+		return
+	}
+
+	test.Eventually(func() bool {
+		first, err := cluster.nodes[leader].server.logs.FirstIndex()
+
+		return err == nil && first > 1
+	}, 5*time.Second, 10*time.Millisecond, "log is compacted after snapshot")
+
+	// Tail of the log follows snapshot.
+	for i := 0; i < 3; i++ {
+		test.Equal(protocol.CodeOK, cluster.call(protocol.Request{Op: protocol.OpIncX, Key: protocol.Key{0}, Value: 1}).Code)
+	}
+
+	joined := cluster.nodes[cluster.add(t, tune)]
+	if joined.server == nil {
+		return
+	}
+
+	test.NoError(cluster.nodes[leader].server.raft.AddVoter(
+		raft.ServerID(joined.config.ID),
+		raft.ServerAddress(joined.config.Address),
+		0,
+		0,
+	).Error())
+
+	test.Eventually(func() bool {
+		for i := 1; i < 50; i++ {
+			if value, _ := joined.server.fsm.get(protocol.Key{byte(i)}); value != float64(i) {
+				return false
+			}
+		}
+
+		value, _ := joined.server.fsm.get(protocol.Key{0})
+
+		return value == 3
+	}, 5*time.Second, 10*time.Millisecond, "new node catches up")
+
+	test.NotEqual("0", joined.server.raft.Stats()["last_snapshot_index"], "new node installs snapshot")
+
+	// Restored state is flushed to disk.
+	cluster.stop(3)
+
+	values := stored(test, joined.config.Dir)
+	test.Len(values, 50)
+	test.Equal(3.0, values[protocol.Key{0}])
+}
+
+func TestFSM_Snapshot(t *testing.T) {
+	test := assert.New(t)
+
+	source, err := openFSM(t.TempDir())
+	if !test.NoError(err) {
+		return
+	}
+
+	defer source.close()
+
+	apply := func(index uint64, request protocol.Request) {
+		data, _ := request.AppendBinary(nil)
+
+		source.Apply(&raft.Log{Index: index, Type: raft.LogCommand, Data: data})
+	}
+
+	apply(1, protocol.Request{Op: protocol.OpSetX, Key: protocol.Key{2}, Value: 2})
+	apply(2, protocol.Request{Op: protocol.OpSetX, Key: protocol.Key{1}, Value: 1})
+
+	snapshot, err := source.Snapshot()
+	if !test.NoError(err) {
+		return
+	}
+
+	_, err = source.Snapshot()
+	test.ErrorIs(err, errSnapshotInProgress)
+
+	// Writes applied while snapshot is written don't change it.
+	apply(3, protocol.Request{Op: protocol.OpIncX, Key: protocol.Key{2}, Value: 10})
+	apply(4, protocol.Request{Op: protocol.OpSetX, Key: protocol.Key{3}, Value: 3})
+
+	value, _ := source.get(protocol.Key{1})
+	test.Equal(1.0, value)
+
+	sink := &memorySink{}
+
+	test.NoError(snapshot.Persist(sink))
+	snapshot.Release()
+
+	test.Equal(map[protocol.Key]float64{{1}: 1, {2}: 12, {3}: 3}, source.values)
+
+	data := sink.Bytes()
+	test.Len(data, snapshotHeaderSize+2*snapshotRecordSize+4)
+	test.Equal(protocol.Key{1}, protocol.Key(data[snapshotHeaderSize:]), "keys are sorted")
+
+	target, err := openFSM(t.TempDir())
+	if !test.NoError(err) {
+		return
+	}
+
+	defer target.close()
+
+	test.NoError(target.Restore(io.NopCloser(bytes.NewReader(data))))
+	test.Equal(map[protocol.Key]float64{{1}: 1, {2}: 2}, target.values)
+	test.Equal(uint64(2), target.applied)
+
+	corrupted := append([]byte{}, data...)
+	corrupted[snapshotHeaderSize+protocol.KeySize] ^= 1
+
+	test.ErrorIs(target.Restore(io.NopCloser(bytes.NewReader(corrupted))), errChecksum)
+	test.ErrorIs(target.Restore(io.NopCloser(bytes.NewReader(append(data, 0)))), errMalformedSnapshot)
+	test.Error(target.Restore(io.NopCloser(bytes.NewReader(data[:len(data)-1]))))
+
+	test.Equal(map[protocol.Key]float64{{1}: 1, {2}: 2}, target.values, "state is kept after failed restore")
+}
+
+func TestKeyValue_Partition(t *testing.T) {
+	test := assert.New(t)
+
+	network := newPartition()
+
+	// The first node keeps leadership for long after it loses contact with
+	// other nodes, which elect new leader meanwhile. Its heartbeats are
+	// still frequent enough for them while it's connected.
+	cluster := startTunedCluster(t, test, 3, func(i int, config *Config) {
+		config.Dial = network.dialer(config.Address)
+
+		if i == 0 {
+			config.Raft.HeartbeatTimeout = 5 * time.Second
+			config.Raft.ElectionTimeout = 5 * time.Second
+			config.Raft.LeaderLeaseTimeout = 5 * time.Second
+			config.ApplyTimeout = 500 * time.Millisecond
+
+			return
+		}
+
+		config.Raft.HeartbeatTimeout = 1200 * time.Millisecond
+		config.Raft.ElectionTimeout = 1200 * time.Millisecond
+		config.Raft.LeaderLeaseTimeout = 500 * time.Millisecond
+	})
+
+	leader := cluster.leader()
+	if leader < 0 {
+		return
+	}
+
+	old := cluster.nodes[0]
+
+	if leader != 0 {
+		err := cluster.nodes[leader].server.raft.LeadershipTransferToServer(
+			raft.ServerID(old.config.ID),
+			raft.ServerAddress(old.config.Address),
+		).Error()
+		if !test.NoError(err) {
+			return
+		}
+	}
+
+	key := protocol.Key{1}
+
+	test.Eventually(func() bool {
+		return call(test, old.config.Address, protocol.Request{Op: protocol.OpSetX, Key: key, Value: 1}).Code == protocol.CodeOK
+	}, 5*time.Second, 10*time.Millisecond, "write is committed by the first node")
+
+	for _, node := range cluster.nodes {
+		test.Eventually(func() bool {
+			value, _ := node.server.fsm.get(key)
+
+			return value == 1
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	network.isolate(old.config.Address)
+
+	leader = -1
+
+	test.Eventually(func() bool {
+		for i, node := range cluster.nodes[1:] {
+			if node.server.raft.State() == raft.Leader {
+				leader = i + 1
+
+				return true
+			}
+		}
+
+		return false
+	}, 5*time.Second, 10*time.Millisecond, "majority elects new leader")
+
+	if leader < 0 {
+		return
+	}
+
+	test.Equal(
+		protocol.Response{Code: protocol.CodeOK, Value: 2},
+		call(test, cluster.nodes[leader].config.Address, protocol.Request{Op: protocol.OpIncX, Key: key, Value: 1}),
+	)
+
+	// Deposed leader still believes it's the leader, but it can't confirm
+	// that and doesn't answer with value overwritten by new one.
+	test.Equal(raft.Leader, old.server.raft.State())
+
+	response := call(test, old.config.Address, protocol.Request{Op: protocol.OpGet, Key: key})
+	test.Contains([]protocol.Code{protocol.CodeUnavailable, protocol.CodeNotLeader}, response.Code)
+
+	test.Equal(
+		protocol.Response{Code: protocol.CodeOK, Value: 1},
+		call(test, old.config.Address, protocol.Request{Op: protocol.OpGetStale, Key: key}),
+		"stale read is answered from local state",
+	)
+
+	// Node catches up once partition heals.
+	network.heal()
+
+	test.Eventually(func() bool {
+		value, _ := old.server.fsm.get(key)
+
+		return value == 2 && old.server.raft.State() != raft.Leader
+	}, 10*time.Second, 10*time.Millisecond)
+
+	test.Equal(
+		protocol.Response{Code: protocol.CodeOK, Value: 2},
+		cluster.call(protocol.Request{Op: protocol.OpGet, Key: key}),
+	)
+}
+
+// testRaft returns raft configuration with timeouts suitable for cluster
+// on localhost.
+func testRaft() *raft.Config {
+	config := raft.DefaultConfig()
+
+	config.HeartbeatTimeout = 100 * time.Millisecond
+	config.ElectionTimeout = 100 * time.Millisecond
+	config.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.CommitTimeout = 5 * time.Millisecond
+
+	return config
+}
+
+// openFSM opens FSM with state stored by engine in directory.
+func openFSM(dir string) (*fsm, error) {
+	store, err := storage.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fsm, err := newFSM(store)
+	if err != nil {
+		store.Close()
+	}
+
+	return fsm, err
+}
+
+// stored returns state persisted by stopped node.
+func stored(test *assert.Assertions, dir string) map[protocol.Key]float64 {
+	store, err := storage.Open(filepath.Join(dir, "state"))
+	if !test.NoError(err) {
+		return nil
+	}
+
+	defer store.Close()
+
+	values, _, err := store.Load()
+	test.NoError(err)
+
+	return values
+}
+
+var errPartitioned = errors.New("network is partitioned")
+
+// partition is network between nodes of the cluster, which can cut node off
+// the others: raft connections from it or to it are closed and refused.
+type partition struct {
+	mutex    sync.Mutex
+	isolated string
+	links    map[net.Conn]link
+}
+
+// link is raft connection between addresses of two nodes.
+type link struct {
+	from string
+	to   string
+}
+
+func newPartition() *partition {
+	return &partition{
+		links: map[net.Conn]link{},
+	}
+}
+
+// dialer returns Dial of node with specified address.
+func (partition *partition) dialer(from string) func(string, time.Duration) (net.Conn, error) {
+	return func(address string, timeout time.Duration) (net.Conn, error) {
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		partition.mutex.Lock()
+		defer partition.mutex.Unlock()
+
+		link := link{from: from, to: address}
+
+		if partition.isolated == link.from || partition.isolated == link.to {
+			conn.Close()
+
+			return nil, errPartitioned
+		}
+
+		partition.links[conn] = link
+
+		return conn, nil
+	}
+}
+
+// isolate cuts node with specified address off the others.
+func (partition *partition) isolate(address string) {
+	partition.mutex.Lock()
+	defer partition.mutex.Unlock()
+
+	partition.isolated = address
+
+	for conn, link := range partition.links {
+		if link.from == address || link.to == address {
+			conn.Close()
+
+			delete(partition.links, conn)
+		}
+	}
+}
+
+// heal connects isolated node back.
+func (partition *partition) heal() {
+	partition.mutex.Lock()
+	defer partition.mutex.Unlock()
+
+	partition.isolated = ""
+}
+
+// memorySink is raft snapshot sink writing to memory.
+type memorySink struct {
+	bytes.Buffer
+}
+
+func (sink *memorySink) ID() string {
+	return "memory"
+}
+
+func (sink *memorySink) Cancel() error {
+	return nil
+}
+
+func (sink *memorySink) Close() error {
+	return nil
+}
+
+type node struct {
+	config Config
+	server *Server
+}
+
+type cluster struct {
+	test  *assert.Assertions
+	nodes []*node
+}
+
+// startCluster starts cluster of specified size on localhost, which is
+// closed at the end of the test.
+func startCluster(t *testing.T, test *assert.Assertions, size int) *cluster {
+	return startTunedCluster(t, test, size, func(int, *Config) {})
+}
+
+// startTunedCluster starts cluster with configuration of every node
+// changed by tune.
+func startTunedCluster(
+	t *testing.T,
+	test *assert.Assertions,
+	size int,
+	tune func(i int, config *Config),
+) *cluster {
+	cluster := &cluster{test: test}
+
+	var (
+		listeners []net.Listener
+		peers     []raft.Server
+	)
+
+	for i := 0; i < size; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if !test.NoError(err) {
+			t.FailNow()
+		}
+
+		config := Config{
+			ID:        fmt.Sprintf("node%d", i+1),
+			Address:   listener.Addr().String(),
+			Dir:       t.TempDir(),
+			Raft:      testRaft(),
+			LogOutput: io.Discard,
+		}
+
+		tune(i, &config)
+
+		listeners = append(listeners, listener)
+		peers = append(peers, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(config.ID),
+			Address:  raft.ServerAddress(config.Address),
+		})
+
+		cluster.nodes = append(cluster.nodes, &node{config: config})
+	}
+
+	t.Cleanup(func() {
+		for i := range cluster.nodes {
+			cluster.stop(i)
+		}
+	})
+
+	for i, node := range cluster.nodes {
+		node.config.Peers = peers
+
+		cluster.start(i, listeners[i])
+	}
+
+	return cluster
+}
+
+// add starts node without initial configuration, which waits until it's
+// added to the cluster, and returns its index.
+func (cluster *cluster) add(t *testing.T, tune func(i int, config *Config)) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !cluster.test.NoError(err) {
+		t.FailNow()
+	}
+
+	i := len(cluster.nodes)
+
+	config := Config{
+		ID:        fmt.Sprintf("node%d", i+1),
+		Address:   listener.Addr().String(),
+		Dir:       t.TempDir(),
+		Raft:      testRaft(),
+		LogOutput: io.Discard,
+	}
+
+	tune(i, &config)
+
+	cluster.nodes = append(cluster.nodes, &node{config: config})
+	cluster.start(i, listener)
+
+	return i
+}
+
+func (cluster *cluster) start(i int, listener net.Listener) {
+	node := cluster.nodes[i]
+
+	server, err := NewServer(node.config)
+	if !cluster.test.NoError(err) {
+		listener.Close()
+
+		return
+	}
+
+	node.server = server
+
+	go server.Serve(listener)
+}
+
+func (cluster *cluster) stop(i int) {
+	node := cluster.nodes[i]
+
+	if node.server != nil {
+		node.server.Close()
+		node.server = nil
+	}
+}
+
+// restart starts stopped node on its previous address.
+func (cluster *cluster) restart(i int) {
+	listener, err := net.Listen("tcp", cluster.nodes[i].config.Address)
+	if cluster.test.NoError(err) {
+		cluster.start(i, listener)
+	}
+}
+
+// leader waits until one of running nodes becomes the leader and returns
+// its index, or -1 if no leader is elected in time.
+func (cluster *cluster) leader() int {
+	leader := -1
+
+	cluster.test.Eventually(func() bool {
+		for i, node := range cluster.nodes {
+			if node.server != nil && node.server.raft.State() == raft.Leader {
+				leader = i
+
+				return true
+			}
+		}
+
+		return false
+	}, 5*time.Second, 10*time.Millisecond, "leader is elected")
+
+	return leader
+}
+
+// call sends request to the leader.
+func (cluster *cluster) call(request protocol.Request) protocol.Response {
+	leader := cluster.leader()
+	if leader < 0 {
+		return protocol.Response{Code: protocol.CodeUnavailable}
+	}
+
+	return call(cluster.test, cluster.nodes[leader].config.Address, request)
+}
+
+func call(test *assert.Assertions, address string, request protocol.Request) protocol.Response {
+	conn, err := net.Dial("tcp", address)
+	if !test.NoError(err) {
+		return protocol.Response{Code: protocol.CodeUnavailable}
+	}
+
+	defer conn.Close()
+
+	test.NoError(protocol.WriteRequest(conn, request))
+
+	response, err := protocol.ReadResponse(conn)
+	test.NoError(err)
+
+	return response
 }
@@ -0,0 +1,407 @@
+// Package kvclient is client of the replicated key-value storage of user
+// balances. It keeps pool of pipelined connections to the leader of the
+// cluster, finds the leader by following NOT_LEADER responses of other
+// nodes and retries requests after leader change.
+package kvclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/protocol"
+)
+
+var (
+	ErrNotFound  = errors.New("key not found")
+	ErrExists    = errors.New("key already exists")
+	ErrNotLeader = errors.New("node is not the leader")
+	ErrInvalid   = errors.New("invalid operation")
+
+	ErrInsufficientFunds = errors.New("insufficient funds")
+
+	// ErrUnavailable is returned when cluster can't be reached or write
+	// wasn't committed in time. Write may still be applied in the latter
+	// case, so it's not retried.
+	ErrUnavailable = errors.New("cluster is unavailable")
+
+	ErrClosed    = errors.New("client is closed")
+	ErrNoAddress = errors.New("no address of cluster node")
+)
+
+const (
+	DefaultPoolSize    = 4
+	DefaultRetries     = 8
+	DefaultBackoff     = 50 * time.Millisecond
+	DefaultDialTimeout = time.Second
+
+	// maxBackoff limits delay between retries while leader is unknown.
+	maxBackoff = time.Second
+)
+
+// Key is UUID of user.
+type Key = protocol.Key
+
+// ParseKey parses UUID in canonical form or as 32 hex digits.
+func ParseKey(text string) (Key, error) {
+	return protocol.ParseKey(text)
+}
+
+type Option func(client *Client)
+
+// WithPoolSize sets number of connections to the leader.
+func WithPoolSize(size int) Option {
+	return func(client *Client) {
+		client.size = size
+	}
+}
+
+// WithRetries sets number of times request is repeated after redirect or
+// connection failure.
+func WithRetries(retries int) Option {
+	return func(client *Client) {
+		client.retries = retries
+	}
+}
+
+// WithBackoff sets initial delay before retry when no node is reachable or
+// leader is unknown. The delay doubles with every retry.
+func WithBackoff(backoff time.Duration) Option {
+	return func(client *Client) {
+		client.backoff = backoff
+	}
+}
+
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(client *Client) {
+		client.dialer.Timeout = timeout
+	}
+}
+
+// Client is safe for concurrent use. Requests of concurrent callers are
+// spread over pool of connections and pipelined.
+type Client struct {
+	nodes   []string
+	size    int
+	retries int
+	backoff time.Duration
+	dialer  net.Dialer
+
+	mutex sync.Mutex
+
+	// leader is address of the leader, or empty if it's unknown, in which
+	// case node is tried.
+	leader string
+	node   int
+
+	// pool holds connections to target, slots are dialed lazily.
+	target string
+	pool   []*conn
+	next   int
+
+	closed bool
+}
+
+// New returns client of cluster with nodes listening on specified
+// addresses. Any node can be used to find the leader.
+func New(addresses []string, options ...Option) (*Client, error) {
+	if len(addresses) == 0 {
+		return nil, ErrNoAddress
+	}
+
+	client := &Client{
+		nodes:   append([]string{}, addresses...),
+		size:    DefaultPoolSize,
+		retries: DefaultRetries,
+		backoff: DefaultBackoff,
+		dialer:  net.Dialer{Timeout: DefaultDialTimeout},
+	}
+
+	for _, option := range options {
+		option(client)
+	}
+
+	if client.size < 1 {
+		client.size = 1
+	}
+
+	return client, nil
+}
+
+// Get returns the latest value of key, which is confirmed by the leader.
+func (client *Client) Get(ctx context.Context, key Key) (float64, error) {
+	return client.value(ctx, protocol.Request{Op: protocol.OpGet, Key: key})
+}
+
+// GetStale returns value of key known to any node, which may miss the
+// latest writes. It's cheaper than Get and works without the leader.
+func (client *Client) GetStale(ctx context.Context, key Key) (float64, error) {
+	return client.value(ctx, protocol.Request{Op: protocol.OpGetStale, Key: key})
+}
+
+// SetX sets value of key only if it doesn't exist. If key exists, it
+// returns ErrExists together with current value.
+func (client *Client) SetX(ctx context.Context, key Key, value float64) (float64, error) {
+	return client.value(ctx, protocol.Request{Op: protocol.OpSetX, Key: key, Value: value})
+}
+
+// IncX increments value of existing key and returns new value.
+func (client *Client) IncX(ctx context.Context, key Key, delta float64) (float64, error) {
+	return client.value(ctx, protocol.Request{Op: protocol.OpIncX, Key: key, Value: delta})
+}
+
+// DecX decrements value of existing key and returns new value.
+func (client *Client) DecX(ctx context.Context, key Key, delta float64) (float64, error) {
+	return client.value(ctx, protocol.Request{Op: protocol.OpDecX, Key: key, Value: delta})
+}
+
+// Transfer moves positive amount from one existing key to another
+// atomically and returns their new values. If balance of from is lower
+// than amount, it returns ErrInsufficientFunds together with the balance.
+func (client *Client) Transfer(ctx context.Context, from Key, to Key, amount float64) (float64, float64, error) {
+	response, err := client.do(ctx, protocol.Request{Op: protocol.OpTransfer, Key: from, Value: amount, To: to})
+
+	return response.Value, response.To, err
+}
+
+// Leader returns address of the leader known to client, or empty string.
+func (client *Client) Leader() string {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.leader
+}
+
+// Close closes every connection, pending requests fail with ErrClosed.
+func (client *Client) Close() error {
+	client.mutex.Lock()
+
+	client.closed = true
+	pool := client.pool
+	client.pool = nil
+
+	client.mutex.Unlock()
+
+	for _, conn := range pool {
+		if conn != nil {
+			conn.close()
+		}
+	}
+
+	return nil
+}
+
+func (client *Client) value(ctx context.Context, request protocol.Request) (float64, error) {
+	response, err := client.do(ctx, request)
+
+	return response.Value, err
+}
+
+// do sends request to the leader. Request is repeated on another node if
+// it was redirected or it certainly didn't reach node, and reads are
+// repeated after any connection failure or until leader is ready to answer
+// them.
+func (client *Client) do(ctx context.Context, request protocol.Request) (protocol.Response, error) {
+	backoff := client.backoff
+
+	for attempt := 0; ; attempt++ {
+		response, retry, err := client.attempt(ctx, request)
+
+		if !retry {
+			if err != nil {
+				return protocol.Response{}, err
+			}
+
+			return response, codeError(response.Code)
+		}
+
+		if attempt >= client.retries {
+			if err != nil {
+				return protocol.Response{}, fmt.Errorf("%w: %w", ErrUnavailable, err)
+			}
+
+			return protocol.Response{}, codeError(response.Code)
+		}
+
+		// Leader is tried immediately after redirect, otherwise cluster
+		// needs time to elect leader or recover.
+		if err == nil && response.Leader != "" {
+			continue
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return protocol.Response{}, ctx.Err()
+		}
+
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// attempt sends request once and reports whether it should be repeated.
+func (client *Client) attempt(
+	ctx context.Context,
+	request protocol.Request,
+) (protocol.Response, bool, error) {
+	conn, err := client.conn()
+	if err != nil {
+		return protocol.Response{}, false, err
+	}
+
+	response, sent, err := conn.do(ctx, request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return response, false, ctx.Err()
+		}
+
+		// Retired connection refuses requests, but its node is fine.
+		if err != errRetired {
+			client.unreachable(conn.address)
+		}
+
+		if sent && request.Op.Write() {
+			return response, false, fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+
+		return response, true, err
+	}
+
+	if response.Code == protocol.CodeNotLeader {
+		client.redirect(conn.address, response.Leader)
+
+		return response, true, nil
+	}
+
+	if response.Code == protocol.CodeUnavailable && !request.Op.Write() {
+		return response, true, nil
+	}
+
+	// Any node answers stale reads, while other operations are answered
+	// only by the leader.
+	if request.Op != protocol.OpGetStale {
+		client.answered(conn.address)
+	}
+
+	return response, false, nil
+}
+
+// conn returns connection of the pool. Missing or broken connection is
+// replaced by new one, which is dialed in background while requests wait
+// for it.
+func (client *Client) conn() (*conn, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.closed {
+		return nil, ErrClosed
+	}
+
+	target := client.leader
+	if target == "" {
+		target = client.nodes[client.node%len(client.nodes)]
+	}
+
+	if target != client.target || client.pool == nil {
+		client.retarget(target)
+	}
+
+	slot := client.next % client.size
+	client.next++
+
+	existing := client.pool[slot]
+	if existing != nil && existing.alive() {
+		return existing, nil
+	}
+
+	if existing != nil {
+		existing.retire()
+	}
+
+	conn := newConn(target)
+	client.pool[slot] = conn
+
+	go conn.connect(&client.dialer)
+
+	return conn, nil
+}
+
+// retarget switches pool to another node. Old connections are closed once
+// their pending requests are answered.
+func (client *Client) retarget(target string) {
+	for _, conn := range client.pool {
+		if conn != nil {
+			conn.retire()
+		}
+	}
+
+	client.target = target
+	client.pool = make([]*conn, client.size)
+}
+
+// redirect remembers leader reported by node, or moves on to the next node
+// if leader is unknown to it.
+func (client *Client) redirect(from string, leader string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if leader != "" && leader != from {
+		client.leader = leader
+
+		return
+	}
+
+	client.forget(from)
+}
+
+// answered remembers node which executed request as the leader.
+func (client *Client) answered(address string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.target == address {
+		client.leader = address
+	}
+}
+
+// unreachable forgets node which can't be reached.
+func (client *Client) unreachable(address string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	client.forget(address)
+}
+
+func (client *Client) forget(address string) {
+	if client.leader == address {
+		client.leader = ""
+	}
+
+	if client.nodes[client.node%len(client.nodes)] == address {
+		client.node++
+	}
+}
+
+func codeError(code protocol.Code) error {
+	switch code {
+	case protocol.CodeOK:
+		return nil
+	case protocol.CodeNotFound:
+		return ErrNotFound
+	case protocol.CodeExists:
+		return ErrExists
+	case protocol.CodeNotLeader:
+		return ErrNotLeader
+	case protocol.CodeInvalid:
+		return ErrInvalid
+	case protocol.CodeUnavailable:
+		return ErrUnavailable
+	case protocol.CodeInsufficientFunds:
+		return ErrInsufficientFunds
+	}
+
+	return fmt.Errorf("unknown response code %d", code)
+}
@@ -0,0 +1,213 @@
+package kvclient
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/kvclient/kvtest"
+)
+
+func TestClient_Operations(t *testing.T) {
+	test := assert.New(t)
+
+	server := kvtest.NewServer()
+	defer server.Close()
+
+	client, err := New([]string{server.Address})
+	if !test.NoError(err) {
+		return
+	}
+
+	defer client.Close()
+
+	ctx := context.Background()
+	key := Key{1}
+
+	value, err := client.SetX(ctx, key, 100)
+	test.NoError(err)
+	test.Equal(100.0, value)
+
+	value, err = client.SetX(ctx, key, 50)
+	test.ErrorIs(err, ErrExists)
+	test.Equal(100.0, value, "current value is returned")
+
+	value, err = client.IncX(ctx, key, 25)
+	test.NoError(err)
+	test.Equal(125.0, value)
+
+	value, err = client.DecX(ctx, key, 5)
+	test.NoError(err)
+	test.Equal(120.0, value)
+
+	value, err = client.Get(ctx, key)
+	test.NoError(err)
+	test.Equal(120.0, value)
+
+	_, err = client.Get(ctx, Key{2})
+	test.ErrorIs(err, ErrNotFound)
+
+	_, err = client.IncX(ctx, Key{2}, 1)
+	test.ErrorIs(err, ErrNotFound)
+
+	stored, _ := server.Value(key)
+	test.Equal(120.0, stored)
+
+	other := Key{3}
+	server.Set(other, 10)
+
+	from, to, err := client.Transfer(ctx, key, other, 20)
+	test.NoError(err)
+	test.Equal([]float64{100, 30}, []float64{from, to})
+
+	from, _, err = client.Transfer(ctx, other, key, 50)
+	test.ErrorIs(err, ErrInsufficientFunds)
+	test.Equal(30.0, from, "current balance is returned")
+
+	_, err = New(nil)
+	test.ErrorIs(err, ErrNoAddress)
+}
+
+func TestClient_Pipelining(t *testing.T) {
+	test := assert.New(t)
+
+	server := kvtest.NewServer()
+	defer server.Close()
+
+	client, err := New([]string{server.Address}, WithPoolSize(2))
+	if !test.NoError(err) {
+		return
+	}
+
+	defer client.Close()
+
+	key := Key{1}
+	server.Set(key, 0)
+
+	var wait sync.WaitGroup
+
+	for i := 0; i < 500; i++ {
+		wait.Add(1)
+
+		go func() {
+			defer wait.Done()
+
+			_, err := client.IncX(context.Background(), key, 1)
+			test.NoError(err)
+		}()
+	}
+
+	wait.Wait()
+
+	value, _ := server.Value(key)
+	test.Equal(500.0, value)
+	test.LessOrEqual(server.Accepted(), 2, "requests share pool")
+}
+
+func TestClient_Leader(t *testing.T) {
+	test := assert.New(t)
+
+	leader := kvtest.NewServer()
+	defer leader.Close()
+
+	follower := kvtest.NewServer()
+	defer follower.Close()
+
+	follower.Follow(leader.Address)
+
+	client, err := New(
+		[]string{follower.Address, leader.Address},
+		WithRetries(3),
+		WithBackoff(time.Millisecond),
+	)
+	if !test.NoError(err) {
+		return
+	}
+
+	defer client.Close()
+
+	ctx := context.Background()
+	key := Key{1}
+
+	_, err = client.SetX(ctx, key, 10)
+	test.NoError(err)
+	test.Equal(leader.Address, client.Leader(), "leader is discovered through follower")
+
+	_, ok := follower.Value(key)
+	test.False(ok)
+
+	// Leader changes, and request is retried on the new one.
+	follower.Set(key, 20)
+	follower.Lead()
+	leader.Follow(follower.Address)
+
+	value, err := client.IncX(ctx, key, 1)
+	test.NoError(err)
+	test.Equal(21.0, value)
+	test.Equal(follower.Address, client.Leader())
+
+	// Nodes which don't know the leader are tried in turn.
+	leader.Follow("")
+	follower.Follow("")
+
+	_, err = client.Get(ctx, key)
+	test.ErrorIs(err, ErrNotLeader)
+	test.Equal("", client.Leader())
+
+	_, err = client.GetStale(ctx, key)
+	test.NoError(err, "followers answer stale reads")
+	test.Equal("", client.Leader(), "follower answering stale read isn't the leader")
+
+	leader.Lead()
+
+	value, err = client.Get(ctx, key)
+	test.NoError(err)
+	test.Equal(10.0, value)
+}
+
+func TestClient_Failures(t *testing.T) {
+	test := assert.New(t)
+
+	server := kvtest.NewServer()
+
+	client, err := New(
+		[]string{server.Address},
+		WithRetries(2),
+		WithBackoff(time.Millisecond),
+	)
+	if !test.NoError(err) {
+		return
+	}
+
+	key := Key{1}
+	server.Set(key, 1)
+
+	_, err = client.Get(context.Background(), key)
+	test.NoError(err)
+
+	// Reads are repeated on new connection.
+	server.Disconnect()
+
+	value, err := client.Get(context.Background(), key)
+	test.NoError(err)
+	test.Equal(1.0, value)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = client.Get(ctx, key)
+	test.ErrorIs(err, context.Canceled)
+
+	server.Close()
+
+	_, err = client.Get(context.Background(), key)
+	test.ErrorIs(err, ErrUnavailable)
+
+	client.Close()
+
+	_, err = client.Get(context.Background(), key)
+	test.ErrorIs(err, ErrClosed)
+}
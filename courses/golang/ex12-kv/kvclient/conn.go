@@ -0,0 +1,274 @@
+package kvclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/protocol"
+)
+
+var (
+	errRetired    = errors.New("connection is retired")
+	errUnexpected = errors.New("unexpected response")
+)
+
+// conn is pipelined connection to single node. Requests of concurrent
+// callers are written by one goroutine and flushed once there are no more
+// of them, and responses are matched to requests in order by another one.
+type conn struct {
+	address string
+	conn    net.Conn
+
+	requests chan *call
+
+	mutex   sync.Mutex
+	pending []*call
+	retired bool
+	err     error
+	done    chan struct{}
+}
+
+type call struct {
+	request  protocol.Request
+	response protocol.Response
+	err      error
+
+	// sent reports whether request might have reached node.
+	sent bool
+	done chan struct{}
+}
+
+func (call *call) finish(response protocol.Response, err error) {
+	call.response = response
+	call.err = err
+
+	close(call.done)
+}
+
+func newConn(address string) *conn {
+	return &conn{
+		address:  address,
+		requests: make(chan *call),
+		done:     make(chan struct{}),
+	}
+}
+
+// connect dials node and starts serving requests, which wait until then.
+func (conn *conn) connect(dialer *net.Dialer) {
+	socket, err := dialer.Dial("tcp", conn.address)
+	if err != nil {
+		conn.fail(err)
+
+		return
+	}
+
+	conn.mutex.Lock()
+
+	if conn.err != nil {
+		conn.mutex.Unlock()
+
+		socket.Close()
+
+		return
+	}
+
+	conn.conn = socket
+
+	conn.mutex.Unlock()
+
+	go conn.write()
+	go conn.read()
+}
+
+// do sends request and waits for its response. Besides error it reports
+// whether request might have reached node, so that caller knows if it's
+// safe to repeat it.
+func (conn *conn) do(ctx context.Context, request protocol.Request) (protocol.Response, bool, error) {
+	call := &call{
+		request: request,
+		done:    make(chan struct{}),
+	}
+
+	select {
+	case conn.requests <- call:
+	case <-conn.done:
+		return protocol.Response{}, false, conn.error()
+	case <-ctx.Done():
+		return protocol.Response{}, false, ctx.Err()
+	}
+
+	select {
+	case <-call.done:
+		return call.response, call.sent, call.err
+	case <-ctx.Done():
+		return protocol.Response{}, true, ctx.Err()
+	}
+}
+
+// alive reports whether connection can take new requests.
+func (conn *conn) alive() bool {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	return conn.err == nil && !conn.retired
+}
+
+func (conn *conn) error() error {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	return conn.err
+}
+
+// retire closes connection once responses to every pending request are
+// received. New requests are refused as not sent.
+func (conn *conn) retire() {
+	conn.mutex.Lock()
+
+	conn.retired = true
+	idle := len(conn.pending) == 0
+
+	conn.mutex.Unlock()
+
+	if idle {
+		conn.fail(errRetired)
+	}
+}
+
+func (conn *conn) close() {
+	conn.fail(ErrClosed)
+}
+
+// fail closes connection and finishes every pending request with error.
+func (conn *conn) fail(err error) {
+	conn.mutex.Lock()
+
+	if conn.err != nil {
+		conn.mutex.Unlock()
+
+		return
+	}
+
+	conn.err = err
+	pending := conn.pending
+	conn.pending = nil
+
+	close(conn.done)
+
+	if conn.conn != nil {
+		conn.conn.Close()
+	}
+
+	conn.mutex.Unlock()
+
+	for _, call := range pending {
+		call.finish(protocol.Response{}, err)
+	}
+}
+
+func (conn *conn) enqueue(call *call) bool {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.err != nil || conn.retired {
+		return false
+	}
+
+	call.sent = true
+	conn.pending = append(conn.pending, call)
+
+	return true
+}
+
+// dequeue returns the oldest pending request and whether connection
+// should be closed after it.
+func (conn *conn) dequeue() (*call, bool) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if len(conn.pending) == 0 {
+		return nil, false
+	}
+
+	call := conn.pending[0]
+	conn.pending[0] = nil
+	conn.pending = conn.pending[1:]
+
+	return call, conn.retired && len(conn.pending) == 0
+}
+
+func (conn *conn) write() {
+	writer := bufio.NewWriter(conn.conn)
+
+	for {
+		var call *call
+
+		select {
+		case call = <-conn.requests:
+		case <-conn.done:
+			return
+		}
+
+		for call != nil {
+			if !conn.enqueue(call) {
+				err := conn.error()
+				if err == nil {
+					err = errRetired
+				}
+
+				call.finish(protocol.Response{}, err)
+
+				break
+			}
+
+			if err := protocol.WriteRequest(writer, call.request); err != nil {
+				conn.fail(err)
+
+				return
+			}
+
+			select {
+			case call = <-conn.requests:
+			default:
+				call = nil
+			}
+		}
+
+		if err := writer.Flush(); err != nil {
+			conn.fail(err)
+
+			return
+		}
+	}
+}
+
+func (conn *conn) read() {
+	reader := bufio.NewReader(conn.conn)
+
+	for {
+		response, err := protocol.ReadResponse(reader)
+		if err != nil {
+			conn.fail(err)
+
+			return
+		}
+
+		call, last := conn.dequeue()
+		if call == nil {
+			conn.fail(errUnexpected)
+
+			return
+		}
+
+		call.finish(response, nil)
+
+		if last {
+			conn.fail(errRetired)
+
+			return
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Package kvtest provides in-process fake of the key-value storage node
+// for unit tests of its clients. It speaks the same protocol and executes
+// requests with the same semantics as real node, but keeps values in
+// memory and can pretend to be follower of another node.
+package kvtest
+
+import (
+	"bufio"
+	"net"
+	"sync"
+
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/protocol"
+)
+
+type Server struct {
+	// Address is address the server listens on.
+	Address string
+
+	listener net.Listener
+
+	mutex    sync.Mutex
+	values   map[protocol.Key]float64
+	follower bool
+	leader   string
+
+	connections map[net.Conn]struct{}
+	accepted    int
+	wait        sync.WaitGroup
+}
+
+// NewServer starts server on loopback interface. It panics if it can't
+// listen, like httptest.NewServer does.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("kvtest: can't listen: " + err.Error())
+	}
+
+	server := &Server{
+		Address:     listener.Addr().String(),
+		listener:    listener,
+		values:      map[protocol.Key]float64{},
+		connections: map[net.Conn]struct{}{},
+	}
+
+	server.wait.Add(1)
+
+	go server.serve()
+
+	return server
+}
+
+// Close stops server and closes every connection.
+func (server *Server) Close() {
+	server.listener.Close()
+	server.Disconnect()
+	server.wait.Wait()
+}
+
+// Disconnect closes every client connection, as if node was restarted.
+func (server *Server) Disconnect() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for conn := range server.connections {
+		conn.Close()
+	}
+}
+
+// Accepted returns number of connections accepted so far.
+func (server *Server) Accepted() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return server.accepted
+}
+
+func (server *Server) Set(key protocol.Key, value float64) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.values[key] = value
+}
+
+func (server *Server) Value(key protocol.Key) (float64, bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	value, ok := server.values[key]
+
+	return value, ok
+}
+
+// Follow makes server answer every request with NOT_LEADER response
+// pointing to leader, empty leader means that leader is unknown.
+func (server *Server) Follow(leader string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.follower = true
+	server.leader = leader
+}
+
+// Lead makes server execute requests again.
+func (server *Server) Lead() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.follower = false
+	server.leader = ""
+}
+
+func (server *Server) serve() {
+	defer server.wait.Done()
+
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		server.mutex.Lock()
+
+		server.connections[conn] = struct{}{}
+		server.accepted++
+		server.wait.Add(1)
+
+		server.mutex.Unlock()
+
+		go server.handle(conn)
+	}
+}
+
+func (server *Server) handle(conn net.Conn) {
+	defer server.wait.Done()
+
+	defer func() {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+
+		delete(server.connections, conn)
+
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	for {
+		request, err := protocol.ReadRequest(reader)
+		if err != nil && err != protocol.ErrUnsupportedVersion && err != protocol.ErrMalformedPacket {
+			return
+		}
+
+		// Extension frame which can't be decoded is skipped entirely, so
+		// request is answered as invalid.
+		response := protocol.Response{Code: protocol.CodeInvalid}
+		if err == nil {
+			response = server.execute(request)
+		}
+
+		if protocol.WriteResponse(writer, response) != nil {
+			return
+		}
+
+		if reader.Buffered() == 0 && writer.Flush() != nil {
+			return
+		}
+	}
+}
+
+func (server *Server) execute(request protocol.Request) protocol.Response {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	// Followers answer only stale reads.
+	if server.follower && request.Op != protocol.OpGetStale {
+		return protocol.Response{Code: protocol.CodeNotLeader, Leader: server.leader}
+	}
+
+	return protocol.Apply(server.values, request)
+}
@@ -0,0 +1,121 @@
+// Command kv runs node of replicated key-value storage of user balances.
+//
+// Usage:
+//
+//	kv -id node1 -address 127.0.0.1:7001 -dir data/node1 \
+//		-peers node1=127.0.0.1:7001,node2=127.0.0.1:7002,node3=127.0.0.1:7003
+//
+// Every node of new cluster is started with the same -peers, and restarted
+// node recovers its state from -dir. Node started without -peers waits
+// until it's added to existing cluster by kvctl. Clients, other nodes and
+// kvctl connect to -address, which is also listened on unless -listen is
+// specified.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/hashicorp/raft"
+)
+
+func main() {
+	var (
+		config Config
+		listen string
+		peers  string
+	)
+
+	flag.StringVar(&config.ID, "id", "", "unique node ID")
+	flag.StringVar(&config.Address, "address", "127.0.0.1:7001", "address advertised to clients and other nodes")
+	flag.StringVar(&listen, "listen", "", "address to listen on (default -address)")
+	flag.StringVar(&config.Dir, "dir", "data", "directory of raft log, snapshots and state")
+	flag.StringVar(&peers, "peers", "", "initial cluster as comma-separated id=address pairs")
+	flag.DurationVar(&config.ApplyTimeout, "apply-timeout", DefaultApplyTimeout, "time limit of committing single write")
+	flag.Uint64Var(&config.SnapshotThreshold, "snapshot-threshold", 0, "number of log entries which triggers snapshot (default of raft if 0)")
+	flag.DurationVar(&config.SnapshotInterval, "snapshot-interval", 0, "how often snapshot threshold is checked (default of raft if 0)")
+	flag.Uint64Var(&config.TrailingLogs, "trailing-logs", 0, "number of log entries kept after snapshot (default of raft if 0)")
+
+	flag.Parse()
+
+	if config.ID == "" {
+		config.ID = config.Address
+	}
+
+	if listen == "" {
+		listen = config.Address
+	}
+
+	var err error
+
+	config.Peers, err = parsePeers(peers)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kv:", err)
+		os.Exit(2)
+	}
+
+	if err := run(config, listen); err != nil {
+		fmt.Fprintln(os.Stderr, "kv:", err)
+		os.Exit(1)
+	}
+}
+
+func run(config Config, listen string) error {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+
+	server, err := NewServer(config)
+	if err != nil {
+		listener.Close()
+
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+
+		server.Close()
+	}()
+
+	if err := server.Serve(listener); err != ErrClosed {
+		server.Close()
+
+		return err
+	}
+
+	return nil
+}
+
+// parsePeers parses comma-separated id=address pairs.
+func parsePeers(text string) ([]raft.Server, error) {
+	var peers []raft.Server
+
+	if text == "" {
+		return nil, nil
+	}
+
+	for _, pair := range strings.Split(text, ",") {
+		id, address, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || id == "" || address == "" {
+			return nil, fmt.Errorf("invalid peer %q, expected id=address", pair)
+		}
+
+		peers = append(peers, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(id),
+			Address:  raft.ServerAddress(address),
+		})
+	}
+
+	return peers, nil
+}
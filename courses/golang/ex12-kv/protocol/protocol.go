@@ -0,0 +1,549 @@
+// Package protocol implements TCP framing of the key-value service. Every
+// request is fixed-size packet of operation, 16-byte key and 8-byte value,
+// and every response is fixed-size packet of code and value. Values are
+// float64 numbers encoded as big-endian IEEE 754 bits.
+//
+// Operations which need more fields, like TRANSFER, are extended: their
+// packets are followed by versioned extension frame of version byte,
+// big-endian 16-bit length and payload. Response which carries extension
+// frame, like NOT_LEADER with address of the leader, has the highest bit of
+// its code set.
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+)
+
+const (
+	// RequestSize is size of request packet: operation, key and value.
+	RequestSize = 1 + KeySize + 8
+
+	// ResponseSize is size of response packet: code and value.
+	ResponseSize = 1 + 8
+
+	KeySize = 16
+
+	// ExtensionVersion is version of extension frame supported by package.
+	ExtensionVersion = 1
+
+	// extensionHeaderSize is size of extension frame header: version and
+	// length of payload.
+	extensionHeaderSize = 1 + 2
+
+	// extended flags response code followed by extension frame.
+	extended = 0x80
+)
+
+// MaxLeader is maximal length of leader address carried by extension frame
+// of NOT_LEADER response.
+const MaxLeader = 255
+
+var (
+	ErrMalformedPacket    = errors.New("malformed packet")
+	ErrInvalidKey         = errors.New("invalid key")
+	ErrUnsupportedVersion = errors.New("unsupported extension version")
+)
+
+// Op is operation of request. Operations with the highest bit set and ASCII
+// capital letters are never sent by clients, so server can use them to tell
+// internal traffic and HTTP sharing the same port.
+type Op uint8
+
+const (
+	OpGet  Op = 1
+	OpSetX Op = 2
+	OpIncX Op = 3
+	OpDecX Op = 4
+
+	// OpGetStale is GET which may be answered by any node from its local
+	// state, so it can return value older than the latest write.
+	OpGetStale Op = 5
+
+	// OpTransfer debits amount carried by value from key and credits it to
+	// key carried by extension frame in single step. It fails if debited
+	// balance would become negative.
+	OpTransfer Op = 6
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpGet:
+		return "GET"
+	case OpSetX:
+		return "SETX"
+	case OpIncX:
+		return "INCX"
+	case OpDecX:
+		return "DECX"
+	case OpGetStale:
+		return "GET_STALE"
+	case OpTransfer:
+		return "TRANSFER"
+	}
+
+	return "UNKNOWN"
+}
+
+// Write reports whether operation changes values.
+func (op Op) Write() bool {
+	return op == OpSetX || op == OpIncX || op == OpDecX || op == OpTransfer
+}
+
+// Extended reports whether request packet of operation is followed by
+// extension frame.
+func (op Op) Extended() bool {
+	return op == OpTransfer
+}
+
+// Code is result of request.
+type Code uint8
+
+const (
+	CodeOK Code = 0
+
+	// CodeNotFound is returned by GET, INCX and DECX of missing key.
+	CodeNotFound Code = 1
+
+	// CodeExists is returned by SETX of existing key with its current
+	// value.
+	CodeExists Code = 2
+
+	// CodeNotLeader is returned by node which isn't leader of the cluster.
+	// Address of the leader is carried by extension frame, and response
+	// without it means that leader is unknown at the moment.
+	CodeNotLeader Code = 3
+
+	// CodeInvalid is returned for unknown operation.
+	CodeInvalid Code = 4
+
+	// CodeUnavailable is returned when write couldn't be committed in time
+	// or leadership was lost while committing it, in which case write may
+	// still be applied later. It's also returned by GET when leader can't
+	// confirm its leadership in time.
+	CodeUnavailable Code = 5
+
+	// CodeInsufficientFunds is returned by TRANSFER which would make
+	// debited balance negative, with its current value.
+	CodeInsufficientFunds Code = 6
+)
+
+func (code Code) String() string {
+	switch code {
+	case CodeOK:
+		return "OK"
+	case CodeNotFound:
+		return "NOT_FOUND"
+	case CodeExists:
+		return "EXISTS"
+	case CodeNotLeader:
+		return "NOT_LEADER"
+	case CodeInvalid:
+		return "INVALID"
+	case CodeUnavailable:
+		return "UNAVAILABLE"
+	case CodeInsufficientFunds:
+		return "INSUFFICIENT_FUNDS"
+	}
+
+	return "UNKNOWN"
+}
+
+// Key is UUID of user.
+type Key [KeySize]byte
+
+// ParseKey parses UUID in canonical form
+// xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx or as 32 hex digits.
+func ParseKey(text string) (Key, error) {
+	var key Key
+
+	digits := make([]byte, 0, 2*KeySize)
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == '-' && len(text) == 36 && (i == 8 || i == 13 || i == 18 || i == 23) {
+			continue
+		}
+
+		digits = append(digits, text[i])
+	}
+
+	if len(digits) != 2*KeySize {
+		return key, ErrInvalidKey
+	}
+
+	if _, err := hex.Decode(key[:], digits); err != nil {
+		return key, ErrInvalidKey
+	}
+
+	return key, nil
+}
+
+func (key Key) String() string {
+	var text [36]byte
+
+	hex.Encode(text[0:8], key[0:4])
+	text[8] = '-'
+	hex.Encode(text[9:13], key[4:6])
+	text[13] = '-'
+	hex.Encode(text[14:18], key[6:8])
+	text[18] = '-'
+	hex.Encode(text[19:23], key[8:10])
+	text[23] = '-'
+	hex.Encode(text[24:], key[10:])
+
+	return string(text[:])
+}
+
+type Request struct {
+	Op    Op
+	Key   Key
+	Value float64
+
+	// To is key credited by TRANSFER, which is carried by extension frame.
+	To Key
+}
+
+func (request Request) AppendBinary(buffer []byte) ([]byte, error) {
+	buffer = append(buffer, byte(request.Op))
+	buffer = append(buffer, request.Key[:]...)
+	buffer = binary.BigEndian.AppendUint64(buffer, math.Float64bits(request.Value))
+
+	if request.Op.Extended() {
+		buffer = appendExtension(buffer, request.To[:])
+	}
+
+	return buffer, nil
+}
+
+func (request *Request) UnmarshalBinary(data []byte) error {
+	if len(data) < RequestSize {
+		return ErrMalformedPacket
+	}
+
+	request.unmarshalPacket(data[:RequestSize])
+
+	if !request.Op.Extended() {
+		if len(data) != RequestSize {
+			return ErrMalformedPacket
+		}
+
+		return nil
+	}
+
+	payload, err := decodeExtension(data[RequestSize:])
+	if err != nil {
+		return err
+	}
+
+	return request.unmarshalExtension(payload)
+}
+
+// unmarshalPacket decodes fixed-size part of request.
+func (request *Request) unmarshalPacket(packet []byte) {
+	request.Op = Op(packet[0])
+	copy(request.Key[:], packet[1:1+KeySize])
+	request.Value = math.Float64frombits(binary.BigEndian.Uint64(packet[1+KeySize:]))
+}
+
+func (request *Request) unmarshalExtension(payload []byte) error {
+	if len(payload) != KeySize {
+		return ErrMalformedPacket
+	}
+
+	copy(request.To[:], payload)
+
+	return nil
+}
+
+type Response struct {
+	Code  Code
+	Value float64
+
+	// Leader is address of the leader of NOT_LEADER response, which is
+	// carried by extension frame unless it's empty.
+	Leader string
+
+	// Extended reports whether response carries extension frame with new
+	// value of key credited by TRANSFER in To.
+	Extended bool
+	To       float64
+}
+
+func (response Response) AppendBinary(buffer []byte) ([]byte, error) {
+	if response.Code&extended != 0 || response.Extended && response.Code == CodeNotLeader {
+		return nil, ErrMalformedPacket
+	}
+
+	if response.Code == CodeNotLeader {
+		return response.appendLeader(buffer)
+	}
+
+	code := byte(response.Code)
+	if response.Extended {
+		code |= extended
+	}
+
+	buffer = append(buffer, code)
+	buffer = binary.BigEndian.AppendUint64(buffer, math.Float64bits(response.Value))
+
+	if response.Extended {
+		var payload [8]byte
+
+		binary.BigEndian.PutUint64(payload[:], math.Float64bits(response.To))
+		buffer = appendExtension(buffer, payload[:])
+	}
+
+	return buffer, nil
+}
+
+// appendLeader encodes NOT_LEADER response, which has zero value.
+func (response Response) appendLeader(buffer []byte) ([]byte, error) {
+	if len(response.Leader) > MaxLeader {
+		return nil, ErrMalformedPacket
+	}
+
+	code := byte(CodeNotLeader)
+	if response.Leader != "" {
+		code |= extended
+	}
+
+	buffer = append(buffer, code)
+	buffer = binary.BigEndian.AppendUint64(buffer, 0)
+
+	if response.Leader != "" {
+		buffer = appendExtension(buffer, []byte(response.Leader))
+	}
+
+	return buffer, nil
+}
+
+// ReadRequest reads single request. It returns io.EOF only if reader ends
+// at packet boundary. Extension frame of unsupported version or with
+// malformed payload is read entirely, so next request can be read after
+// ErrUnsupportedVersion or ErrMalformedPacket.
+func ReadRequest(reader io.Reader) (Request, error) {
+	var (
+		packet  [RequestSize]byte
+		request Request
+	)
+
+	if _, err := io.ReadFull(reader, packet[:]); err != nil {
+		return request, err
+	}
+
+	request.unmarshalPacket(packet[:])
+
+	if !request.Op.Extended() {
+		return request, nil
+	}
+
+	payload, err := readExtension(reader)
+	if err != nil {
+		return request, err
+	}
+
+	err = request.unmarshalExtension(payload)
+
+	return request, err
+}
+
+func WriteRequest(writer io.Writer, request Request) error {
+	var packet [RequestSize + extensionHeaderSize + KeySize]byte
+
+	buffer, _ := request.AppendBinary(packet[:0])
+
+	_, err := writer.Write(buffer)
+
+	return err
+}
+
+// ReadResponse reads single response together with its extension frame.
+// Frame of unsupported version or with malformed payload is read entirely,
+// so next response can be read after ErrUnsupportedVersion or
+// ErrMalformedPacket.
+func ReadResponse(reader io.Reader) (Response, error) {
+	var (
+		packet   [ResponseSize]byte
+		response Response
+	)
+
+	if _, err := io.ReadFull(reader, packet[:]); err != nil {
+		return response, err
+	}
+
+	response.Code = Code(packet[0] &^ extended)
+
+	if packet[0]&extended == 0 {
+		if response.Code != CodeNotLeader {
+			response.Value = math.Float64frombits(binary.BigEndian.Uint64(packet[1:]))
+		}
+
+		return response, nil
+	}
+
+	payload, err := readExtension(reader)
+	if err != nil {
+		return response, err
+	}
+
+	if response.Code == CodeNotLeader {
+		if len(payload) > MaxLeader {
+			return response, ErrMalformedPacket
+		}
+
+		response.Leader = string(payload)
+
+		return response, nil
+	}
+
+	response.Value = math.Float64frombits(binary.BigEndian.Uint64(packet[1:]))
+	response.Extended = true
+
+	if len(payload) != 8 {
+		return response, ErrMalformedPacket
+	}
+
+	response.To = math.Float64frombits(binary.BigEndian.Uint64(payload))
+
+	return response, nil
+}
+
+func WriteResponse(writer io.Writer, response Response) error {
+	var packet [ResponseSize + extensionHeaderSize + MaxLeader]byte
+
+	buffer, err := response.AppendBinary(packet[:0])
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(buffer)
+
+	return err
+}
+
+// Apply executes request against values the same way as every node of the
+// cluster does and returns its response. Values are changed only by writes
+// with OK response.
+func Apply(values map[Key]float64, request Request) Response {
+	value, ok := values[request.Key]
+
+	switch request.Op {
+	case OpTransfer:
+		return transfer(values, request)
+	case OpGet, OpGetStale:
+		if !ok {
+			return Response{Code: CodeNotFound}
+		}
+
+		return Response{Code: CodeOK, Value: value}
+	case OpSetX:
+		if ok {
+			return Response{Code: CodeExists, Value: value}
+		}
+
+		value = request.Value
+	case OpIncX, OpDecX:
+		if !ok {
+			return Response{Code: CodeNotFound}
+		}
+
+		if request.Op == OpIncX {
+			value += request.Value
+		} else {
+			value -= request.Value
+		}
+	default:
+		return Response{Code: CodeInvalid}
+	}
+
+	values[request.Key] = value
+
+	return Response{Code: CodeOK, Value: value}
+}
+
+// transfer moves positive amount between two existing keys.
+func transfer(values map[Key]float64, request Request) Response {
+	if request.Key == request.To || !(request.Value > 0) {
+		return Response{Code: CodeInvalid}
+	}
+
+	from, ok := values[request.Key]
+	if !ok {
+		return Response{Code: CodeNotFound}
+	}
+
+	to, ok := values[request.To]
+	if !ok {
+		return Response{Code: CodeNotFound}
+	}
+
+	if from-request.Value < 0 {
+		return Response{Code: CodeInsufficientFunds, Value: from}
+	}
+
+	from -= request.Value
+	to += request.Value
+
+	values[request.Key] = from
+	values[request.To] = to
+
+	return Response{Code: CodeOK, Value: from, Extended: true, To: to}
+}
+
+func appendExtension(buffer []byte, payload []byte) []byte {
+	buffer = append(buffer, ExtensionVersion)
+	buffer = binary.BigEndian.AppendUint16(buffer, uint16(len(payload)))
+
+	return append(buffer, payload...)
+}
+
+// decodeExtension returns payload of extension frame filling data.
+func decodeExtension(data []byte) ([]byte, error) {
+	if len(data) < extensionHeaderSize {
+		return nil, ErrMalformedPacket
+	}
+
+	if len(data) != extensionHeaderSize+int(binary.BigEndian.Uint16(data[1:])) {
+		return nil, ErrMalformedPacket
+	}
+
+	if data[0] != ExtensionVersion {
+		return nil, ErrUnsupportedVersion
+	}
+
+	return data[extensionHeaderSize:], nil
+}
+
+// readExtension reads extension frame and returns its payload. Frame of
+// unsupported version is skipped.
+func readExtension(reader io.Reader) ([]byte, error) {
+	var header [extensionHeaderSize]byte
+
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, unexpected(err)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[1:]))
+
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, unexpected(err)
+	}
+
+	if header[0] != ExtensionVersion {
+		return nil, ErrUnsupportedVersion
+	}
+
+	return payload, nil
+}
+
+// unexpected reports end of stream inside of packet as io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,175 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtocol_Packets(t *testing.T) {
+	test := assert.New(t)
+
+	key, err := ParseKey("0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0")
+	test.NoError(err)
+	test.Equal("0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0", key.String())
+
+	compact, err := ParseKey("0f1e2d3c4b5a69788796a5b4c3d2e1f0")
+	test.NoError(err)
+	test.Equal(key, compact)
+
+	for _, text := range []string{"", "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f", "0f1e2d3c4b5a-6978-8796-a5b4c3d2e1f0", "zf1e2d3c4b5a69788796a5b4c3d2e1f0"} {
+		_, err := ParseKey(text)
+		test.ErrorIs(err, ErrInvalidKey, text)
+	}
+
+	buffer := &bytes.Buffer{}
+
+	request := Request{Op: OpIncX, Key: key, Value: -12.75}
+
+	test.NoError(WriteRequest(buffer, request))
+	test.Equal(RequestSize, buffer.Len())
+
+	decoded, err := ReadRequest(buffer)
+	test.NoError(err)
+	test.Equal(request, decoded)
+
+	_, err = ReadRequest(buffer)
+	test.Equal(io.EOF, err)
+
+	responses := []Response{
+		{Code: CodeOK, Value: 1e9},
+		{Code: CodeNotLeader, Leader: "127.0.0.1:7002"},
+		{Code: CodeNotLeader},
+		{Code: CodeExists, Value: 0.5},
+	}
+
+	for _, response := range responses {
+		test.NoError(WriteResponse(buffer, response))
+	}
+
+	test.Equal(4*ResponseSize+extensionHeaderSize+len("127.0.0.1:7002"), buffer.Len())
+
+	for _, response := range responses {
+		decoded, err := ReadResponse(buffer)
+		test.NoError(err)
+		test.Equal(response, decoded)
+	}
+
+	test.ErrorIs(
+		WriteResponse(buffer, Response{Code: CodeNotLeader, Leader: string(make([]byte, MaxLeader+1))}),
+		ErrMalformedPacket,
+	)
+
+	// NOT_LEADER keeps size of response packet, so client which doesn't
+	// know the frame still reads its code.
+	notLeader := []byte{byte(CodeNotLeader) | extended, 0, 0, 0, 0, 0, 0, 0, 0}
+
+	_, err = ReadResponse(bytes.NewReader(append(notLeader, ExtensionVersion, 0, 5, 'a')))
+	test.Equal(io.ErrUnexpectedEOF, err)
+
+	buffer.Write(append(append(notLeader, ExtensionVersion, 1, 0), make([]byte, 256)...))
+	WriteResponse(buffer, Response{Code: CodeOK, Value: 2})
+
+	_, err = ReadResponse(buffer)
+	test.ErrorIs(err, ErrMalformedPacket)
+
+	response, err := ReadResponse(buffer)
+	test.NoError(err)
+	test.Equal(Response{Code: CodeOK, Value: 2}, response)
+}
+
+func TestProtocol_Transfer(t *testing.T) {
+	test := assert.New(t)
+
+	buffer := &bytes.Buffer{}
+
+	request := Request{Op: OpTransfer, Key: Key{1}, Value: 30, To: Key{2}}
+
+	test.NoError(WriteRequest(buffer, request))
+	test.Equal(RequestSize+extensionHeaderSize+KeySize, buffer.Len())
+
+	data, _ := request.AppendBinary(nil)
+	test.Equal(buffer.Bytes(), data)
+
+	var unmarshaled Request
+
+	test.NoError(unmarshaled.UnmarshalBinary(data))
+	test.Equal(request, unmarshaled)
+	test.ErrorIs(unmarshaled.UnmarshalBinary(data[:len(data)-1]), ErrMalformedPacket)
+
+	decoded, err := ReadRequest(buffer)
+	test.NoError(err)
+	test.Equal(request, decoded)
+
+	// Frame of unknown version is skipped, and next request is read.
+	unknown := append([]byte{}, data...)
+	unknown[RequestSize] = ExtensionVersion + 1
+
+	buffer.Write(unknown)
+	WriteRequest(buffer, Request{Op: OpGet, Key: Key{3}})
+
+	_, err = ReadRequest(buffer)
+	test.ErrorIs(err, ErrUnsupportedVersion)
+
+	decoded, err = ReadRequest(buffer)
+	test.NoError(err)
+	test.Equal(Request{Op: OpGet, Key: Key{3}}, decoded)
+
+	_, err = ReadRequest(bytes.NewReader(data[:RequestSize+1]))
+	test.Equal(io.ErrUnexpectedEOF, err)
+
+	response := Response{Code: CodeOK, Value: 70, Extended: true, To: 130}
+
+	test.NoError(WriteResponse(buffer, response))
+	test.NoError(WriteResponse(buffer, Response{Code: CodeInsufficientFunds, Value: 10}))
+
+	decodedResponse, err := ReadResponse(buffer)
+	test.NoError(err)
+	test.Equal(response, decodedResponse)
+
+	decodedResponse, err = ReadResponse(buffer)
+	test.NoError(err)
+	test.Equal(Response{Code: CodeInsufficientFunds, Value: 10}, decodedResponse)
+
+	test.ErrorIs(WriteResponse(buffer, Response{Code: CodeNotLeader, Extended: true}), ErrMalformedPacket)
+
+	values := map[Key]float64{{1}: 100, {2}: 100}
+
+	cases := []struct {
+		Request  Request
+		Response Response
+	}{
+		{
+			Request{Op: OpTransfer, Key: Key{1}, Value: 30, To: Key{2}},
+			Response{Code: CodeOK, Value: 70, Extended: true, To: 130},
+		},
+		{
+			Request{Op: OpTransfer, Key: Key{1}, Value: 70.5, To: Key{2}},
+			Response{Code: CodeInsufficientFunds, Value: 70},
+		},
+		{
+			Request{Op: OpTransfer, Key: Key{1}, Value: 1, To: Key{3}},
+			Response{Code: CodeNotFound},
+		},
+		{
+			Request{Op: OpTransfer, Key: Key{1}, Value: -1, To: Key{2}},
+			Response{Code: CodeInvalid},
+		},
+		{
+			Request{Op: OpTransfer, Key: Key{1}, Value: 1, To: Key{1}},
+			Response{Code: CodeInvalid},
+		},
+		{
+			Request{Op: OpTransfer, Key: Key{2}, Value: 130, To: Key{1}},
+			Response{Code: CodeOK, Value: 0, Extended: true, To: 200},
+		},
+	}
+
+	for _, testcase := range cases {
+		test.Equal(testcase.Response, Apply(values, testcase.Request))
+	}
+
+	test.Equal(map[Key]float64{{1}: 200, {2}: 0}, values, "failed transfers don't change values")
+}
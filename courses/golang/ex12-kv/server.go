@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftfastlog "github.com/tidwall/raft-fastlog"
+
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/admin"
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/protocol"
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/storage"
+)
+
+var (
+	ErrClosed = errors.New("server is closed")
+
+	errTimeout = errors.New("timeout")
+
+	// errStale means that term changed since leader became ready, so
+	// leadership confirmed for read might be the one of later term.
+	errStale = errors.New("leadership changed")
+)
+
+const (
+	DefaultApplyTimeout = 5 * time.Second
+
+	// retainSnapshots is number of snapshots kept on disk.
+	retainSnapshots = 2
+
+	// transportPool is number of connections kept open to every peer.
+	transportPool = 3
+
+	transportTimeout = 10 * time.Second
+)
+
+type Config struct {
+	// ID is unique ID of node in the cluster.
+	ID string
+
+	// Address is address of node advertised to clients and other nodes.
+	// Clients and raft share the same port.
+	Address string
+
+	// Dir is directory of raft log, snapshots and state.
+	Dir string
+
+	// Peers is initial configuration of the cluster, including the node
+	// itself. Node bootstraps cluster with it unless it has state already.
+	Peers []raft.Server
+
+	// Raft is template of raft configuration, e.g. with tuned timeouts.
+	// raft.DefaultConfig is used if it's nil.
+	Raft *raft.Config
+
+	// SnapshotThreshold is number of log entries after which snapshot is
+	// taken and log is compacted, and SnapshotInterval is how often that's
+	// checked. TrailingLogs is number of entries left in log after
+	// compaction, so that follower which lags behind slightly catches up
+	// without snapshot. Values of Raft are kept where they're zero.
+	SnapshotThreshold uint64
+	SnapshotInterval  time.Duration
+	TrailingLogs      uint64
+
+	// ApplyTimeout limits time of committing single write or confirming
+	// leadership for read.
+	ApplyTimeout time.Duration
+
+	// LogOutput receives logs of raft, os.Stderr is used if it's nil.
+	LogOutput io.Writer
+
+	// Dial opens raft connections to other nodes, e.g. through network
+	// partitioned by test. TCP dialer is used if it's nil.
+	Dial func(address string, timeout time.Duration) (net.Conn, error)
+}
+
+// Server is node of the cluster. It answers reads and commits writes if it
+// is the leader, otherwise it redirects clients to the leader with
+// NOT_LEADER response. Stale reads are answered by any node. Port is shared
+// by clients, other nodes and HTTP API of package admin.
+//
+// Reads are linearizable: leader answers them only after it has applied
+// every entry committed by previous leaders and once majority of the
+// cluster confirms its leadership after read arrives, so deposed leader
+// which doesn't know about it yet never answers.
+type Server struct {
+	config Config
+
+	raft      *raft.Raft
+	fsm       *fsm
+	logs      *raftfastlog.FastLogStore
+	stream    *stream
+	transport *raft.NetworkTransport
+
+	// admin serves HTTP API of membership changes on connections handed
+	// over to adminStream.
+	admin       *http.Server
+	adminStream *stream
+
+	stop chan struct{}
+
+	reads sync.Mutex
+
+	// ready is closed once leader has applied entries of previous terms,
+	// and term is term of that leadership.
+	ready chan struct{}
+	term  uint64
+
+	// waiting reads share the next leadership confirmation.
+	waiting   []chan error
+	verifying bool
+
+	mutex       sync.Mutex
+	listeners   map[net.Listener]struct{}
+	connections map[net.Conn]struct{}
+	closed      bool
+	wait        sync.WaitGroup
+}
+
+// NewServer starts raft node, which communicates with other nodes through
+// connections accepted by Serve.
+func NewServer(config Config) (*Server, error) {
+	if config.ApplyTimeout == 0 {
+		config.ApplyTimeout = DefaultApplyTimeout
+	}
+
+	if config.LogOutput == nil {
+		config.LogOutput = os.Stderr
+	}
+
+	if config.Dial == nil {
+		config.Dial = dialTCP
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	server := &Server{
+		config:      config,
+		stop:        make(chan struct{}),
+		ready:       make(chan struct{}),
+		listeners:   map[net.Listener]struct{}{},
+		connections: map[net.Conn]struct{}{},
+	}
+
+	if err := server.start(); err != nil {
+		server.release()
+
+		return nil, err
+	}
+
+	return server, nil
+}
+
+func (server *Server) start() error {
+	store, err := storage.Open(filepath.Join(server.config.Dir, "state"))
+	if err != nil {
+		return err
+	}
+
+	server.fsm, err = newFSM(store)
+	if err != nil {
+		store.Close()
+
+		return err
+	}
+
+	server.logs, err = raftfastlog.NewFastLogStore(
+		filepath.Join(server.config.Dir, "raft.log"),
+		raftfastlog.High,
+		server.config.LogOutput,
+	)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(
+		server.config.Dir,
+		retainSnapshots,
+		server.config.LogOutput,
+	)
+	if err != nil {
+		return err
+	}
+
+	server.stream, err = newStream(server.config.Address, server.config.Dial)
+	if err != nil {
+		return err
+	}
+
+	server.transport = raft.NewNetworkTransport(
+		server.stream,
+		transportPool,
+		transportTimeout,
+		server.config.LogOutput,
+	)
+
+	config := raft.DefaultConfig()
+	if server.config.Raft != nil {
+		copied := *server.config.Raft
+		config = &copied
+	}
+
+	if server.config.SnapshotThreshold != 0 {
+		config.SnapshotThreshold = server.config.SnapshotThreshold
+	}
+
+	if server.config.SnapshotInterval != 0 {
+		config.SnapshotInterval = server.config.SnapshotInterval
+	}
+
+	if server.config.TrailingLogs != 0 {
+		config.TrailingLogs = server.config.TrailingLogs
+	}
+
+	config.LocalID = raft.ServerID(server.config.ID)
+	config.LogOutput = server.config.LogOutput
+
+	// State is restored from disk, which is never older than snapshot.
+	config.NoSnapshotRestoreOnStart = true
+
+	server.raft, err = raft.NewRaft(
+		config,
+		server.fsm,
+		server.logs,
+		server.logs,
+		snapshots,
+		server.transport,
+	)
+	if err != nil {
+		return err
+	}
+
+	go server.lead(server.raft.LeaderCh())
+
+	server.adminStream, err = newStream(server.config.Address, nil)
+	if err != nil {
+		return err
+	}
+
+	server.admin = &http.Server{
+		Handler:  admin.NewHandler(server.raft, server.config.ID, server.config.Address),
+		ErrorLog: log.New(server.config.LogOutput, "[admin] ", log.LstdFlags),
+	}
+
+	go server.admin.Serve(server.adminStream)
+
+	if len(server.config.Peers) == 0 {
+		return nil
+	}
+
+	err = server.raft.BootstrapCluster(raft.Configuration{Servers: server.config.Peers}).Error()
+	if err != nil && err != raft.ErrCantBootstrap {
+		return err
+	}
+
+	return nil
+}
+
+// release stops raft and closes its stores.
+func (server *Server) release() {
+	close(server.stop)
+
+	if server.admin != nil {
+		server.admin.Close()
+	}
+
+	if server.raft != nil {
+		server.raft.Shutdown().Error()
+	}
+
+	if server.transport != nil {
+		server.transport.Close()
+	}
+
+	if server.logs != nil {
+		server.logs.Close()
+	}
+
+	if server.fsm != nil {
+		server.fsm.close()
+	}
+}
+
+// Serve accepts connections of clients and other nodes until listener is
+// closed or server is closed, in which case it returns ErrClosed.
+func (server *Server) Serve(listener net.Listener) error {
+	server.mutex.Lock()
+
+	if server.closed {
+		server.mutex.Unlock()
+
+		return ErrClosed
+	}
+
+	server.listeners[listener] = struct{}{}
+
+	server.mutex.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			server.mutex.Lock()
+			defer server.mutex.Unlock()
+
+			delete(server.listeners, listener)
+
+			if server.closed {
+				return ErrClosed
+			}
+
+			return err
+		}
+
+		if !server.track(conn) {
+			conn.Close()
+
+			return ErrClosed
+		}
+
+		go server.handle(conn)
+	}
+}
+
+// Close stops serving, closes every client connection and shuts raft node
+// down.
+func (server *Server) Close() error {
+	server.mutex.Lock()
+
+	if server.closed {
+		server.mutex.Unlock()
+
+		return ErrClosed
+	}
+
+	server.closed = true
+
+	for listener := range server.listeners {
+		listener.Close()
+	}
+
+	for conn := range server.connections {
+		conn.Close()
+	}
+
+	server.mutex.Unlock()
+
+	// Raft connections are closed by the stream, which transport doesn't do.
+	server.stream.Close()
+	server.adminStream.Close()
+
+	server.wait.Wait()
+	server.release()
+
+	return nil
+}
+
+// Leader returns address of the current leader, or empty string if it's
+// unknown.
+func (server *Server) Leader() string {
+	address, _ := server.raft.LeaderWithID()
+
+	return string(address)
+}
+
+func (server *Server) track(conn net.Conn) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.closed {
+		return false
+	}
+
+	server.connections[conn] = struct{}{}
+	server.wait.Add(1)
+
+	return true
+}
+
+// handover stops tracking connection handed over to raft without closing
+// it.
+func (server *Server) handover(conn net.Conn) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	delete(server.connections, conn)
+}
+
+func (server *Server) untrack(conn net.Conn) error {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	delete(server.connections, conn)
+
+	return conn.Close()
+}
+
+// handle serves single connection: raft connections are handed over to
+// transport, and client requests are executed one by one. Responses are
+// flushed once there are no more pipelined requests.
+func (server *Server) handle(conn net.Conn) {
+	defer server.wait.Done()
+
+	reader := bufio.NewReader(conn)
+
+	marker, err := reader.Peek(1)
+	if err == nil && marker[0] == raftMarker {
+		reader.Discard(1)
+
+		server.handover(conn)
+		server.stream.push(conn, reader)
+
+		return
+	}
+
+	// HTTP request starts with method in capital letters, which are never
+	// valid operations.
+	if err == nil && marker[0] >= 'A' && marker[0] <= 'Z' {
+		server.handover(conn)
+		server.adminStream.push(conn, reader)
+
+		return
+	}
+
+	defer server.untrack(conn)
+
+	writer := bufio.NewWriter(conn)
+
+	for {
+		request, err := protocol.ReadRequest(reader)
+		if err != nil && err != protocol.ErrUnsupportedVersion && err != protocol.ErrMalformedPacket {
+			return
+		}
+
+		// Extension frame which can't be decoded is skipped entirely, so
+		// request is answered as invalid.
+		response := protocol.Response{Code: protocol.CodeInvalid}
+		if err == nil {
+			response = server.execute(request)
+		}
+
+		if protocol.WriteResponse(writer, response) != nil {
+			return
+		}
+
+		if reader.Buffered() == 0 && writer.Flush() != nil {
+			return
+		}
+	}
+}
+
+func (server *Server) execute(request protocol.Request) protocol.Response {
+	switch {
+	case request.Op == protocol.OpGet:
+		return server.get(request.Key)
+	case request.Op == protocol.OpGetStale:
+		return server.read(request.Key)
+	case request.Op.Write():
+		return server.apply(request)
+	}
+
+	return protocol.Response{Code: protocol.CodeInvalid}
+}
+
+// get answers linearizable read. Leader which can't confirm its leadership
+// in time answers UNAVAILABLE, so client retries.
+func (server *Server) get(key protocol.Key) protocol.Response {
+	if server.raft.State() != raft.Leader {
+		return server.redirect()
+	}
+
+	if err := server.confirm(); err != nil {
+		if err == errTimeout || err == errStale {
+			return protocol.Response{Code: protocol.CodeUnavailable}
+		}
+
+		return server.redirect()
+	}
+
+	return server.read(key)
+}
+
+// read answers from local state.
+func (server *Server) read(key protocol.Key) protocol.Response {
+	value, ok := server.fsm.get(key)
+	if !ok {
+		return protocol.Response{Code: protocol.CodeNotFound}
+	}
+
+	return protocol.Response{Code: protocol.CodeOK, Value: value}
+}
+
+// lead marks leader ready once it commits barrier, which makes it apply
+// every entry committed by previous leaders. Readiness belongs to the term
+// in which barrier was committed, as notifications of leadership changes
+// may be coalesced or processed late.
+func (server *Server) lead(leadership <-chan bool) {
+	for {
+		select {
+		case leader := <-leadership:
+			ready := make(chan struct{})
+			term := server.raft.CurrentTerm()
+
+			server.reads.Lock()
+			server.ready = ready
+			server.term = term
+			server.reads.Unlock()
+
+			if leader {
+				go server.prepare(ready, term)
+			}
+		case <-server.stop:
+			return
+		}
+	}
+}
+
+// prepare closes ready once barrier is committed by leader of specified
+// term.
+func (server *Server) prepare(ready chan struct{}, term uint64) {
+	if server.raft.Barrier(0).Error() != nil {
+		return
+	}
+
+	if server.raft.State() == raft.Leader && server.raft.CurrentTerm() == term {
+		close(ready)
+	}
+}
+
+// confirm waits until leader is ready and majority of the cluster confirms
+// its leadership after the call, and checks that both happened in the same
+// term. Reads waiting at the same time share single round of heartbeats.
+func (server *Server) confirm() error {
+	timer := time.NewTimer(server.config.ApplyTimeout)
+	defer timer.Stop()
+
+	server.reads.Lock()
+	ready, term := server.ready, server.term
+	server.reads.Unlock()
+
+	select {
+	case <-ready:
+	case <-timer.C:
+		return errTimeout
+	}
+
+	wait := make(chan error, 1)
+
+	server.reads.Lock()
+
+	server.waiting = append(server.waiting, wait)
+	start := !server.verifying
+	server.verifying = true
+
+	server.reads.Unlock()
+
+	if start {
+		go server.verify()
+	}
+
+	select {
+	case err := <-wait:
+		if err == nil && server.raft.CurrentTerm() != term {
+			return errStale
+		}
+
+		return err
+	case <-timer.C:
+		return errTimeout
+	}
+}
+
+// verify confirms leadership for waiting reads until there are none. Round
+// started before read arrived doesn't count for it, so reads arrived during
+// round wait for the next one.
+func (server *Server) verify() {
+	for {
+		server.reads.Lock()
+
+		waiting := server.waiting
+		server.waiting = nil
+
+		if len(waiting) == 0 {
+			server.verifying = false
+			server.reads.Unlock()
+
+			return
+		}
+
+		server.reads.Unlock()
+
+		err := server.raft.VerifyLeader().Error()
+
+		for _, wait := range waiting {
+			wait <- err
+		}
+	}
+}
+
+// apply commits write through raft and returns its result. Write which
+// was rejected before it reached the log is redirected, while write which
+// may have been committed is reported as unavailable, so that client
+// doesn't repeat increment blindly.
+func (server *Server) apply(request protocol.Request) protocol.Response {
+	data, _ := request.AppendBinary(nil)
+
+	future := server.raft.Apply(data, server.config.ApplyTimeout)
+
+	if err := future.Error(); err != nil {
+		if err == raft.ErrNotLeader || err == raft.ErrLeadershipTransferInProgress {
+			return server.redirect()
+		}
+
+		return protocol.Response{Code: protocol.CodeUnavailable}
+	}
+
+	response, ok := future.Response().(protocol.Response)
+	if !ok {
+		return protocol.Response{Code: protocol.CodeUnavailable}
+	}
+
+	return response
+}
+
+func (server *Server) redirect() protocol.Response {
+	return protocol.Response{
+		Code:   protocol.CodeNotLeader,
+		Leader: server.Leader(),
+	}
+}
@@ -0,0 +1,821 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/protocol"
+)
+
+var (
+	ErrClosed    = errors.New("store is closed")
+	ErrCorrupted = errors.New("segment is corrupted")
+)
+
+const (
+	DefaultSegmentSize     = 64 << 20
+	DefaultCompactionRatio = 0.5
+)
+
+// Option configures Engine.
+type Option func(engine *Engine)
+
+// WithSegmentSize sets size after which active segment is sealed and new
+// one is started. Offsets of records are 32-bit, so it's limited to 4 GiB.
+func WithSegmentSize(size int64) Option {
+	return func(engine *Engine) {
+		engine.segmentSize = min(max(size, headerSize+RecordSize), math.MaxUint32-RecordSize)
+	}
+}
+
+// WithSync enables fsync, which is on by default. Without it synced writes
+// survive crash of the process, but not of the machine.
+func WithSync(enabled bool) Option {
+	return func(engine *Engine) {
+		engine.sync = enabled
+	}
+}
+
+// WithCompactionRatio sets share of dead records in sealed segments which
+// triggers compaction.
+func WithCompactionRatio(ratio float64) Option {
+	return func(engine *Engine) {
+		engine.ratio = ratio
+	}
+}
+
+// location is position of record in the log.
+type location struct {
+	segment uint32
+	offset  uint32
+}
+
+// Engine is Store for fixed-size records: log index, 16-byte key and 8-byte
+// value. Records are appended to the active segment, and the newest record
+// of each key is found through in-memory hash index of locations, which is
+// rebuilt by reading segments in order on open. Index doesn't hold values,
+// so state isn't kept in memory twice by the engine and the FSM. Records of
+// single entry are flagged as group, and torn group or record left at the
+// end of the active segment by crash is truncated.
+//
+// Writes are buffered and written to files by Sync, which holds lock only
+// while writing to files and flushes them without it, so writes made during
+// flush are committed together by the next Sync. Sealed segments are
+// compacted in background once dead records make up configured ratio of
+// them.
+type Engine struct {
+	dir         string
+	segmentSize int64
+	sync        bool
+	ratio       float64
+
+	mutex sync.Mutex
+	index map[protocol.Key]location
+
+	// segments are ordered by ID, the last one is active.
+	segments []*segment
+
+	// created is set when segment is created, so directory is flushed by
+	// the next sync.
+	created bool
+
+	applied uint64
+
+	// written counts writes, and synced is number of them which are
+	// durable. Sync is done by single caller at a time, others wait for it.
+	written uint64
+	synced  uint64
+	syncing bool
+	done    *sync.Cond
+
+	// err is failure of writing or flushing, after which state on disk is
+	// unknown, so the engine fails every write.
+	err    error
+	closed bool
+
+	// maintenance serializes compaction and replace.
+	maintenance sync.Mutex
+
+	wake chan struct{}
+	stop chan struct{}
+	wait sync.WaitGroup
+}
+
+// Open opens log in directory, creating it if needed, and recovers state
+// from it.
+func Open(dir string, options ...Option) (*Engine, error) {
+	engine := &Engine{
+		dir:         dir,
+		segmentSize: DefaultSegmentSize,
+		sync:        true,
+		ratio:       DefaultCompactionRatio,
+		index:       map[protocol.Key]location{},
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+
+	engine.done = sync.NewCond(&engine.mutex)
+
+	for _, option := range options {
+		option(engine)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := engine.recover(); err != nil {
+		for _, segment := range engine.segments {
+			segment.file.Close()
+		}
+
+		return nil, err
+	}
+
+	engine.wait.Add(1)
+
+	go engine.compactor()
+
+	return engine, nil
+}
+
+// recover reads segments following the last base one and truncates torn
+// record or entry at the end of the active one.
+func (engine *Engine) recover() error {
+	files, err := os.ReadDir(engine.dir)
+	if err != nil {
+		return err
+	}
+
+	var ids []uint32
+
+	for _, file := range files {
+		name := file.Name()
+
+		if strings.HasSuffix(name, temporarySuffix) {
+			if err := os.Remove(filepath.Join(engine.dir, name)); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 32)
+		if err != nil || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		ids = append(ids, uint32(id))
+	}
+
+	slices.Sort(ids)
+
+	for i, id := range ids {
+		file, err := os.OpenFile(segmentPath(engine.dir, id), os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+
+		flags, ok := readHeader(file)
+
+		switch {
+		case !ok && i == len(ids)-1:
+			// Crash right after segment was created.
+			file.Close()
+
+			if err := os.Remove(segmentPath(engine.dir, id)); err != nil {
+				return err
+			}
+
+			continue
+		case !ok:
+			file.Close()
+
+			return ErrCorrupted
+		case flags&base != 0:
+			if err := engine.remove(engine.segments); err != nil {
+				file.Close()
+
+				return err
+			}
+
+			engine.segments = nil
+		}
+
+		engine.segments = append(engine.segments, &segment{id: id, file: file})
+	}
+
+	for i, segment := range engine.segments {
+		size, torn, err := engine.replay(segment)
+		if err != nil {
+			return err
+		}
+
+		if !torn {
+			continue
+		}
+
+		// Sealed segments were flushed before the next one was started,
+		// so only the active one can be torn by crash.
+		if i < len(engine.segments)-1 {
+			return ErrCorrupted
+		}
+
+		if err := segment.file.Truncate(size); err != nil {
+			return err
+		}
+
+		if err := segment.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if len(engine.segments) == 0 {
+		if _, err := engine.create(1, 0); err != nil {
+			return err
+		}
+	}
+
+	active := engine.segments[len(engine.segments)-1]
+
+	if _, err := active.file.Seek(active.size, 0); err != nil {
+		return err
+	}
+
+	return syncDir(engine.dir)
+}
+
+// replay indexes records of segment. It returns size of segment up to the
+// last complete entry, and whether anything follows it.
+func (engine *Engine) replay(segment *segment) (int64, bool, error) {
+	type record struct {
+		key protocol.Key
+		location
+	}
+
+	var group []record
+
+	size := int64(headerSize)
+
+	err := scan(segment, func(offset int64, index uint64, key protocol.Key, value float64) bool {
+		group = append(group, record{key: key, location: location{segment.id, uint32(offset)}})
+
+		if index&more != 0 {
+			return true
+		}
+
+		for _, record := range group {
+			engine.put(record.key, record.location)
+		}
+
+		group = group[:0]
+		engine.applied = max(engine.applied, index)
+		size = offset + RecordSize
+
+		return true
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	info, err := segment.file.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+
+	segment.size = size
+
+	return size, info.Size() != size, nil
+}
+
+// put indexes newest record of key.
+func (engine *Engine) put(key protocol.Key, record location) {
+	if previous, ok := engine.index[key]; ok {
+		if segment := engine.segment(previous.segment); segment != nil {
+			segment.dead++
+		}
+	}
+
+	engine.index[key] = record
+
+	if segment := engine.segment(record.segment); segment != nil {
+		segment.records++
+	}
+}
+
+func (engine *Engine) segment(id uint32) *segment {
+	i, ok := slices.BinarySearchFunc(engine.segments, id, func(segment *segment, id uint32) int {
+		return int(int64(segment.id) - int64(id))
+	})
+	if !ok {
+		return nil
+	}
+
+	return engine.segments[i]
+}
+
+// create creates segment with specified ID and flags and appends it to
+// segments. Header is written on the next sync.
+func (engine *Engine) create(id uint32, flags byte) (*segment, error) {
+	file, err := os.OpenFile(segmentPath(engine.dir, id), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	segment := &segment{id: id, file: file, pending: appendHeader(nil, flags)}
+
+	engine.segments = append(engine.segments, segment)
+	engine.created = true
+
+	return segment, nil
+}
+
+// remove closes and deletes segments.
+func (engine *Engine) remove(segments []*segment) error {
+	for _, segment := range segments {
+		segment.file.Close()
+
+		if err := os.Remove(segmentPath(engine.dir, segment.id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (engine *Engine) active() *segment {
+	return engine.segments[len(engine.segments)-1]
+}
+
+// Load reads segments in order and picks records which index points to.
+// Writes wait until it's done, so it's meant to be called on start.
+func (engine *Engine) Load() (map[protocol.Key]float64, uint64, error) {
+	engine.maintenance.Lock()
+	defer engine.maintenance.Unlock()
+
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	values := make(map[protocol.Key]float64, len(engine.index))
+
+	for _, segment := range engine.segments {
+		err := scan(segment, func(offset int64, index uint64, key protocol.Key, value float64) bool {
+			if engine.index[key] == (location{segment.id, uint32(offset)}) {
+				values[key] = value
+			}
+
+			return true
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return values, engine.applied, nil
+}
+
+// Get reads the newest value of key, including one which isn't synced yet.
+func (engine *Engine) Get(key protocol.Key) (float64, bool, error) {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	record, ok := engine.index[key]
+	if !ok {
+		return 0, false, nil
+	}
+
+	segment := engine.segment(record.segment)
+	offset := int64(record.offset)
+
+	var data []byte
+
+	if offset >= segment.size {
+		data = segment.pending[offset-segment.size:]
+	} else {
+		data = make([]byte, RecordSize)
+
+		if _, err := segment.file.ReadAt(data, offset); err != nil {
+			return 0, false, err
+		}
+	}
+
+	_, _, value, ok := decodeRecord(data)
+	if !ok {
+		return 0, false, ErrCorrupted
+	}
+
+	return value, true, nil
+}
+
+// Write appends records of entry to the active segment, sealing it first
+// if it's full, so entry never spans segments.
+func (engine *Engine) Write(index uint64, changes ...Change) error {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	active, err := engine.reserve(len(changes) * RecordSize)
+	if err != nil {
+		return err
+	}
+
+	for i, change := range changes {
+		flagged := index
+		if i < len(changes)-1 {
+			flagged |= more
+		}
+
+		offset := active.size + int64(len(active.pending))
+
+		active.pending = appendRecord(active.pending, flagged, change.Key, change.Value)
+
+		engine.put(change.Key, location{active.id, uint32(offset)})
+	}
+
+	engine.applied = max(engine.applied, index)
+	engine.written++
+
+	return nil
+}
+
+// reserve returns active segment with room for records of entry. Full
+// segment is made durable before the next one is started, so crash can tear
+// only the active segment. It's called with lock held and may release it.
+func (engine *Engine) reserve(size int) (*segment, error) {
+	for {
+		if engine.closed {
+			return nil, ErrClosed
+		}
+
+		if engine.err != nil {
+			return nil, engine.err
+		}
+
+		active := engine.active()
+		used := active.size + int64(len(active.pending))
+
+		if used == headerSize || used+int64(size) <= engine.segmentSize {
+			return active, nil
+		}
+
+		if engine.synced < engine.written {
+			engine.commit()
+
+			continue
+		}
+
+		return engine.create(active.id+1, 0)
+	}
+}
+
+// Sync writes pending records and flushes them. Callers arriving during
+// flush wait for it and then flush everything written meanwhile at once.
+func (engine *Engine) Sync() error {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	return engine.commit()
+}
+
+// commit waits until every write made before it is durable. It's called
+// with lock held.
+func (engine *Engine) commit() error {
+	target := engine.written
+
+	for engine.synced < target && engine.err == nil {
+		if engine.syncing {
+			engine.done.Wait()
+
+			continue
+		}
+
+		engine.flush()
+	}
+
+	return engine.err
+}
+
+// flush is single round of group commit. It's called with lock held and
+// releases it while files are flushed.
+func (engine *Engine) flush() {
+	engine.syncing = true
+
+	target := engine.written
+
+	var dirty []*segment
+
+	for _, segment := range engine.segments {
+		if len(segment.pending) == 0 {
+			continue
+		}
+
+		if _, err := segment.file.Write(segment.pending); err != nil {
+			engine.err = err
+
+			break
+		}
+
+		segment.size += int64(len(segment.pending))
+		segment.pending = segment.pending[:0]
+
+		dirty = append(dirty, segment)
+	}
+
+	created := engine.created
+	engine.created = false
+
+	var err error
+
+	if engine.sync && engine.err == nil {
+		engine.mutex.Unlock()
+
+		for _, segment := range dirty {
+			if err = segment.file.Sync(); err != nil {
+				break
+			}
+		}
+
+		if err == nil && created {
+			err = syncDir(engine.dir)
+		}
+
+		engine.mutex.Lock()
+	}
+
+	if err != nil && engine.err == nil {
+		engine.err = err
+	}
+
+	if engine.err == nil {
+		engine.synced = target
+	}
+
+	engine.syncing = false
+	engine.done.Broadcast()
+
+	engine.trigger()
+}
+
+// trigger wakes compactor if dead records make up configured ratio of
+// sealed segments.
+func (engine *Engine) trigger() {
+	records, dead := 0, 0
+
+	for _, segment := range engine.segments[:len(engine.segments)-1] {
+		records += segment.records
+		dead += segment.dead
+	}
+
+	if dead == 0 || float64(dead) < engine.ratio*float64(records) {
+		return
+	}
+
+	select {
+	case engine.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (engine *Engine) compactor() {
+	defer engine.wait.Done()
+
+	for {
+		select {
+		case <-engine.wake:
+			// Failed compaction leaves log as it was, and it's retried
+			// on the next trigger.
+			engine.Compact()
+		case <-engine.stop:
+			return
+		}
+	}
+}
+
+// Compact rewrites live records of sealed segments into single segment,
+// which takes ID of the newest of them, so log replays the same way if
+// crash leaves older ones behind.
+func (engine *Engine) Compact() error {
+	engine.maintenance.Lock()
+	defer engine.maintenance.Unlock()
+
+	// Segment sealed by the latest write may still have pending records,
+	// so it's left for the next compaction.
+	var sealed []*segment
+
+	engine.mutex.Lock()
+
+	for _, segment := range engine.segments[:len(engine.segments)-1] {
+		if len(segment.pending) > 0 {
+			break
+		}
+
+		sealed = append(sealed, segment)
+	}
+
+	engine.mutex.Unlock()
+
+	if len(sealed) == 0 {
+		return nil
+	}
+
+	target := sealed[len(sealed)-1].id
+	path := segmentPath(engine.dir, target)
+
+	file, err := os.Create(path + temporarySuffix)
+	if err != nil {
+		return err
+	}
+
+	type move struct {
+		key  protocol.Key
+		from location
+		to   uint32
+	}
+
+	var moves []move
+
+	writer := bufio.NewWriter(file)
+	writer.Write(appendHeader(nil, 0))
+
+	offset := int64(headerSize)
+	buffer := make([]byte, 0, RecordSize)
+
+	for _, segment := range sealed {
+		err = scan(segment, func(at int64, index uint64, key protocol.Key, value float64) bool {
+			from := location{segment.id, uint32(at)}
+
+			engine.mutex.Lock()
+			current, ok := engine.index[key]
+			engine.mutex.Unlock()
+
+			if !ok || current != from {
+				return true
+			}
+
+			// Sealed entries are complete, so records don't need to
+			// stay grouped. Writer keeps its error until flush.
+			writer.Write(appendRecord(buffer[:0], index&^more, key, value))
+
+			moves = append(moves, move{key: key, from: from, to: uint32(offset)})
+			offset += RecordSize
+
+			return true
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = writer.Flush()
+	}
+
+	if err == nil && engine.sync {
+		err = file.Sync()
+	}
+
+	if err == nil {
+		err = os.Rename(path+temporarySuffix, path)
+	}
+
+	if err == nil {
+		err = syncDir(engine.dir)
+	}
+
+	if err != nil {
+		file.Close()
+		os.Remove(path + temporarySuffix)
+
+		return err
+	}
+
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	compacted := &segment{id: target, file: file, size: offset, records: len(moves)}
+
+	for _, move := range moves {
+		if engine.index[move.key] != move.from {
+			compacted.dead++
+
+			continue
+		}
+
+		engine.index[move.key] = location{target, move.to}
+	}
+
+	for _, segment := range sealed {
+		segment.file.Close()
+
+		if segment.id != target {
+			if err := os.Remove(segmentPath(engine.dir, segment.id)); err != nil {
+				return err
+			}
+		}
+	}
+
+	engine.segments = append([]*segment{compacted}, engine.segments[len(sealed):]...)
+
+	return syncDir(engine.dir)
+}
+
+// Replace writes values as base segment and switches to it, so crash leaves
+// either old log or the new one.
+func (engine *Engine) Replace(index uint64, values map[protocol.Key]float64) error {
+	engine.maintenance.Lock()
+	defer engine.maintenance.Unlock()
+
+	engine.mutex.Lock()
+	id := engine.active().id + 1
+	engine.mutex.Unlock()
+
+	path := segmentPath(engine.dir, id)
+
+	file, err := os.Create(path + temporarySuffix)
+	if err != nil {
+		return err
+	}
+
+	index &^= more
+
+	buffer := appendHeader(make([]byte, 0, headerSize+len(values)*RecordSize), base)
+	replaced := make(map[protocol.Key]location, len(values))
+
+	for key, value := range values {
+		replaced[key] = location{id, uint32(len(buffer))}
+		buffer = appendRecord(buffer, index, key, value)
+	}
+
+	_, err = file.Write(buffer)
+
+	if err == nil && engine.sync {
+		err = file.Sync()
+	}
+
+	if err == nil {
+		err = os.Rename(path+temporarySuffix, path)
+	}
+
+	if err == nil {
+		err = syncDir(engine.dir)
+	}
+
+	if err != nil {
+		file.Close()
+		os.Remove(path + temporarySuffix)
+
+		return err
+	}
+
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	if err := engine.remove(engine.segments); err != nil {
+		engine.err = err
+
+		return err
+	}
+
+	engine.segments = []*segment{{id: id, file: file, size: int64(len(buffer)), records: len(values)}}
+	engine.index = replaced
+	engine.applied = index
+	engine.synced = engine.written
+
+	return syncDir(engine.dir)
+}
+
+// Close stops compaction, syncs pending writes and closes segments. Writes
+// are refused as soon as it's called.
+func (engine *Engine) Close() error {
+	engine.mutex.Lock()
+
+	if engine.closed {
+		engine.mutex.Unlock()
+
+		return ErrClosed
+	}
+
+	engine.closed = true
+
+	engine.mutex.Unlock()
+
+	close(engine.stop)
+	engine.wait.Wait()
+
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	err := engine.commit()
+
+	for _, segment := range engine.segments {
+		segment.file.Close()
+	}
+
+	return err
+}
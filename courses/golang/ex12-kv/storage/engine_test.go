@@ -0,0 +1,340 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/protocol"
+)
+
+func TestEngine(t *testing.T) {
+	test := assert.New(t)
+
+	dir := t.TempDir()
+
+	engine, err := Open(dir)
+	if !test.NoError(err) {
+		return
+	}
+
+	test.NoError(engine.Write(1, Change{Key: protocol.Key{1}, Value: 1}))
+	test.NoError(engine.Write(2, Change{Key: protocol.Key{2}, Value: 2}, Change{Key: protocol.Key{1}, Value: 3}))
+
+	value, ok, err := engine.Get(protocol.Key{1})
+	test.NoError(err)
+	test.True(ok)
+	test.Equal(3.0, value, "unsynced write is indexed")
+
+	test.NoError(engine.Sync())
+
+	// Concurrent closes don't race, and the engine is closed once.
+	var (
+		wait   sync.WaitGroup
+		closed atomic.Int32
+	)
+
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+
+		go func() {
+			defer wait.Done()
+
+			if engine.Close() == nil {
+				closed.Add(1)
+			}
+		}()
+	}
+
+	wait.Wait()
+
+	test.Equal(int32(1), closed.Load())
+	test.ErrorIs(engine.Write(3, Change{Key: protocol.Key{1}, Value: 4}), ErrClosed)
+
+	engine, err = Open(dir)
+	if !test.NoError(err) {
+		return
+	}
+
+	defer engine.Close()
+
+	values, applied, err := engine.Load()
+	test.NoError(err)
+	test.Equal(map[protocol.Key]float64{{1}: 3, {2}: 2}, values)
+	test.Equal(uint64(2), applied)
+}
+
+func TestEngine_Torn(t *testing.T) {
+	test := assert.New(t)
+
+	dir := t.TempDir()
+
+	engine, err := Open(dir)
+	if !test.NoError(err) {
+		return
+	}
+
+	test.NoError(engine.Write(1, Change{Key: protocol.Key{1}, Value: 100}, Change{Key: protocol.Key{2}, Value: 0}))
+	test.NoError(engine.Write(2, Change{Key: protocol.Key{1}, Value: 60}, Change{Key: protocol.Key{2}, Value: 40}))
+	test.NoError(engine.Close())
+
+	path := segmentPath(dir, 1)
+
+	info, err := os.Stat(path)
+	if !test.NoError(err) {
+		return
+	}
+
+	// Entry loses its last record.
+	test.NoError(os.Truncate(path, info.Size()-RecordSize))
+
+	engine, err = Open(dir)
+	if !test.NoError(err) {
+		return
+	}
+
+	values, applied, err := engine.Load()
+	test.NoError(err)
+	test.Equal(map[protocol.Key]float64{{1}: 100, {2}: 0}, values)
+	test.Equal(uint64(1), applied)
+
+	test.NoError(engine.Write(2, Change{Key: protocol.Key{1}, Value: 70}))
+	test.NoError(engine.Close())
+
+	// Damaged record at the end of the active segment is torn write.
+	record := appendRecord(nil, 3, protocol.Key{2}, 1)
+	record[8] ^= 1
+
+	test.NoError(appendFile(path, record))
+
+	engine, err = Open(dir)
+	if !test.NoError(err) {
+		return
+	}
+
+	values, applied, err = engine.Load()
+	test.NoError(err)
+	test.Equal(map[protocol.Key]float64{{1}: 70, {2}: 0}, values)
+	test.Equal(uint64(2), applied)
+
+	test.NoError(engine.Close())
+
+	// Sealed segment was flushed before the next one was started, so its
+	// damage isn't truncated.
+	test.NoError(appendFile(path, record))
+	test.NoError(os.WriteFile(segmentPath(dir, 2), appendRecord(appendHeader(nil, 0), 4, protocol.Key{2}, 2), 0o644))
+
+	_, err = Open(dir)
+	test.ErrorIs(err, ErrCorrupted)
+	test.FileExists(segmentPath(dir, 2))
+}
+
+func appendFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	_, err = file.Write(data)
+
+	return err
+}
+
+func TestEngine_Compaction(t *testing.T) {
+	test := assert.New(t)
+
+	dir := t.TempDir()
+
+	engine, err := Open(dir, WithSegmentSize(headerSize+10*RecordSize), WithCompactionRatio(2))
+	if !test.NoError(err) {
+		return
+	}
+
+	test.NoError(engine.Write(1, Change{Key: protocol.Key{9}, Value: 9}))
+
+	for i := uint64(2); i <= 100; i++ {
+		test.NoError(engine.Write(i, Change{Key: protocol.Key{byte(i % 3)}, Value: float64(i)}))
+		test.NoError(engine.Sync())
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	test.Len(segments, 10)
+
+	test.NoError(engine.Compact())
+
+	segments, _ = filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	test.Len(segments, 2, "sealed segments are merged")
+
+	info, err := os.Stat(segmentPath(dir, 9))
+	if test.NoError(err) {
+		test.Equal(int64(headerSize+RecordSize), info.Size(), "only live records are kept")
+	}
+
+	test.NoError(engine.Close())
+
+	engine, err = Open(dir, WithSegmentSize(headerSize+10*RecordSize))
+	if !test.NoError(err) {
+		return
+	}
+
+	defer engine.Close()
+
+	values, applied, err := engine.Load()
+	test.NoError(err)
+	test.Equal(map[protocol.Key]float64{{0}: 99, {1}: 100, {2}: 98, {9}: 9}, values)
+	test.Equal(uint64(100), applied)
+
+	// Compaction runs in background once half of sealed records are dead.
+	for i := uint64(101); i <= 200; i++ {
+		test.NoError(engine.Write(i, Change{Key: protocol.Key{byte(i % 3)}, Value: float64(i)}))
+		test.NoError(engine.Sync())
+	}
+
+	test.Eventually(func() bool {
+		segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+
+		return len(segments) < 5
+	}, 5*time.Second, 10*time.Millisecond)
+
+	value, _, err := engine.Get(protocol.Key{1})
+	test.NoError(err)
+	test.Equal(199.0, value)
+}
+
+func TestEngine_Replace(t *testing.T) {
+	test := assert.New(t)
+
+	dir := t.TempDir()
+
+	engine, err := Open(dir, WithSegmentSize(headerSize+2*RecordSize))
+	if !test.NoError(err) {
+		return
+	}
+
+	for i := uint64(1); i <= 5; i++ {
+		test.NoError(engine.Write(i, Change{Key: protocol.Key{byte(i)}, Value: float64(i)}))
+	}
+
+	test.NoError(engine.Sync())
+	test.NoError(engine.Replace(10, map[protocol.Key]float64{{1}: 10, {7}: 7}))
+	test.NoError(engine.Write(11, Change{Key: protocol.Key{7}, Value: 8}))
+	test.NoError(engine.Close())
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	test.Len(segments, 2, "base segment and one after it")
+
+	// Crash after base segment was written leaves older segments behind.
+	test.NoError(os.WriteFile(segmentPath(dir, 1), appendRecord(appendHeader(nil, 0), 1, protocol.Key{2}, 2), 0o644))
+
+	engine, err = Open(dir)
+	if !test.NoError(err) {
+		return
+	}
+
+	defer engine.Close()
+
+	values, applied, err := engine.Load()
+	test.NoError(err)
+	test.Equal(map[protocol.Key]float64{{1}: 10, {7}: 8}, values)
+	test.Equal(uint64(11), applied)
+
+	test.NoFileExists(segmentPath(dir, 1))
+}
+
+func TestEngine_GroupCommit(t *testing.T) {
+	test := assert.New(t)
+
+	dir := t.TempDir()
+
+	engine, err := Open(dir)
+	if !test.NoError(err) {
+		return
+	}
+
+	var (
+		mutex sync.Mutex
+		index uint64
+		wait  sync.WaitGroup
+	)
+
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+
+		go func() {
+			defer wait.Done()
+
+			for j := 0; j < 50; j++ {
+				mutex.Lock()
+				index++
+				test.NoError(engine.Write(index, Change{Key: protocol.Key{byte(i)}, Value: float64(j)}))
+				mutex.Unlock()
+
+				test.NoError(engine.Sync())
+			}
+		}()
+	}
+
+	wait.Wait()
+
+	test.NoError(engine.Close())
+
+	engine, err = Open(dir)
+	if !test.NoError(err) {
+		return
+	}
+
+	defer engine.Close()
+
+	values, applied, err := engine.Load()
+	test.NoError(err)
+	test.Len(values, 8)
+	test.Equal(49.0, values[protocol.Key{3}])
+	test.Equal(uint64(400), applied)
+}
+
+func BenchmarkEngine_Sync(b *testing.B) {
+	for _, enabled := range []bool{true, false} {
+		name := "fsync"
+		if !enabled {
+			name = "nofsync"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			engine, err := Open(b.TempDir(), WithSync(enabled))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			defer engine.Close()
+
+			var (
+				mutex sync.Mutex
+				index uint64
+			)
+
+			b.SetParallelism(16)
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					mutex.Lock()
+					index++
+					engine.Write(index, Change{Key: protocol.Key{byte(index)}, Value: float64(index)})
+					mutex.Unlock()
+
+					if err := engine.Sync(); err != nil {
+						b.Error(err)
+
+						return
+					}
+				}
+			})
+		})
+	}
+}
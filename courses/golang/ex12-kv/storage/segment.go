@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/protocol"
+)
+
+const (
+	// RecordSize is size of record: log index, key, value and CRC-32C of
+	// them.
+	RecordSize = 8 + protocol.KeySize + 8 + 4
+
+	// headerSize is size of segment header: magic, version, flags and two
+	// reserved bytes.
+	headerSize = 4 + 1 + 1 + 2
+
+	version = 1
+
+	// base flags segment holding whole state, which supersedes every
+	// older segment.
+	base = 1
+
+	// more flags index of record followed by another record of the same
+	// entry.
+	more = 1 << 63
+
+	segmentSuffix   = ".seg"
+	temporarySuffix = ".tmp"
+)
+
+var (
+	magic = [4]byte{'k', 'v', 's', 'g'}
+
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// segment is file of the log. Only the active segment is appended, other
+// ones are sealed and change only by compaction.
+type segment struct {
+	id   uint32
+	file *os.File
+
+	// size is size of data written to file, and pending is data appended
+	// after it, which is written on sync.
+	size    int64
+	pending []byte
+
+	// records is number of records in segment, and dead is number of them
+	// superseded by newer records of the same key.
+	records int
+	dead    int
+}
+
+func segmentPath(dir string, id uint32) string {
+	return filepath.Join(dir, fmt.Sprintf("%010d%s", id, segmentSuffix))
+}
+
+func appendHeader(buffer []byte, flags byte) []byte {
+	buffer = append(buffer, magic[:]...)
+
+	return append(buffer, version, flags, 0, 0)
+}
+
+// readHeader returns flags of segment, or ok false if header is missing
+// or invalid.
+func readHeader(file *os.File) (byte, bool) {
+	var header [headerSize]byte
+
+	if _, err := file.ReadAt(header[:], 0); err != nil {
+		return 0, false
+	}
+
+	if [4]byte(header[:4]) != magic || header[4] != version {
+		return 0, false
+	}
+
+	return header[5], true
+}
+
+func appendRecord(buffer []byte, index uint64, key protocol.Key, value float64) []byte {
+	start := len(buffer)
+
+	buffer = binary.BigEndian.AppendUint64(buffer, index)
+	buffer = append(buffer, key[:]...)
+	buffer = binary.BigEndian.AppendUint64(buffer, math.Float64bits(value))
+
+	return binary.BigEndian.AppendUint32(buffer, crc32.Checksum(buffer[start:], castagnoli))
+}
+
+// decodeRecord decodes record, ok is false if its checksum doesn't match.
+func decodeRecord(record []byte) (index uint64, key protocol.Key, value float64, ok bool) {
+	checksum := binary.BigEndian.Uint32(record[RecordSize-4:])
+	if crc32.Checksum(record[:RecordSize-4], castagnoli) != checksum {
+		return 0, key, 0, false
+	}
+
+	index = binary.BigEndian.Uint64(record)
+	copy(key[:], record[8:])
+	value = math.Float64frombits(binary.BigEndian.Uint64(record[8+protocol.KeySize:]))
+
+	return index, key, value, true
+}
+
+// scan reads valid records of segment in order until visit returns false.
+// Records which are still pending are read too.
+func scan(
+	segment *segment,
+	visit func(offset int64, index uint64, key protocol.Key, value float64) bool,
+) error {
+	info, err := segment.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Header of new segment is pending until the first sync.
+	reader := bufio.NewReader(io.MultiReader(
+		io.NewSectionReader(segment.file, headerSize, max(info.Size()-headerSize, 0)),
+		bytes.NewReader(segment.pending[max(headerSize-info.Size(), 0):]),
+	))
+
+	var record [RecordSize]byte
+
+	offset := int64(headerSize)
+
+	for {
+		if _, err := io.ReadFull(reader, record[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+
+			return err
+		}
+
+		index, key, value, ok := decodeRecord(record[:])
+		if !ok || !visit(offset, index, key, value) {
+			return nil
+		}
+
+		offset += RecordSize
+	}
+}
+
+// syncDir flushes changes of directory entries, e.g. created or renamed
+// segments.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return file.Sync()
+}
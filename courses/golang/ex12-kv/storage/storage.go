@@ -0,0 +1,38 @@
+// Package storage persists state of the key-value FSM. Store is everything
+// the FSM needs from persistence, and Engine implements it as append-only
+// log of fixed-size records split into segments, with in-memory hash index
+// of keys, group commit of fsync, recovery which truncates torn tail and
+// background compaction.
+package storage
+
+import (
+	"github.com/actpohabtNS/hackademy/courses/golang/ex12-kv/protocol"
+)
+
+// Change is new value of key.
+type Change struct {
+	Key   protocol.Key
+	Value float64
+}
+
+// Store persists values of keys changed by raft log entries together with
+// index of the last such entry.
+type Store interface {
+	// Load reads persisted values and index of the last entry which
+	// changed them.
+	Load() (map[protocol.Key]float64, uint64, error)
+
+	// Write appends values changed by entry with specified index. Changes
+	// of single entry are recovered all together or not at all, and they
+	// are durable once Sync returns.
+	Write(index uint64, changes ...Change) error
+
+	// Sync makes every write made before it durable.
+	Sync() error
+
+	// Replace atomically replaces state with values applied up to
+	// specified index. It must not run concurrently with writes.
+	Replace(index uint64, values map[protocol.Key]float64) error
+
+	Close() error
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// raftMarker is the first byte sent over connections of raft transport,
+// which share port with clients. It's never valid operation, so server
+// tells raft connections from client ones by the first byte.
+const raftMarker = 0xff
+
+// dialer opens connection to address of other node.
+type dialer func(address string, timeout time.Duration) (net.Conn, error)
+
+func dialTCP(address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", address, timeout)
+}
+
+// stream is listener fed with connections accepted by server, which serves
+// as raft stream layer or listener of admin API. It keeps track of its
+// connections in both directions, so they're closed together with it.
+type stream struct {
+	address     net.Addr
+	connections chan net.Conn
+
+	// dial opens raft connections, it's nil for admin API which only
+	// accepts them.
+	dial dialer
+
+	mutex sync.Mutex
+	open  map[net.Conn]struct{}
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newStream(address string, dial dialer) (*stream, error) {
+	resolved, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stream{
+		address:     resolved,
+		connections: make(chan net.Conn),
+		dial:        dial,
+		open:        map[net.Conn]struct{}{},
+		closed:      make(chan struct{}),
+	}, nil
+}
+
+// push hands connection partially read by server over to raft, or closes
+// it if transport is closed.
+func (stream *stream) push(conn net.Conn, reader *bufio.Reader) {
+	tracked, err := stream.track(conn, reader)
+	if err != nil {
+		return
+	}
+
+	select {
+	case stream.connections <- tracked:
+	case <-stream.closed:
+		tracked.Close()
+	}
+}
+
+func (stream *stream) Accept() (net.Conn, error) {
+	select {
+	case conn := <-stream.connections:
+		return conn, nil
+	case <-stream.closed:
+		return nil, ErrClosed
+	}
+}
+
+func (stream *stream) Close() error {
+	stream.once.Do(func() {
+		close(stream.closed)
+	})
+
+	stream.drop()
+
+	return nil
+}
+
+func (stream *stream) Addr() net.Addr {
+	return stream.address
+}
+
+func (stream *stream) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conn, err := stream.dial(string(address), timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write([]byte{raftMarker}); err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	return stream.track(conn, nil)
+}
+
+func (stream *stream) track(conn net.Conn, reader *bufio.Reader) (net.Conn, error) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	select {
+	case <-stream.closed:
+		conn.Close()
+
+		return nil, ErrClosed
+	default:
+	}
+
+	stream.open[conn] = struct{}{}
+
+	return &streamConn{Conn: conn, reader: reader, stream: stream}, nil
+}
+
+// drop closes every raft connection.
+func (stream *stream) drop() {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	for conn := range stream.open {
+		conn.Close()
+	}
+}
+
+type streamConn struct {
+	net.Conn
+
+	// reader holds data read ahead by server, if any.
+	reader *bufio.Reader
+	stream *stream
+}
+
+func (conn *streamConn) Read(buffer []byte) (int, error) {
+	if conn.reader != nil {
+		return conn.reader.Read(buffer)
+	}
+
+	return conn.Conn.Read(buffer)
+}
+
+func (conn *streamConn) Close() error {
+	conn.stream.mutex.Lock()
+	delete(conn.stream.open, conn.Conn)
+	conn.stream.mutex.Unlock()
+
+	return conn.Conn.Close()
+}